@@ -0,0 +1,285 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Root directory for blacklisted agents.
+	BLACKLIST_DIRECTORY = "/blacklist/"
+
+	// Used when no refuse duration is configured for blacklisted agents.
+	defaultRefuse = time.Hour
+)
+
+type (
+	// Everything we know about an agent from the offers it has sent us.
+	Agent struct {
+		ID          string                `json:"id"`
+		Hostname    string                `json:"hostname"`
+		LastSeen    time.Time             `json:"lastSeen"`
+		Attributes  []*mesos_v1.Attribute `json:"attributes,omitempty"`
+		Resources   []*mesos_v1.Resource  `json:"resources,omitempty"`
+		Tasks       []string              `json:"tasks,omitempty"`
+		Blacklisted bool                  `json:"blacklisted"`
+		Quarantined []string              `json:"quarantined,omitempty"`
+	}
+
+	// An agent that operators have asked us to stay away from.
+	BlacklistEntry struct {
+		Hostname string    `json:"hostname"`
+		Reason   string    `json:"reason,omitempty"`
+		Added    time.Time `json:"added"`
+	}
+
+	// The inventory keeps track of every agent that has sent us offers.
+	// It also holds the persisted blacklist of agents whose offers should always be declined
+	// and the agents that are quarantined because tasks keep failing on them.
+	Inventory struct {
+		mutex       sync.RWMutex
+		agents      map[string]*Agent
+		blacklist   map[string]BlacklistEntry
+		refuse      time.Duration
+		policy      QuarantinePolicy
+		failures    map[string]map[string][]time.Time
+		quarantines map[string]map[string]Quarantine
+		storage     persistence.Storage
+		logger      logging.Logger
+	}
+)
+
+// Returns a new agent inventory.
+// Offers from blacklisted agents are declined for the refuse duration.
+func NewInventory(s persistence.Storage, refuse time.Duration, q QuarantinePolicy, l logging.Logger) *Inventory {
+	if refuse <= 0 {
+		refuse = defaultRefuse
+	}
+
+	return &Inventory{
+		agents:      make(map[string]*Agent),
+		blacklist:   make(map[string]BlacklistEntry),
+		refuse:      refuse,
+		policy:      q,
+		failures:    make(map[string]map[string][]time.Time),
+		quarantines: make(map[string]map[string]Quarantine),
+		storage:     s,
+		logger:      l,
+	}
+}
+
+// Loads the blacklist from storage, replacing whatever is currently in memory.
+func (i *Inventory) Restore(ctx context.Context) error {
+	var entries map[string]string
+	err := i.storage.Retry(ctx, func() error {
+		e, err := i.storage.ReadAll(BLACKLIST_DIRECTORY)
+		if err != nil {
+			i.logger.Emit(logging.ERROR, "Failed to read the agent blacklist: %s", err.Error())
+			return err
+		}
+
+		entries = e
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	blacklist := make(map[string]BlacklistEntry, len(entries))
+	for _, value := range entries {
+		var entry BlacklistEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			return err
+		}
+		blacklist[entry.Hostname] = entry
+	}
+
+	i.mutex.Lock()
+	i.blacklist = blacklist
+	i.mutex.Unlock()
+
+	return nil
+}
+
+// Records the agents and resources from a batch of offers.
+func (i *Inventory) Observe(offers []*mesos_v1.Offer) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, offer := range offers {
+		id := offer.GetAgentId().GetValue()
+		a, ok := i.agents[id]
+		if !ok {
+			a = &Agent{ID: id}
+			i.agents[id] = a
+		}
+
+		// An agent can send several offers at once so add them up instead of replacing them.
+		if !seen[id] {
+			a.Resources = nil
+			seen[id] = true
+		}
+		a.Hostname = offer.GetHostname()
+		a.Attributes = offer.GetAttributes()
+		a.Resources = append(a.Resources, offer.GetResources()...)
+		a.LastSeen = now
+	}
+}
+
+// Splits offers into those we can use and those from blacklisted agents.
+func (i *Inventory) Filter(offers []*mesos_v1.Offer) (allowed, blacklisted []*mesos_v1.Offer) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	for _, offer := range offers {
+		if _, ok := i.blacklist[offer.GetHostname()]; ok {
+			blacklisted = append(blacklisted, offer)
+			continue
+		}
+		allowed = append(allowed, offer)
+	}
+
+	return allowed, blacklisted
+}
+
+// How long Mesos should hold back offers from blacklisted agents.
+func (i *Inventory) RefuseSeconds() float64 {
+	return i.refuse.Seconds()
+}
+
+// Gets a copy of every known agent, sorted by hostname.
+func (i *Inventory) Agents() []Agent {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	now := time.Now()
+	agents := make([]Agent, 0, len(i.agents))
+	for _, a := range i.agents {
+		c := *a
+		_, c.Blacklisted = i.blacklist[a.Hostname]
+		for app, q := range i.quarantines[a.ID] {
+			if !now.Before(q.Until) {
+				continue
+			}
+			if app == "" {
+				app = "*"
+			}
+			c.Quarantined = append(c.Quarantined, app)
+		}
+		sort.Strings(c.Quarantined)
+		agents = append(agents, c)
+	}
+	sort.Sort(byHostname(agents))
+
+	return agents
+}
+
+// Persists and applies a blacklist entry for the given host.
+func (i *Inventory) Blacklist(ctx context.Context, hostname, reason string) error {
+	if hostname == "" {
+		return errors.New("Hostname is required")
+	}
+
+	entry := BlacklistEntry{Hostname: hostname, Reason: reason, Added: time.Now()}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	err = i.storage.Retry(ctx, func() error {
+		err := i.storage.Update(BLACKLIST_DIRECTORY+hostname, string(data))
+		if err != nil {
+			i.logger.Emit(logging.ERROR, "Failed to blacklist agent %s: %s", hostname, err.Error())
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	i.mutex.Lock()
+	i.blacklist[hostname] = entry
+	i.mutex.Unlock()
+
+	i.logger.Emit(logging.INFO, "Agent %s blacklisted: %s", hostname, reason)
+	return nil
+}
+
+// Removes the blacklist entry for the given host.
+func (i *Inventory) Unblacklist(ctx context.Context, hostname string) error {
+	i.mutex.RLock()
+	_, ok := i.blacklist[hostname]
+	i.mutex.RUnlock()
+	if !ok {
+		return errors.New("Agent " + hostname + " is not blacklisted")
+	}
+
+	err := i.storage.Retry(ctx, func() error {
+		err := i.storage.Delete(BLACKLIST_DIRECTORY + hostname)
+		if err != nil {
+			i.logger.Emit(logging.ERROR, "Failed to remove agent %s from the blacklist: %s", hostname, err.Error())
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	i.mutex.Lock()
+	delete(i.blacklist, hostname)
+	i.mutex.Unlock()
+
+	i.logger.Emit(logging.INFO, "Agent %s removed from the blacklist", hostname)
+	return nil
+}
+
+// Gets every blacklisted agent, sorted by hostname.
+func (i *Inventory) Blacklisted() []BlacklistEntry {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	entries := make([]BlacklistEntry, 0, len(i.blacklist))
+	for _, e := range i.blacklist {
+		entries = append(entries, e)
+	}
+	sort.Sort(entriesByHostname(entries))
+
+	return entries
+}
+
+type byHostname []Agent
+
+func (b byHostname) Len() int           { return len(b) }
+func (b byHostname) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byHostname) Less(i, j int) bool { return b[i].Hostname < b[j].Hostname }
+
+type entriesByHostname []BlacklistEntry
+
+func (b entriesByHostname) Len() int           { return len(b) }
+func (b entriesByHostname) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b entriesByHostname) Less(i, j int) bool { return b[i].Hostname < b[j].Hostname }
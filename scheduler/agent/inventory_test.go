@@ -0,0 +1,118 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"context"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"testing"
+	"time"
+)
+
+func offer(id, host string) *mesos_v1.Offer {
+	return &mesos_v1.Offer{
+		Id:       &mesos_v1.OfferID{Value: utils.ProtoString(id)},
+		AgentId:  &mesos_v1.AgentID{Value: utils.ProtoString(host)},
+		Hostname: utils.ProtoString(host),
+		Resources: []*mesos_v1.Resource{
+			{
+				Name:   utils.ProtoString("cpus"),
+				Type:   mesos_v1.Value_SCALAR.Enum(),
+				Scalar: &mesos_v1.Value_Scalar{Value: utils.ProtoFloat64(1)},
+			},
+		},
+	}
+}
+
+func TestNewInventory(t *testing.T) {
+	i := NewInventory(&mockStorage.MockStorage{}, 0, QuarantinePolicy{}, &mockLogger.MockLogger{})
+	if i.RefuseSeconds() != defaultRefuse.Seconds() {
+		t.Fatalf("Expected the default refuse duration, got %f", i.RefuseSeconds())
+	}
+
+	i = NewInventory(&mockStorage.MockStorage{}, time.Minute, QuarantinePolicy{}, &mockLogger.MockLogger{})
+	if i.RefuseSeconds() != 60 {
+		t.Fatalf("Expected a 60 second refuse duration, got %f", i.RefuseSeconds())
+	}
+}
+
+// Multiple offers from the same agent in one batch should be added together.
+func TestInventory_Observe(t *testing.T) {
+	i := NewInventory(&mockStorage.MockStorage{}, 0, QuarantinePolicy{}, &mockLogger.MockLogger{})
+	i.Observe([]*mesos_v1.Offer{offer("1", "b"), offer("2", "a"), offer("3", "a")})
+
+	agents := i.Agents()
+	if len(agents) != 2 || agents[0].Hostname != "a" {
+		t.Fatalf("Expected 2 agents sorted by hostname, got %v", agents)
+	}
+	if len(agents[0].Resources) != 2 {
+		t.Fatalf("Expected resources from both offers, got %v", agents[0].Resources)
+	}
+
+	// A new batch replaces what we knew about the agent's resources.
+	i.Observe([]*mesos_v1.Offer{offer("4", "a")})
+	if agents = i.Agents(); len(agents[0].Resources) != 1 {
+		t.Fatalf("Expected resources from the latest offer only, got %v", agents[0].Resources)
+	}
+}
+
+func TestInventory_Blacklist(t *testing.T) {
+	i := NewInventory(&mockStorage.MockStorage{}, 0, QuarantinePolicy{}, &mockLogger.MockLogger{})
+	if err := i.Blacklist(context.Background(), "", "no host"); err == nil {
+		t.Fatal("Blacklisting without a hostname should fail")
+	}
+	if err := i.Blacklist(context.Background(), "a", "bad disk"); err != nil {
+		t.Fatalf("Failed to blacklist agent: %s", err.Error())
+	}
+
+	i.Observe([]*mesos_v1.Offer{offer("1", "a"), offer("2", "b")})
+	allowed, blacklisted := i.Filter([]*mesos_v1.Offer{offer("1", "a"), offer("2", "b")})
+	if len(allowed) != 1 || allowed[0].GetHostname() != "b" {
+		t.Fatalf("Expected only agent b to be allowed, got %v", allowed)
+	}
+	if len(blacklisted) != 1 || blacklisted[0].GetHostname() != "a" {
+		t.Fatalf("Expected agent a to be blacklisted, got %v", blacklisted)
+	}
+	if agents := i.Agents(); !agents[0].Blacklisted || agents[1].Blacklisted {
+		t.Fatalf("Expected only agent a to be marked as blacklisted, got %v", agents)
+	}
+
+	if err := i.Unblacklist(context.Background(), "a"); err != nil {
+		t.Fatalf("Failed to remove agent from the blacklist: %s", err.Error())
+	}
+	if err := i.Unblacklist(context.Background(), "a"); err == nil {
+		t.Fatal("Removing an agent that isn't blacklisted should fail")
+	}
+	if len(i.Blacklisted()) != 0 {
+		t.Fatal("Blacklist should be empty")
+	}
+}
+
+// Storage failures should leave the in-memory blacklist untouched.
+func TestInventory_BlacklistStorageFailure(t *testing.T) {
+	i := NewInventory(&mockStorage.MockBrokenStorage{}, 0, QuarantinePolicy{}, &mockLogger.MockLogger{})
+	if err := i.Blacklist(context.Background(), "a", ""); err == nil {
+		t.Fatal("Blacklisting should fail when storage is broken")
+	}
+	if len(i.Blacklisted()) != 0 {
+		t.Fatal("Blacklist should be empty after a storage failure")
+	}
+	if err := i.Restore(context.Background()); err == nil {
+		t.Fatal("Restoring should fail when storage is broken")
+	}
+}
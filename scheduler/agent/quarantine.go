@@ -0,0 +1,185 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"sort"
+	"time"
+)
+
+type (
+	// Controls when agents are quarantined after repeated task failures.
+	// A threshold of zero disables quarantining entirely.
+	QuarantinePolicy struct {
+		Threshold int           // Failures of one app on one agent before the agent is avoided for that app.
+		Window    time.Duration // Failures older than this are forgotten.
+		Cooldown  time.Duration // How long a quarantine lasts.
+		Apps      int           // Quarantined apps on one agent before the agent is avoided for every app, zero disables.
+	}
+
+	// An agent that is being avoided because tasks keep failing on it.
+	// An empty app means the agent is avoided for every app.
+	Quarantine struct {
+		AgentID  string    `json:"agentId"`
+		Hostname string    `json:"hostname,omitempty"`
+		App      string    `json:"app,omitempty"`
+		Failures int       `json:"failures"`
+		Until    time.Time `json:"until"`
+	}
+)
+
+//
+// Fail records a task failure for an app on the given agent.
+// Once the app has failed often enough within the window the agent is quarantined for that app.
+// If enough apps end up quarantined on the same agent then the agent is quarantined for all apps.
+//
+func (i *Inventory) Fail(agentID, app string) {
+	if i.policy.Threshold <= 0 || agentID == "" {
+		return
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := time.Now()
+	if i.failures[agentID] == nil {
+		i.failures[agentID] = make(map[string][]time.Time)
+	}
+
+	// Only keep failures that are still inside the window.
+	recent := []time.Time{}
+	for _, t := range i.failures[agentID][app] {
+		if now.Sub(t) < i.policy.Window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	i.failures[agentID][app] = recent
+
+	if len(recent) < i.policy.Threshold || i.quarantined(agentID, app, now) {
+		return
+	}
+
+	hostname := agentID
+	if a, ok := i.agents[agentID]; ok && a.Hostname != "" {
+		hostname = a.Hostname
+	}
+
+	i.quarantine(Quarantine{AgentID: agentID, App: app, Failures: len(recent), Until: now.Add(i.policy.Cooldown)})
+	delete(i.failures[agentID], app)
+	i.logger.Emit(
+		logging.ALARM,
+		"Agent %s quarantined for app %s after %d failures, avoiding it until %s",
+		hostname,
+		app,
+		len(recent),
+		now.Add(i.policy.Cooldown).Format(time.RFC3339),
+	)
+
+	if i.policy.Apps <= 0 {
+		return
+	}
+
+	apps := 0
+	for name, q := range i.quarantines[agentID] {
+		if name != "" && now.Before(q.Until) {
+			apps++
+		}
+	}
+	if apps >= i.policy.Apps {
+		i.quarantine(Quarantine{AgentID: agentID, Failures: apps, Until: now.Add(i.policy.Cooldown)})
+		i.logger.Emit(
+			logging.ALARM,
+			"Agent %s quarantined for all apps after %d apps kept failing on it, avoiding it until %s",
+			hostname,
+			apps,
+			now.Add(i.policy.Cooldown).Format(time.RFC3339),
+		)
+	}
+}
+
+// Determines if the agent should be avoided for the given app.
+func (i *Inventory) Quarantined(agentID, app string) bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.quarantined(agentID, app, time.Now())
+}
+
+// Gets every active quarantine, sorted by hostname and then app.
+func (i *Inventory) Quarantines() []Quarantine {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	now := time.Now()
+	quarantines := []Quarantine{}
+	for agentID, apps := range i.quarantines {
+		for app, q := range apps {
+			if !now.Before(q.Until) {
+				// The cool-down is over, the agent can be used again.
+				delete(apps, app)
+				continue
+			}
+
+			if a, ok := i.agents[agentID]; ok {
+				q.Hostname = a.Hostname
+			}
+			quarantines = append(quarantines, q)
+		}
+		if len(apps) == 0 {
+			delete(i.quarantines, agentID)
+		}
+	}
+	sort.Sort(byQuarantine(quarantines))
+
+	return quarantines
+}
+
+// Must be called with the lock held.
+func (i *Inventory) quarantined(agentID, app string, now time.Time) bool {
+	apps := i.quarantines[agentID]
+	if q, ok := apps[""]; ok && now.Before(q.Until) {
+		return true
+	}
+	if q, ok := apps[app]; ok && now.Before(q.Until) {
+		return true
+	}
+
+	return false
+}
+
+// Must be called with the lock held.
+func (i *Inventory) quarantine(q Quarantine) {
+	if i.quarantines[q.AgentID] == nil {
+		i.quarantines[q.AgentID] = make(map[string]Quarantine)
+	}
+	i.quarantines[q.AgentID][q.App] = q
+}
+
+type byQuarantine []Quarantine
+
+func (b byQuarantine) Len() int      { return len(b) }
+func (b byQuarantine) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byQuarantine) Less(i, j int) bool {
+	if b[i].Hostname != b[j].Hostname {
+		return b[i].Hostname < b[j].Hostname
+	}
+	if b[i].AgentID != b[j].AgentID {
+		return b[i].AgentID < b[j].AgentID
+	}
+
+	return b[i].App < b[j].App
+}
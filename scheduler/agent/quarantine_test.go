@@ -0,0 +1,102 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agent
+
+import (
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"testing"
+	"time"
+)
+
+func quarantineFixture(threshold, apps int) *Inventory {
+	return NewInventory(&mockStorage.MockStorage{}, 0, QuarantinePolicy{
+		Threshold: threshold,
+		Window:    time.Minute,
+		Cooldown:  time.Minute,
+		Apps:      apps,
+	}, &mockLogger.MockLogger{})
+}
+
+func TestInventory_QuarantineDisabled(t *testing.T) {
+	i := quarantineFixture(0, 0)
+	for n := 0; n < 10; n++ {
+		i.Fail("a", "app")
+	}
+	if i.Quarantined("a", "app") {
+		t.Fatal("Agents should never be quarantined when the threshold is 0")
+	}
+}
+
+// Only the app that keeps failing should avoid the agent.
+func TestInventory_QuarantineApp(t *testing.T) {
+	i := quarantineFixture(2, 0)
+	i.Observe([]*mesos_v1.Offer{offer("1", "a")})
+
+	i.Fail("a", "app")
+	if i.Quarantined("a", "app") {
+		t.Fatal("Agent should not be quarantined before the threshold")
+	}
+	i.Fail("a", "app")
+	if !i.Quarantined("a", "app") {
+		t.Fatal("Agent should be quarantined for the failing app")
+	}
+	if i.Quarantined("a", "other") || i.Quarantined("b", "app") {
+		t.Fatal("Quarantine should only apply to the failing app on the failing agent")
+	}
+
+	q := i.Quarantines()
+	if len(q) != 1 || q[0].Hostname != "a" || q[0].App != "app" || q[0].Failures != 2 {
+		t.Fatalf("Expected a single quarantine for app on agent a, got %v", q)
+	}
+	if agents := i.Agents(); len(agents[0].Quarantined) != 1 || agents[0].Quarantined[0] != "app" {
+		t.Fatalf("Expected the agent to list the quarantined app, got %v", agents[0].Quarantined)
+	}
+}
+
+// Enough quarantined apps on one agent should take the agent out for everyone.
+func TestInventory_QuarantineAllApps(t *testing.T) {
+	i := quarantineFixture(1, 2)
+	i.Fail("a", "one")
+	if i.Quarantined("a", "three") {
+		t.Fatal("Agent should not be quarantined for all apps yet")
+	}
+	i.Fail("a", "two")
+	if !i.Quarantined("a", "three") {
+		t.Fatal("Agent should be quarantined for all apps")
+	}
+	if q := i.Quarantines(); len(q) != 3 || q[0].App != "" {
+		t.Fatalf("Expected the agent-wide quarantine to be listed first, got %v", q)
+	}
+}
+
+// Failures outside the window and expired quarantines should be forgotten.
+func TestInventory_QuarantineExpiry(t *testing.T) {
+	i := quarantineFixture(2, 0)
+	i.failures["a"] = map[string][]time.Time{"app": {time.Now().Add(-time.Hour)}}
+	i.Fail("a", "app")
+	if i.Quarantined("a", "app") {
+		t.Fatal("Failures outside the window should not count")
+	}
+
+	i.quarantine(Quarantine{AgentID: "a", App: "app", Until: time.Now().Add(-time.Second)})
+	if i.Quarantined("a", "app") {
+		t.Fatal("Quarantine should be lifted after the cool-down")
+	}
+	if q := i.Quarantines(); len(q) != 0 {
+		t.Fatalf("Expected expired quarantines to be removed, got %v", q)
+	}
+}
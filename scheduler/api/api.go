@@ -15,28 +15,56 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 	sched "github.com/verizonlabs/hydrogen/scheduler"
 	apiManager "github.com/verizonlabs/hydrogen/scheduler/api/manager"
 	"github.com/verizonlabs/hydrogen/scheduler/api/v1"
+	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/journal"
 )
 
 // API server provides an interface for users to interact with the core scheduler.
 type ApiServer struct {
-	cfg     *sched.Configuration
-	manager apiManager.ApiParser
-	logger  logging.Logger
+	cfg       *sched.Configuration
+	manager   apiManager.ApiParser
+	ha        *ha.HA
+	peer      *http.Server
+	transport http.RoundTripper
+	journal   *journal.Journal
+	logger    logging.Logger
+	routes    sync.Once
 }
 
 // Returns a new API server injected with the necessary components.
-func NewApiServer(cfg *sched.Configuration, mgr apiManager.ApiParser, lgr logging.Logger) *ApiServer {
-	return &ApiServer{
+// Requests are only journaled if a journal is given.
+// Standbys send anything that changes state to the leader, authenticating each other with TLS if it's given.
+func NewApiServer(cfg *sched.Configuration, mgr apiManager.ApiParser, h *ha.HA, peer *tls.Config, j *journal.Journal, lgr logging.Logger) *ApiServer {
+	a := &ApiServer{
 		cfg:     cfg,
 		manager: mgr,
+		ha:      h,
+		journal: j,
 		logger:  lgr,
 	}
+
+	if peer != nil {
+		a.transport = &http.Transport{TLSClientConfig: peer}
+		a.peer = &http.Server{
+			Addr:      ":" + strconv.Itoa(cfg.Leader.Port),
+			Handler:   a.authenticate(cfg.APIServer.Server.Mux()),
+			TLSConfig: peer,
+			ErrorLog:  log.New(logWriter{lgr}, "", 0),
+		}
+	}
+
+	return a
 }
 
 // Registers an HTTP handler to a given path.
@@ -44,11 +72,17 @@ func NewApiServer(cfg *sched.Configuration, mgr apiManager.ApiParser, lgr loggin
 func (a *ApiServer) applyRoute(path string, route v1.Route) {
 	mux := a.cfg.APIServer.Server.Mux()
 
+	handler := route.Handler
+	if a.journal != nil {
+		handler = a.journal.Record(handler)
+	}
+	handler = a.forward(handler)
+
 	// Apply middleware to determine if the HTTP method is allowed or not for each endpoint.
 	mux.HandleFunc(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		for _, method := range route.Methods {
 			if method == r.Method {
-				route.Handler(w, r)
+				handler(w, r)
 			}
 		}
 	}))
@@ -66,19 +100,33 @@ func (a *ApiServer) applyRoutes(version string) {
 }
 
 // RunAPI runs the server, optionally using TLS.
+// Returns once the server has been shut down.
 func (a *ApiServer) RunAPI(handlers map[string]http.HandlerFunc) {
-	a.applyRoutes(a.cfg.APIServer.Version)
+	a.routes.Do(func() {
+		a.applyRoutes(a.cfg.APIServer.Version)
+	})
 	apiSrvCfg := a.cfg.APIServer.Server
 
 	if apiSrvCfg.TLS() {
-		if err := apiSrvCfg.Server().ListenAndServeTLS(apiSrvCfg.Cert(), apiSrvCfg.Key()); err != nil {
+		if err := apiSrvCfg.Server().ListenAndServeTLS(apiSrvCfg.Cert(), apiSrvCfg.Key()); err != http.ErrServerClosed {
 			a.logger.Emit(logging.ERROR, err.Error())
 			os.Exit(7)
 		}
 	} else {
-		if err := apiSrvCfg.Server().ListenAndServe(); err != nil {
+		if err := apiSrvCfg.Server().ListenAndServe(); err != http.ErrServerClosed {
 			a.logger.Emit(logging.ERROR, err.Error())
 			os.Exit(7)
 		}
 	}
 }
+
+// Stops taking new requests and waits for the ones in flight to finish, giving up once the context is done.
+func (a *ApiServer) Shutdown(ctx context.Context) error {
+	if a.peer != nil {
+		if err := a.peer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	return a.cfg.APIServer.Server.Server().Shutdown(ctx)
+}
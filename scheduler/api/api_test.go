@@ -15,11 +15,14 @@
 package api
 
 import (
+	"context"
 	"errors"
 	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"github.com/verizonlabs/mesos-framework-sdk/server"
 	"github.com/verizonlabs/hydrogen/scheduler"
 	mockApiManager "github.com/verizonlabs/hydrogen/scheduler/api/manager/test"
 	"testing"
+	"time"
 )
 
 type brokenReader struct{}
@@ -36,8 +39,39 @@ var (
 
 // Ensures all components are set correctly when creating the API server.
 func TestNewApiServer(t *testing.T) {
-	srv := NewApiServer(c, apiMgr, l)
-	if srv.cfg != c || srv.manager != apiMgr || srv.logger != l {
+	srv := NewApiServer(c, apiMgr, nil, nil, nil, l)
+	if srv.cfg != c || srv.manager != apiMgr || srv.ha != nil || srv.journal != nil || srv.logger != l {
 		t.Fatal("API does not contain the correct components")
 	}
 }
+
+// Shutting down the API lets RunAPI return instead of exiting.
+func TestApiServer_Shutdown(t *testing.T) {
+	cfg := &scheduler.Configuration{APIServer: &scheduler.ApiConfiguration{
+		Server:  server.NewConfiguration("", "", "", 0),
+		Version: "v1",
+	}}
+	srv := NewApiServer(cfg, apiMgr, nil, nil, nil, l)
+
+	done := make(chan struct{})
+	go func() {
+		srv.RunAPI(nil)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("RunAPI didn't return after shutting down")
+		}
+	}
+}
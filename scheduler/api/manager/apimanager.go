@@ -15,8 +15,17 @@
 package manager
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/scheduler/framework"
+	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/master"
+	"github.com/verizonlabs/hydrogen/task/ipam"
+	"github.com/verizonlabs/hydrogen/task/persistence/backup"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	r "github.com/verizonlabs/mesos-framework-sdk/resources/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/task"
@@ -27,10 +36,37 @@ import (
 type (
 	ApiParser interface {
 		Deploy([]byte) ([]*t.Task, error)
-		Kill([]byte) (string, error)
+		Kill(context.Context, []byte) (string, error)
 		Update([]byte) ([]*t.Task, error)
 		Status(string) (*t.Task, error)
 		AllTasks() ([]*t.Task, error)
+		Queue() ([]queue.Entry, error)
+		Agents() []agent.Agent
+		Blacklist() []agent.BlacklistEntry
+		BlacklistAgent(context.Context, []byte) (string, error)
+		UnblacklistAgent(context.Context, []byte) (string, error)
+		Quarantines() []agent.Quarantine
+		Pools() []ipam.Pool
+		CreatePool(context.Context, []byte) (string, error)
+		DeletePool(context.Context, []byte) (string, error)
+		Backup(context.Context) (*backup.Archive, error)
+		Consistency(context.Context, bool) (*consistency.Report, error)
+		Orphans() []consistency.Orphan
+		Leader(context.Context) (*ha.Leader, error)
+		StepDown([]byte) (string, error)
+		Mesos() master.Status
+		Teardown() (string, error)
+	}
+
+	// Optional request body used to choose who takes over when the leader steps down.
+	StepDownJSON struct {
+		Successor string `json:"successor"`
+	}
+
+	// Request body used to add or remove an agent from the blacklist.
+	BlacklistJSON struct {
+		Hostname string `json:"hostname"`
+		Reason   string `json:"reason"`
 	}
 
 	Parser struct {
@@ -38,21 +74,56 @@ type (
 		resourceManager r.ResourceManager
 		taskManager     t.TaskManager
 		scheduler       scheduler.Scheduler
+		queue           *queue.LaunchQueue
+		inventory       *agent.Inventory
+		pools           *ipam.Pools
+		backup          *backup.Backup
+		consistency     *consistency.Checker
+		orphans         *consistency.Orphans
+		ha              *ha.HA
+		master          *master.Client
+		teardown        *framework.Teardown
 	}
 )
 
 // NewApiParser returns an object that marshalls JSON and handles the input from the API endpoints.
-func NewApiParser(r r.ResourceManager, t t.TaskManager, s scheduler.Scheduler) *Parser {
+func NewApiParser(
+	r r.ResourceManager,
+	t t.TaskManager,
+	s scheduler.Scheduler,
+	q *queue.LaunchQueue,
+	a *agent.Inventory,
+	i *ipam.Pools,
+	b *backup.Backup,
+	k *consistency.Checker,
+	o *consistency.Orphans,
+	h *ha.HA,
+	mc *master.Client,
+	d *framework.Teardown) *Parser {
+
 	return &Parser{
 		resourceManager: r,
 		taskManager:     t,
 		scheduler:       s,
+		queue:           q,
+		inventory:       a,
+		pools:           i,
+		backup:          b,
+		consistency:     k,
+		orphans:         o,
+		ha:              h,
+		master:          mc,
+		teardown:        d,
 	}
 }
 
 // Deploy takes a slice of bytes and marshals them into a Application json struct.
 func (m *Parser) Deploy(decoded []byte) ([]*t.Task, error) {
-	var appJSON []*task.ApplicationJSON
+	if m.teardown.Running() {
+		return nil, framework.ErrTearingDown
+	}
+
+	var appJSON []*builder.ApplicationJSON
 	err := json.Unmarshal(decoded, &appJSON)
 	if err != nil {
 		return nil, err
@@ -62,23 +133,38 @@ func (m *Parser) Deploy(decoded []byte) ([]*t.Task, error) {
 		return nil, errors.New("No valid application passed in.")
 	}
 
-	mesosTasks, err := builder.Application(appJSON...)
+	mesosTasks, err := builder.Applications(appJSON...)
 	if err != nil {
 		return nil, err
 	}
 
+	for i, app := range appJSON {
+		err = m.pools.Attach(mesosTasks[i].Info, app.IpPools)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = m.taskManager.Add(mesosTasks...)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, task := range mesosTasks {
+		m.queue.Enqueue(task.Info.GetName())
+	}
+
 	m.scheduler.Revive()
 	return mesosTasks, nil
 }
 
 // Update takes a slice of bytes and marshalls them into an ApplicationJSON struct.
 func (m *Parser) Update(decoded []byte) ([]*t.Task, error) {
-	var appJSON task.ApplicationJSON
+	if m.teardown.Running() {
+		return nil, framework.ErrTearingDown
+	}
+
+	var appJSON builder.ApplicationJSON
 	err := json.Unmarshal(decoded, &appJSON)
 	if err != nil {
 		return nil, err
@@ -89,7 +175,12 @@ func (m *Parser) Update(decoded []byte) ([]*t.Task, error) {
 		return nil, err
 	}
 
-	mesosTask, err := builder.Application(&appJSON)
+	mesosTask, err := builder.Applications(&appJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.pools.Attach(mesosTask[0].Info, appJSON.IpPools)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +193,7 @@ func (m *Parser) Update(decoded []byte) ([]*t.Task, error) {
 }
 
 // Kill takes a slice of bytes and marshalls them into a kill json struct.
-func (m *Parser) Kill(decoded []byte) (string, error) {
+func (m *Parser) Kill(ctx context.Context, decoded []byte) (string, error) {
 	var appJSON task.KillJson
 	err := json.Unmarshal(decoded, &appJSON)
 	if err != nil {
@@ -124,9 +215,13 @@ func (m *Parser) Kill(decoded []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	m.queue.Remove(tsk.Info.GetName())
 
 	// If we are "unknown" that means the master doesn't know about the task, no need to make an HTTP call.
-	if tsk.State != t.UNKNOWN {
+	// A task that was launched keeps its addresses until Mesos reports it has stopped.
+	if tsk.State == t.UNKNOWN {
+		m.pools.Release(ctx, tsk.Info.GetTaskId().GetValue())
+	} else {
 		_, err := m.scheduler.Kill(tsk.Info.GetTaskId(), tsk.Info.GetAgentId())
 		if err != nil {
 			return "", err
@@ -153,3 +248,173 @@ func (m *Parser) AllTasks() ([]*t.Task, error) {
 
 	return tasks, nil
 }
+
+// Queue gathers every task that's waiting to be launched along with why it hasn't been launched yet.
+func (m *Parser) Queue() ([]queue.Entry, error) {
+	waiting, err := m.taskManager.AllByState(t.UNKNOWN)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]queue.Entry, 0, len(waiting))
+	for _, tsk := range waiting {
+		name := tsk.Info.GetName()
+		m.queue.Enqueue(name) // Start the clock for tasks that haven't seen an offer cycle yet.
+
+		entry, _ := m.queue.Get(name)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Agents gathers every agent we've received offers from along with the tasks we've placed on them.
+func (m *Parser) Agents() []agent.Agent {
+	agents := m.inventory.Agents()
+
+	tasks, err := m.taskManager.All()
+	if err != nil {
+		// An empty task manager just means nothing has been placed yet.
+		return agents
+	}
+
+	placed := make(map[string][]string)
+	for _, tsk := range tasks {
+		id := tsk.Info.GetAgentId().GetValue()
+		if id == "" {
+			continue
+		}
+		placed[id] = append(placed[id], tsk.Info.GetName())
+	}
+
+	for i := range agents {
+		agents[i].Tasks = placed[agents[i].ID]
+	}
+
+	return agents
+}
+
+// Blacklist gathers every agent that operators have blacklisted.
+func (m *Parser) Blacklist() []agent.BlacklistEntry {
+	return m.inventory.Blacklisted()
+}
+
+// BlacklistAgent takes a slice of bytes and marshals them into a blacklist json struct.
+func (m *Parser) BlacklistAgent(ctx context.Context, decoded []byte) (string, error) {
+	var blacklistJSON BlacklistJSON
+	err := json.Unmarshal(decoded, &blacklistJSON)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.inventory.Blacklist(ctx, blacklistJSON.Hostname, blacklistJSON.Reason)
+	if err != nil {
+		return "", err
+	}
+
+	return blacklistJSON.Hostname, nil
+}
+
+// UnblacklistAgent takes a slice of bytes and marshals them into a blacklist json struct.
+func (m *Parser) UnblacklistAgent(ctx context.Context, decoded []byte) (string, error) {
+	var blacklistJSON BlacklistJSON
+	err := json.Unmarshal(decoded, &blacklistJSON)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.inventory.Unblacklist(ctx, blacklistJSON.Hostname)
+	if err != nil {
+		return "", err
+	}
+
+	return blacklistJSON.Hostname, nil
+}
+
+// Quarantines gathers every agent that is being avoided because tasks keep failing on it.
+func (m *Parser) Quarantines() []agent.Quarantine {
+	return m.inventory.Quarantines()
+}
+
+// Pools gathers every IP pool along with the addresses handed out from it.
+func (m *Parser) Pools() []ipam.Pool {
+	return m.pools.All()
+}
+
+// CreatePool takes a slice of bytes and marshals them into a pool struct.
+func (m *Parser) CreatePool(ctx context.Context, decoded []byte) (string, error) {
+	var pool ipam.Pool
+	err := json.Unmarshal(decoded, &pool)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.pools.Create(ctx, pool)
+	if err != nil {
+		return "", err
+	}
+
+	return pool.Name, nil
+}
+
+// DeletePool takes a slice of bytes and marshals them into a pool struct.
+func (m *Parser) DeletePool(ctx context.Context, decoded []byte) (string, error) {
+	var pool ipam.Pool
+	err := json.Unmarshal(decoded, &pool)
+	if err != nil {
+		return "", err
+	}
+
+	err = m.pools.Delete(ctx, pool.Name)
+	if err != nil {
+		return "", err
+	}
+
+	return pool.Name, nil
+}
+
+// Backup takes a snapshot of everything the scheduler has persisted.
+func (m *Parser) Backup(ctx context.Context) (*backup.Archive, error) {
+	return m.backup.Export(ctx)
+}
+
+// Consistency checks memory, storage and Mesos against each other, repairing any discrepancies if asked to.
+func (m *Parser) Consistency(ctx context.Context, repair bool) (*consistency.Report, error) {
+	return m.consistency.Check(ctx, repair)
+}
+
+// Orphans gets the tasks that Mesos runs for us but that we don't know about.
+func (m *Parser) Orphans() []consistency.Orphan {
+	return m.orphans.All()
+}
+
+// Leader gets who's currently leading, or nil if nobody has been elected yet.
+func (m *Parser) Leader(ctx context.Context) (*ha.Leader, error) {
+	return m.ha.GetLeader(ctx)
+}
+
+// StepDown asks the leader to hand over to the given successor, or to any standby if none was given.
+func (m *Parser) StepDown(decoded []byte) (string, error) {
+	var stepDown StepDownJSON
+	if len(decoded) > 0 {
+		if err := json.Unmarshal(decoded, &stepDown); err != nil {
+			return "", err
+		}
+	}
+
+	if err := m.ha.StepDown(stepDown.Successor); err != nil {
+		return "", err
+	}
+
+	return stepDown.Successor, nil
+}
+
+// Mesos reports which Mesos master we're talking to and how our subscription is doing.
+func (m *Parser) Mesos() master.Status {
+	return m.master.Status()
+}
+
+// Teardown kills every task and retires our framework once they've all stopped, returning its ID.
+func (m *Parser) Teardown() (string, error) {
+	return m.teardown.Run()
+}
@@ -15,17 +15,61 @@
 package manager
 
 import (
+	"context"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
 	k "github.com/verizonlabs/mesos-framework-sdk/resources/manager/test"
 	s "github.com/verizonlabs/mesos-framework-sdk/scheduler/test"
+	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/auth"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/scheduler/framework"
+	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/master"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	"github.com/verizonlabs/hydrogen/task/manager/test"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/backup"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"testing"
+	"time"
 )
 
 // Generate valid and invalid JSON
 
+func inventory() *agent.Inventory {
+	return agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{})
+}
+
+func backups() *backup.Backup {
+	return backup.NewBackup(&mockStorage.MockStorage{}, &mockLogger.MockLogger{})
+}
+
+func checker() *consistency.Checker {
+	return consistency.NewChecker(test.MockTaskManager{}, &mockStorage.MockStorage{}, s.MockScheduler{}, 0, &mockLogger.MockLogger{})
+}
+
+func orphans() *consistency.Orphans {
+	return consistency.NewOrphans(s.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{})
+}
+
+func leadership(data map[string]string) *ha.HA {
+	kv := &mockStorage.MockFailingKVStore{Data: data}
+	return ha.NewHA(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), &mockLogger.MockLogger{}, &scheduler.LeaderConfiguration{})
+}
+
+func teardown() *framework.Teardown {
+	return framework.NewTeardown(context.Background(), test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), pools(), &mockStorage.MockStorage{}, 0, &mockLogger.MockLogger{})
+}
+
+func pools() *ipam.Pools {
+	return ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{})
+}
+
 func TestNewApiParser(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	if api.resourceManager == nil || api.scheduler == nil || api.taskManager == nil {
 		t.Logf("Expected instances to be set %v\n", api)
 		t.Fail()
@@ -33,7 +77,7 @@ func TestNewApiParser(t *testing.T) {
 }
 
 func TestParser_DeployNoHealthCheck(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	validJSON := `[{"name": "test",
 	"instances": 1,
 	"resources": {"cpu": 0.5, "mem": 128.0, "disk": {"size": 1024.0}},
@@ -53,7 +97,7 @@ func TestParser_DeployNoHealthCheck(t *testing.T) {
 }
 
 func TestParser_DeployWithTCPHealthCheck(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	validJSON := `[{"name": "test",
 	"instances": 1,
 	"resources": {"cpu": 0.5, "mem": 128.0, "disk": {"size": 1024.0}},
@@ -88,7 +132,7 @@ func TestParser_DeployWithTCPHealthCheck(t *testing.T) {
 }
 
 func TestParser_DeployWithNoName(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	invalidJSON := `{"instances": 1,
 	"resources": {"cpu": 0.5, "mem": 128.0, "disk": {"size": 1024.0}},
 	"command": {"cmd": "echo hello"}`
@@ -100,7 +144,7 @@ func TestParser_DeployWithNoName(t *testing.T) {
 }
 
 func TestParser_DeployWithNoResources(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	invalidJSON := `{"name": "no-resources",
 	"instances": 1,
 	"command": {"cmd": "echo hello"}`
@@ -112,7 +156,7 @@ func TestParser_DeployWithNoResources(t *testing.T) {
 }
 
 func TestParser_DeployWithCNINetwork(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	validJSON := `[{"name": "tester",
 	"instances": 1,
 	"resources": {"cpu": 0.5, "mem": 128.0, "disk": {"size": 1024.0}},
@@ -131,7 +175,7 @@ func TestParser_DeployWithCNINetwork(t *testing.T) {
 }
 
 func TestParser_DeployWithIPNetwork(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	validJSON := `[{"name": "tester",
 	"instances": 1,
 	"resources": {"cpu": 0.5, "mem": 128.0, "disk": {"size": 1024.0}},
@@ -180,9 +224,9 @@ func TestParser_DeployWithIPNetwork(t *testing.T) {
 }
 
 func TestParser_Kill(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	validJSON := `{"name": "test"}`
-	status, err := api.Kill([]byte(validJSON))
+	status, err := api.Kill(context.Background(), []byte(validJSON))
 	if err != nil {
 		t.Logf("Failed %v\n", err)
 		t.Fail()
@@ -198,9 +242,9 @@ func TestParser_Kill(t *testing.T) {
 }
 
 func TestParser_KillFail(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	validJSON := `{"junk":"value"}`
-	status, err := api.Kill([]byte(validJSON))
+	status, err := api.Kill(context.Background(), []byte(validJSON))
 	if err == nil {
 		t.Logf("Application should of failed %v\n", err)
 		t.Fail()
@@ -211,7 +255,7 @@ func TestParser_KillFail(t *testing.T) {
 }
 
 func TestParser_AllTasks(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	tasks, err := api.AllTasks()
 	if err != nil {
 		t.Logf("Failed %v\n", err)
@@ -224,7 +268,7 @@ func TestParser_AllTasks(t *testing.T) {
 }
 
 func TestParser_Update(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	validJSON := `{"name": "test",
 	"instances": 1,
 	"resources": {"cpu": 0.5, "mem": 128.0, "disk": {"size": 1024.0}},
@@ -240,7 +284,7 @@ func TestParser_Update(t *testing.T) {
 }
 
 func TestParser_Status(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	task, err := api.Status("test")
 	if err != nil {
 		t.Logf("Failed on status update %v\n", task.State.String())
@@ -253,7 +297,7 @@ func TestParser_Status(t *testing.T) {
 }
 
 func TestParser_DeployMultiInstance(t *testing.T) {
-	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
 	multiInstance := `[{"name": "test",
 	"instances": 5,
 	"resources": {"cpu": 0.5, "mem": 128.0, "disk": {"size": 1024.0}},
@@ -265,3 +309,143 @@ func TestParser_DeployMultiInstance(t *testing.T) {
 	}
 
 }
+
+func TestParser_Queue(t *testing.T) {
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
+	entries, err := api.Queue()
+	if err != nil {
+		t.Logf("Failed to get the launch queue %v\n", err)
+		t.Fail()
+	}
+	if len(entries) != 0 {
+		t.Logf("Expected an empty queue, got %v entries", len(entries))
+		t.Fail()
+	}
+
+	api = NewApiParser(k.MockResourceManager{}, test.MockBrokenTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
+	if _, err := api.Queue(); err == nil {
+		t.Log("Expected an error from a broken task manager")
+		t.Fail()
+	}
+}
+
+func TestParser_Blacklist(t *testing.T) {
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
+	host, err := api.BlacklistAgent(context.Background(), []byte(`{"hostname": "host", "reason": "bad disk"}`))
+	if err != nil || host != "host" {
+		t.Logf("Failed to blacklist agent %v\n", err)
+		t.Fail()
+	}
+	if b := api.Blacklist(); len(b) != 1 || b[0].Reason != "bad disk" {
+		t.Logf("Expected a single blacklisted agent, got %v", b)
+		t.Fail()
+	}
+	if _, err := api.BlacklistAgent(context.Background(), []byte(`{"reason": "no host"}`)); err == nil {
+		t.Log("Blacklisting without a hostname should fail")
+		t.Fail()
+	}
+
+	if _, err := api.UnblacklistAgent(context.Background(), []byte(`{"hostname": "host"}`)); err != nil {
+		t.Logf("Failed to remove agent from the blacklist %v\n", err)
+		t.Fail()
+	}
+	if _, err := api.UnblacklistAgent(context.Background(), []byte(`{"hostname": "host"}`)); err == nil {
+		t.Log("Removing an agent that isn't blacklisted should fail")
+		t.Fail()
+	}
+}
+
+func TestParser_Agents(t *testing.T) {
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
+	if agents := api.Agents(); len(agents) != 0 {
+		t.Logf("Expected no agents before any offers, got %v", agents)
+		t.Fail()
+	}
+}
+
+func TestParser_Pools(t *testing.T) {
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
+	name, err := api.CreatePool(context.Background(), []byte(`{"name": "test", "network": "cni", "cidr": "10.0.0.0/24"}`))
+	if err != nil || name != "test" {
+		t.Logf("Failed to create pool %v\n", err)
+		t.Fail()
+	}
+	if p := api.Pools(); len(p) != 1 || p[0].Network != "cni" {
+		t.Logf("Expected a single pool, got %v", p)
+		t.Fail()
+	}
+	if _, err := api.CreatePool(context.Background(), []byte(`not json`)); err == nil {
+		t.Log("Creating a pool from junk JSON should fail")
+		t.Fail()
+	}
+
+	if _, err := api.DeletePool(context.Background(), []byte(`{"name": "test"}`)); err != nil {
+		t.Logf("Failed to delete pool %v\n", err)
+		t.Fail()
+	}
+	if _, err := api.DeletePool(context.Background(), []byte(`{"name": "test"}`)); err == nil {
+		t.Log("Deleting a pool that doesn't exist should fail")
+		t.Fail()
+	}
+}
+
+func TestParser_Leader(t *testing.T) {
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
+	leader, err := api.Leader(context.Background())
+	if err != nil || leader != nil {
+		t.Fatalf("Expected no leader before an election, got %+v and %v", leader, err)
+	}
+
+	api = NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{
+		"/leader": `{"id": "a", "ip": "10.0.0.1", "api": "http://10.0.0.1:8080", "token": 3}`,
+	}), nil, teardown())
+	leader, err = api.Leader(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if leader.API != "http://10.0.0.1:8080" || leader.Token != 3 {
+		t.Fatalf("Unexpected leader %+v", leader)
+	}
+}
+
+func TestParser_StepDown(t *testing.T) {
+	h := leadership(map[string]string{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), h, nil, teardown())
+	if _, err := api.StepDown(nil); err != ha.ErrNotLeader {
+		t.Fatalf("Only the leader can step down, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.Election(ctx)
+
+	if _, err := api.StepDown([]byte(`{"successor": `)); err == nil {
+		t.Fatal("Stepping down with junk JSON should fail")
+	}
+	successor, err := api.StepDown([]byte(`{"successor": "10.0.0.2"}`))
+	if err != nil || successor != "10.0.0.2" {
+		t.Fatalf("Expected to hand over to 10.0.0.2, got %q and %v", successor, err)
+	}
+	if <-h.SteppingDown() != "10.0.0.2" {
+		t.Fatal("The leader wasn't told who to hand over to")
+	}
+	if _, err := api.StepDown(nil); err != ha.ErrStepping {
+		t.Fatalf("Stepping down twice should fail, got %v", err)
+	}
+}
+
+func TestParser_Mesos(t *testing.T) {
+	mc := master.NewClient([]string{"http://10.0.0.1:5050/api/v1/scheduler"}, auth.NewBasic("", ""), time.Second, time.Minute, &mockLogger.MockLogger{})
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), mc, teardown())
+	status := api.Mesos()
+	if status.Master != "http://10.0.0.1:5050/api/v1/scheduler" || status.State != master.DISCONNECTED {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+}
+
+func TestParser_Teardown(t *testing.T) {
+	api := NewApiParser(k.MockResourceManager{}, test.MockTaskManager{}, s.MockScheduler{}, queue.NewLaunchQueue(1), inventory(), pools(), backups(), checker(), orphans(), leadership(map[string]string{}), nil, teardown())
+	if _, err := api.Teardown(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
@@ -15,7 +15,15 @@
 package test
 
 import (
+	"context"
 	"errors"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/master"
+	"github.com/verizonlabs/hydrogen/task/ipam"
+	"github.com/verizonlabs/hydrogen/task/persistence/backup"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/task"
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
@@ -32,7 +40,7 @@ func (m MockApiManager) Deploy([]byte) ([]*manager.Task, error) {
 		{Info: &mesos_v1.TaskInfo{}},
 	}, nil
 }
-func (m MockApiManager) Kill([]byte) (string, error) { return "", nil }
+func (m MockApiManager) Kill(context.Context, []byte) (string, error) { return "", nil }
 func (m MockApiManager) Update([]byte) ([]*manager.Task, error) {
 	return []*manager.Task{{Info: &mesos_v1.TaskInfo{}}}, nil
 }
@@ -50,10 +58,56 @@ func (m MockApiManager) AllTasks() ([]*manager.Task, error) {
 		manager.GroupInfo{})}, nil
 }
 
+func (m MockApiManager) Queue() ([]queue.Entry, error) {
+	return []queue.Entry{{Name: "test", Misses: []queue.Miss{{Reason: queue.NoOffers}}}}, nil
+}
+
+func (m MockApiManager) Agents() []agent.Agent {
+	return []agent.Agent{{ID: "id", Hostname: "host", Tasks: []string{"test"}}}
+}
+
+func (m MockApiManager) Blacklist() []agent.BlacklistEntry {
+	return []agent.BlacklistEntry{{Hostname: "host"}}
+}
+
+func (m MockApiManager) BlacklistAgent(context.Context, []byte) (string, error)   { return "host", nil }
+func (m MockApiManager) UnblacklistAgent(context.Context, []byte) (string, error) { return "host", nil }
+
+func (m MockApiManager) Quarantines() []agent.Quarantine {
+	return []agent.Quarantine{{AgentID: "id", Hostname: "host", App: "test", Failures: 3}}
+}
+
+func (m MockApiManager) Pools() []ipam.Pool {
+	return []ipam.Pool{{Name: "test", Network: "cni", CIDR: "10.0.0.0/24"}}
+}
+
+func (m MockApiManager) CreatePool(context.Context, []byte) (string, error) { return "test", nil }
+func (m MockApiManager) DeletePool(context.Context, []byte) (string, error) { return "test", nil }
+
+func (m MockApiManager) Backup(context.Context) (*backup.Archive, error) {
+	return &backup.Archive{Version: backup.VERSION, Tasks: map[string]string{}, Keys: map[string]string{}}, nil
+}
+func (m MockApiManager) Consistency(context.Context, bool) (*consistency.Report, error) {
+	return &consistency.Report{Discrepancies: []consistency.Discrepancy{}}, nil
+}
+func (m MockApiManager) Orphans() []consistency.Orphan {
+	return []consistency.Orphan{{TaskID: "orphan", AgentID: "agent"}}
+}
+func (m MockApiManager) Leader(context.Context) (*ha.Leader, error) {
+	return &ha.Leader{ID: "leader", IP: "127.0.0.1", API: "http://127.0.0.1:8080", Token: 1}, nil
+}
+func (m MockApiManager) StepDown([]byte) (string, error) { return "", nil }
+func (m MockApiManager) Mesos() master.Status {
+	return master.Status{Master: "http://127.0.0.1:5050/api/v1/scheduler", State: master.SUBSCRIBED}
+}
+func (m MockApiManager) Teardown() (string, error) { return "framework", nil }
+
 func (m MockBrokenApiManager) Deploy([]byte) ([]*manager.Task, error) {
 	return nil, errors.New("Broken")
 }
-func (m MockBrokenApiManager) Kill([]byte) (string, error) { return "", errors.New("Broken") }
+func (m MockBrokenApiManager) Kill(context.Context, []byte) (string, error) {
+	return "", errors.New("Broken")
+}
 func (m MockBrokenApiManager) Update([]byte) ([]*manager.Task, error) {
 	return nil, errors.New("Broken")
 }
@@ -63,3 +117,58 @@ func (m MockBrokenApiManager) Status(string) (*manager.Task, error) {
 func (m MockBrokenApiManager) AllTasks() ([]*manager.Task, error) {
 	return nil, errors.New("Broken")
 }
+func (m MockBrokenApiManager) Queue() ([]queue.Entry, error) {
+	return nil, errors.New("Broken")
+}
+
+func (m MockBrokenApiManager) Agents() []agent.Agent {
+	return []agent.Agent{}
+}
+
+func (m MockBrokenApiManager) Blacklist() []agent.BlacklistEntry {
+	return []agent.BlacklistEntry{}
+}
+
+func (m MockBrokenApiManager) BlacklistAgent(context.Context, []byte) (string, error) {
+	return "", errors.New("Broken")
+}
+
+func (m MockBrokenApiManager) UnblacklistAgent(context.Context, []byte) (string, error) {
+	return "", errors.New("Broken")
+}
+
+func (m MockBrokenApiManager) Quarantines() []agent.Quarantine {
+	return []agent.Quarantine{}
+}
+
+func (m MockBrokenApiManager) Pools() []ipam.Pool {
+	return []ipam.Pool{}
+}
+
+func (m MockBrokenApiManager) CreatePool(context.Context, []byte) (string, error) {
+	return "", errors.New("Broken")
+}
+
+func (m MockBrokenApiManager) DeletePool(context.Context, []byte) (string, error) {
+	return "", errors.New("Broken")
+}
+
+func (m MockBrokenApiManager) Backup(context.Context) (*backup.Archive, error) {
+	return nil, errors.New("Broken")
+}
+func (m MockBrokenApiManager) Consistency(context.Context, bool) (*consistency.Report, error) {
+	return nil, errors.New("Broken")
+}
+func (m MockBrokenApiManager) Orphans() []consistency.Orphan { return []consistency.Orphan{} }
+func (m MockBrokenApiManager) Leader(context.Context) (*ha.Leader, error) {
+	return nil, errors.New("Broken")
+}
+func (m MockBrokenApiManager) StepDown([]byte) (string, error) {
+	return "", errors.New("Broken")
+}
+func (m MockBrokenApiManager) Mesos() master.Status {
+	return master.Status{Master: "http://127.0.0.1:5050/api/v1/scheduler", State: master.DISCONNECTED, Failures: 1}
+}
+func (m MockBrokenApiManager) Teardown() (string, error) {
+	return "", errors.New("Broken")
+}
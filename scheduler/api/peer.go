@@ -0,0 +1,82 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/x509"
+	"github.com/verizonlabs/hydrogen/scheduler/api/v1"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//
+// RunPeer serves the same routes as the API to other instances, only letting in those with a certificate signed by our CA.
+// This is where standbys send requests while we're leading. Peers that fail the TLS handshake are logged and dropped.
+// Does nothing if instances don't authenticate each other. Returns once the server has been shut down.
+//
+func (a *ApiServer) RunPeer() {
+	if a.peer == nil {
+		return
+	}
+	a.routes.Do(func() {
+		a.applyRoutes(a.cfg.APIServer.Version)
+	})
+
+	if err := a.peer.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+		a.logger.Emit(logging.ERROR, err.Error())
+		os.Exit(7)
+	}
+}
+
+// Only lets requests through from peers with a verified certificate and logs who each one came from.
+func (a *ApiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			a.logger.Emit(logging.ERROR, "Rejected %s %s from unauthenticated peer %s", r.Method, r.URL.Path, r.RemoteAddr)
+			v1.Unauthorized(w, v1.MessageResponse{Message: "A certificate signed by our CA is required"})
+			return
+		}
+
+		a.logger.Emit(logging.INFO, "Accepted %s %s from peer %s at %s",
+			r.Method, r.URL.Path, identity(r.TLS.VerifiedChains[0][0]), r.RemoteAddr)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Describes who a certificate belongs to by its common name and the addresses it's valid for.
+func identity(cert *x509.Certificate) string {
+	names := []string{}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.DNSNames...)
+	if len(names) == 0 {
+		return cert.Subject.CommonName
+	}
+
+	return cert.Subject.CommonName + " (" + strings.Join(names, ", ") + ")"
+}
+
+// Sends what the HTTP server logs, such as failed TLS handshakes and who they came from, to our logger.
+type logWriter struct {
+	logger logging.Logger
+}
+
+func (l logWriter) Write(p []byte) (int, error) {
+	l.logger.Emit(logging.ERROR, "%s", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
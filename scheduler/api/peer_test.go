@@ -0,0 +1,122 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"crypto/tls"
+	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/ha/test"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/server"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// Loads the TLS configuration of an instance from a certificate and key signed by the CA.
+func peerTLS(t *testing.T, ca, cert, key string) *tls.Config {
+	config, err := ha.LoadTLS(&scheduler.LeaderConfiguration{Cert: cert, Key: key, CA: ca})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return config
+}
+
+// Creates an API server that authenticates other instances, along with the leader record it reads.
+func peerFixture(kv *mockStorage.MockFailingKVStore, ip string, peer *tls.Config) *ApiServer {
+	cfg := &scheduler.Configuration{
+		Leader: &scheduler.LeaderConfiguration{
+			IP:            ip,
+			LeaseTTL:      time.Minute,
+			WatchInterval: time.Millisecond,
+		},
+		APIServer: &scheduler.ApiConfiguration{Server: server.NewConfiguration("", "", "", 0)},
+	}
+	h := ha.NewHA(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), l, cfg.Leader)
+
+	return NewApiServer(cfg, apiMgr, h, peer, nil, l)
+}
+
+// Standbys send changes to the leader over TLS, and only instances with a certificate signed by our CA get in.
+func TestApiServer_Peer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hydrogen-peer")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	ca, certs, keys, err := test.Certificates(dir, "127.0.0.1", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	otherDir, err := ioutil.TempDir("", "hydrogen-peer-other")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(otherDir)
+	otherCA, otherCerts, otherKeys, err := test.Certificates(otherDir, "10.0.0.3")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The leader's channel for other instances.
+	leader := peerFixture(&mockStorage.MockFailingKVStore{Data: map[string]string{}}, "127.0.0.1", peerTLS(t, ca, certs[0], keys[0]))
+	var from string
+	leader.cfg.APIServer.Server.Mux().HandleFunc("/v1/api/app", func(w http.ResponseWriter, r *http.Request) {
+		from = r.Header.Get(forwardedHeader)
+		w.Write([]byte("leader"))
+	})
+	srv := httptest.NewUnstartedServer(leader.peer.Handler)
+	srv.TLS = leader.peer.TLSConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	// A standby with a certificate signed by the same CA gets through.
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{
+		"/leader": `{"id": "a", "ip": "127.0.0.1", "api": "` + srv.URL + `", "token": 1}`,
+	}}
+	standby := peerFixture(kv, "10.0.0.2", peerTLS(t, ca, certs[1], keys[1]))
+	rr := httptest.NewRecorder()
+	standby.forward(local)(rr, httptest.NewRequest("POST", "/v1/api/app", nil))
+	if rr.Body.String() != "leader" || from != "10.0.0.2" {
+		t.Fatalf("Expected the leader to answer the standby, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Anyone else is turned away during the handshake.
+	strangers := map[string]*tls.Config{
+		"without a certificate": {RootCAs: peerTLS(t, ca, certs[1], keys[1]).RootCAs},
+		"signed by another CA":  {RootCAs: peerTLS(t, ca, certs[1], keys[1]).RootCAs, Certificates: peerTLS(t, otherCA, otherCerts[0], otherKeys[0]).Certificates},
+	}
+	for name, config := range strangers {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		if resp, err := client.Post(srv.URL+"/v1/api/app", "application/json", nil); err == nil {
+			resp.Body.Close()
+			t.Fatalf("A peer %s shouldn't get in, got %d", name, resp.StatusCode)
+		}
+	}
+
+	// Requests that somehow skipped the handshake are refused too.
+	rr = httptest.NewRecorder()
+	leader.peer.Handler.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/api/app", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"github.com/verizonlabs/hydrogen/scheduler/api/v1"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// Set on requests a standby sends to the leader so they're never sent on again.
+const forwardedHeader = "X-Hydrogen-Forwarded-By"
+
+// Reads that are answered from what only the leader keeps in memory, so standbys have nothing to answer them with.
+var leaderReads = map[string]bool{
+	"/v1/api/queue":             true,
+	"/v1/api/agents":            true,
+	"/v1/api/agents/quarantine": true,
+	"/v1/api/orphans":           true,
+	"/v1/api/mesos":             true,
+	"/v1/api/consistency":       true,
+}
+
+//
+// Sends requests that change anything on to the leader while we're a standby, so clients can talk to any instance.
+// Reads of what's in storage are answered locally from whatever the standby last loaded,
+// reads of what only the leader keeps in memory are sent on the same way changes are.
+// A request that's already been forwarded once is refused instead of being passed around while leadership changes.
+// A leader that's stepping down refuses changes until a standby has taken over.
+// Without HA everything is answered locally.
+//
+func (a *ApiServer) forward(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.ha == nil || a.ha.Leading() {
+			next(w, r)
+			return
+		}
+
+		// A leader that's stepping down still has everything in memory to answer reads with.
+		read := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if read && (!leaderReads[r.URL.Path] || a.ha.Token() != 0) {
+			next(w, r)
+			return
+		}
+		if a.ha.Token() != 0 {
+			unavailable(w, "We're stepping down as leader, try again once a standby has taken over")
+			return
+		}
+		if by := r.Header.Get(forwardedHeader); by != "" {
+			unavailable(w, "Forwarded by "+by+" but we're not leading, try again once the election is over")
+			return
+		}
+
+		leader, err := a.ha.GetLeader(r.Context())
+		if err != nil {
+			unavailable(w, err.Error())
+			return
+		}
+		if leader == nil || leader.API == "" {
+			unavailable(w, "No leader has been elected yet")
+			return
+		}
+
+		target, err := url.Parse(leader.API)
+		if err != nil {
+			a.logger.Emit(logging.ERROR, "Leader %s has an invalid API address: %s", leader.ID, err.Error())
+			unavailable(w, "Leader has an invalid API address")
+			return
+		}
+
+		r.Header.Set(forwardedHeader, a.cfg.Leader.IP)
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		if a.transport != nil {
+			proxy.Transport = a.transport
+		}
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// Responds with a message saying why the request couldn't be handled right now.
+func unavailable(w http.ResponseWriter, message string) {
+	v1.ServiceUnavailable(w, v1.MessageResponse{Message: message})
+}
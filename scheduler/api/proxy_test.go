@@ -0,0 +1,141 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyFixture(kv *mockStorage.MockFailingKVStore) *ApiServer {
+	cfg := &scheduler.Configuration{Leader: &scheduler.LeaderConfiguration{
+		IP:            "10.0.0.2",
+		LeaseTTL:      time.Minute,
+		WatchInterval: time.Millisecond,
+	}}
+	h := ha.NewHA(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), l, cfg.Leader)
+
+	return NewApiServer(cfg, apiMgr, h, nil, nil, l)
+}
+
+// Answers locally, saying so.
+func local(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("local"))
+}
+
+// Standbys send changes on to the leader and answer reads of storage themselves.
+func TestApiServer_Forward(t *testing.T) {
+	var forwarded *http.Request
+	var body string
+	leader := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		forwarded = r
+		body = string(data)
+		w.Write([]byte("leader"))
+	}))
+	defer leader.Close()
+
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{
+		"/leader": `{"id": "a", "ip": "10.0.0.1", "api": "` + leader.URL + `", "token": 1}`,
+	}}
+	forward := proxyFixture(kv).forward(local)
+
+	rr := httptest.NewRecorder()
+	forward(rr, httptest.NewRequest("POST", "/v1/api/app", strings.NewReader("app")))
+	if rr.Body.String() != "leader" {
+		t.Fatalf("Expected the leader to answer, got %q", rr.Body.String())
+	}
+	if forwarded.URL.Path != "/v1/api/app" || body != "app" || forwarded.Header.Get(forwardedHeader) != "10.0.0.2" {
+		t.Fatalf("Unexpected forwarded request %s with body %q", forwarded.URL.Path, body)
+	}
+
+	rr = httptest.NewRecorder()
+	forward(rr, httptest.NewRequest("GET", "/v1/api/app/all", nil))
+	if rr.Body.String() != "local" {
+		t.Fatalf("Reads should be answered locally, got %q", rr.Body.String())
+	}
+
+	// Only the leader knows what's queued.
+	rr = httptest.NewRecorder()
+	forward(rr, httptest.NewRequest("GET", "/v1/api/queue", nil))
+	if rr.Body.String() != "leader" || forwarded.URL.Path != "/v1/api/queue" {
+		t.Fatalf("Reads of the leader's memory should be answered by the leader, got %q", rr.Body.String())
+	}
+
+	// Never send a request on twice.
+	rr = httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/v1/api/app", nil)
+	r.Header.Set(forwardedHeader, "10.0.0.3")
+	forward(rr, r)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+func TestApiServer_ForwardNoLeader(t *testing.T) {
+	forward := proxyFixture(&mockStorage.MockFailingKVStore{Data: map[string]string{}}).forward(local)
+
+	rr := httptest.NewRecorder()
+	forward(rr, httptest.NewRequest("POST", "/v1/api/app", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+// The leader answers everything itself.
+func TestApiServer_ForwardLeader(t *testing.T) {
+	srv := proxyFixture(&mockStorage.MockFailingKVStore{Data: map[string]string{}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.ha.Election(ctx)
+
+	rr := httptest.NewRecorder()
+	srv.forward(local)(rr, httptest.NewRequest("POST", "/v1/api/app", nil))
+	if rr.Body.String() != "local" {
+		t.Fatalf("The leader should answer locally, got %q", rr.Body.String())
+	}
+}
+
+// A leader that's stepping down stops taking changes but still answers reads.
+func TestApiServer_ForwardSteppingDown(t *testing.T) {
+	srv := proxyFixture(&mockStorage.MockFailingKVStore{Data: map[string]string{}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.ha.Election(ctx)
+	if err := srv.ha.StepDown(""); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	rr := httptest.NewRecorder()
+	srv.forward(local)(rr, httptest.NewRequest("POST", "/v1/api/app", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	srv.forward(local)(rr, httptest.NewRequest("GET", "/v1/api/app/all", nil))
+	if rr.Body.String() != "local" {
+		t.Fatalf("Reads should be answered locally, got %q", rr.Body.String())
+	}
+}
@@ -16,9 +16,12 @@ package v1
 
 import (
 	apiManager "github.com/verizonlabs/hydrogen/scheduler/api/manager"
+	"github.com/verizonlabs/hydrogen/task/ports"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"io/ioutil"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"net/http"
+	"time"
 )
 
 // API handlers communicate with the API manager to perform the appropriate actions.
@@ -109,7 +112,7 @@ func (h *Handlers) killApplication(w http.ResponseWriter, r *http.Request) {
 
 	defer r.Body.Close()
 
-	name, err := h.manager.Kill(dec)
+	name, err := h.manager.Kill(r.Context(), dec)
 	if err != nil {
 		BadRequest(w, MessageResponse{err.Error()})
 		return
@@ -143,6 +146,7 @@ func (h *Handlers) applicationState(w http.ResponseWriter, r *http.Request) {
 		Command   *mesos_v1.CommandInfo   `json:"command,omitempty"`
 		Labels    *mesos_v1.Labels        `json:"labels,omitempty"`
 		Executor  *mesos_v1.ExecutorInfo  `json:"executor,omitempty"`
+		Ports     []ports.PortJSON        `json:"ports,omitempty"`
 	}{
 		task.Info.Name,
 		task.State.String(),
@@ -152,6 +156,7 @@ func (h *Handlers) applicationState(w http.ResponseWriter, r *http.Request) {
 		task.Info.Command,
 		task.Info.Labels,
 		task.Info.Executor,
+		ports.Assigned(task.Info),
 	})
 }
 
@@ -191,3 +196,318 @@ func (h *Handlers) getAllTasks(w http.ResponseWriter, r *http.Request) {
 
 	Success(w, resp)
 }
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Queue(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.launchQueue(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Lists every task waiting to be launched and the most recent reasons it didn't match any offers.
+func (h *Handlers) launchQueue(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.manager.Queue()
+	if err != nil {
+		// Nothing waiting to be launched isn't an error.
+		Success(w, MessageResponse{err.Error()})
+		return
+	}
+
+	type queued struct {
+		Name     string       `json:"name"`
+		Queued   time.Time    `json:"queued"`
+		Waiting  string       `json:"waiting"`
+		Attempts int          `json:"attempts"`
+		Reasons  []queue.Miss `json:"reasons"`
+	}
+
+	resp := []queued{}
+	for _, e := range entries {
+		resp = append(resp, queued{
+			Name:     e.Name,
+			Queued:   e.Queued,
+			Waiting:  time.Since(e.Queued).String(),
+			Attempts: e.Attempts,
+			Reasons:  e.Misses,
+		})
+	}
+
+	Success(w, resp)
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Agents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		Success(w, h.manager.Agents())
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Blacklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		Success(w, h.manager.Blacklist())
+	case http.MethodPost:
+		h.blacklistAgent(w, r)
+	case http.MethodDelete:
+		h.unblacklistAgent(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Quarantine(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		Success(w, h.manager.Quarantines())
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Blacklists an agent so that its offers are always declined.
+func (h *Handlers) blacklistAgent(w http.ResponseWriter, r *http.Request) {
+	dec, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	defer r.Body.Close()
+
+	host, err := h.manager.BlacklistAgent(r.Context(), dec)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	Success(w, MessageResponse{"Agent " + host + " successfully blacklisted"})
+}
+
+// Removes an agent from the blacklist.
+func (h *Handlers) unblacklistAgent(w http.ResponseWriter, r *http.Request) {
+	dec, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	defer r.Body.Close()
+
+	host, err := h.manager.UnblacklistAgent(r.Context(), dec)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	Success(w, MessageResponse{"Agent " + host + " successfully removed from the blacklist"})
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Pools(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		Success(w, h.manager.Pools())
+	case http.MethodPost:
+		h.createPool(w, r)
+	case http.MethodDelete:
+		h.deletePool(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Creates a new IP pool that tasks can get addresses from.
+func (h *Handlers) createPool(w http.ResponseWriter, r *http.Request) {
+	dec, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	defer r.Body.Close()
+
+	name, err := h.manager.CreatePool(r.Context(), dec)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	Success(w, MessageResponse{"Pool " + name + " successfully created"})
+}
+
+// Deletes an IP pool that no longer has any addresses in use.
+func (h *Handlers) deletePool(w http.ResponseWriter, r *http.Request) {
+	dec, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	defer r.Body.Close()
+
+	name, err := h.manager.DeletePool(r.Context(), dec)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	Success(w, MessageResponse{"Pool " + name + " successfully deleted"})
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Backup(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.backup(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Exports everything the scheduler has persisted as a single archive.
+func (h *Handlers) backup(w http.ResponseWriter, r *http.Request) {
+	archive, err := h.manager.Backup(r.Context())
+	if err != nil {
+		InternalServerError(w, MessageResponse{err.Error()})
+		return
+	}
+
+	Success(w, archive)
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Consistency(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.consistency(w, r, false)
+	case http.MethodPost:
+		h.consistency(w, r, true)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Checks memory, storage and Mesos against each other and reports what doesn't match.
+func (h *Handlers) consistency(w http.ResponseWriter, r *http.Request, repair bool) {
+	report, err := h.manager.Consistency(r.Context(), repair)
+	if err != nil {
+		InternalServerError(w, MessageResponse{err.Error()})
+		return
+	}
+
+	Success(w, report)
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Orphans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.orphans(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Lists the tasks that Mesos runs for us but that we don't know about.
+func (h *Handlers) orphans(w http.ResponseWriter, r *http.Request) {
+	Success(w, h.manager.Orphans())
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Leader(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.leader(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Reports which instance is currently leading.
+func (h *Handlers) leader(w http.ResponseWriter, r *http.Request) {
+	leader, err := h.manager.Leader(r.Context())
+	if err != nil {
+		InternalServerError(w, MessageResponse{err.Error()})
+		return
+	}
+	if leader == nil {
+		ServiceUnavailable(w, MessageResponse{"No leader has been elected yet"})
+		return
+	}
+
+	Success(w, leader)
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) StepDown(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.stepDown(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Asks the leader to step down once it's done with what it's doing.
+func (h *Handlers) stepDown(w http.ResponseWriter, r *http.Request) {
+	dec, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		BadRequest(w, MessageResponse{err.Error()})
+		return
+	}
+
+	successor, err := h.manager.StepDown(dec)
+	if err != nil {
+		InternalServerError(w, MessageResponse{err.Error()})
+		return
+	}
+	if successor == "" {
+		successor = "any standby"
+	}
+
+	Success(w, MessageResponse{"Stepping down as leader, handing over to " + successor})
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Mesos(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.mesos(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Reports which Mesos master we're talking to and how our subscription is doing.
+func (h *Handlers) mesos(w http.ResponseWriter, r *http.Request) {
+	Success(w, h.manager.Mesos())
+}
+
+// Calls the appropriate handler based on the HTTP method.
+func (h *Handlers) Framework(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodDelete:
+		h.teardown(w, r)
+	default:
+		MethodNotAllowed(w, MessageResponse{r.Method + " is not allowed on this endpoint"})
+	}
+}
+
+// Kills every task and retires our framework once they've all stopped.
+func (h *Handlers) teardown(w http.ResponseWriter, r *http.Request) {
+	id, err := h.manager.Teardown()
+	if err != nil {
+		InternalServerError(w, MessageResponse{err.Error()})
+		return
+	}
+
+	Success(w, MessageResponse{"Tore down framework " + id})
+}
@@ -15,14 +15,23 @@
 package v1
 
 import (
+	"context"
 	"io"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
 	"github.com/verizonlabs/mesos-framework-sdk/resources/manager/test"
 	test3 "github.com/verizonlabs/mesos-framework-sdk/scheduler/test"
 	"net/http"
 	"net/http/httptest"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
 	"github.com/verizonlabs/hydrogen/scheduler/api/manager"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/scheduler/framework"
 	mockApiManager "github.com/verizonlabs/hydrogen/scheduler/api/manager/test"
+	"github.com/verizonlabs/hydrogen/task/ipam"
+	"github.com/verizonlabs/hydrogen/task/persistence/backup"
 	test2 "github.com/verizonlabs/hydrogen/task/manager/test"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"strings"
 	"testing"
 )
@@ -73,6 +82,15 @@ func TestHandlers_DeployError(t *testing.T) {
 		&test.MockResourceManager{},
 		&test2.MockTaskManager{},
 		test3.MockScheduler{},
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		backup.NewBackup(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(&test2.MockTaskManager{}, &mockStorage.MockStorage{}, test3.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(test3.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
+		nil,
+		nil,
+		framework.NewTeardown(context.Background(), &test2.MockTaskManager{}, test3.MockScheduler{}, queue.NewLaunchQueue(1), ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}), &mockStorage.MockStorage{}, 0, &mockLogger.MockLogger{}),
 	)
 	rr := requestFixture(h.Application, "POST", "/app", strings.NewReader(junkJSON))
 	if rr.Code == http.StatusOK {
@@ -166,3 +184,256 @@ func TestHandlers_UpdateError(t *testing.T) {
 		t.Fatalf("Wrong status code: want %d but got %d", 400, http.StatusOK)
 	}
 }
+
+// Validates the endpoint to list queued tasks.
+func TestHandlers_Queue(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Queue, "GET", "/queue", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), string(queue.NoOffers)) {
+		t.Fatalf("Expected queue reasons in the response, got %s", rr.Body.String())
+	}
+}
+
+// Tests that we get an OK response to an empty queue and reject other methods.
+func TestHandlers_QueueEmpty(t *testing.T) {
+	h := NewHandlers(brokenApiMgr)
+	h.manager = mockApiManager.MockBrokenApiManager{}
+	rr := requestFixture(h.Queue, "GET", "/queue", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = requestFixture(h.Queue, "POST", "/queue", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+// Validates the endpoint to list agents.
+func TestHandlers_Agents(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Agents, "GET", "/agents", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = requestFixture(h.Agents, "PUT", "/agents", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+// Validates the endpoints to manage the agent blacklist.
+func TestHandlers_Blacklist(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		rr := requestFixture(h.Blacklist, method, "/agents/blacklist", strings.NewReader(`{"hostname": "host"}`))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Wrong status code for %s: want %d but got %d", method, http.StatusOK, rr.Code)
+		}
+	}
+}
+
+// Makes sure the blacklist endpoints give an error when they should.
+func TestHandlers_BlacklistError(t *testing.T) {
+	h := NewHandlers(brokenApiMgr)
+	h.manager = mockApiManager.MockBrokenApiManager{}
+	for _, method := range []string{"POST", "DELETE"} {
+		rr := requestFixture(h.Blacklist, method, "/agents/blacklist", strings.NewReader(junkJSON))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Wrong status code for %s: want %d but got %d", method, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// Validates the endpoint to list quarantined agents.
+func TestHandlers_Quarantine(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Quarantine, "GET", "/agents/quarantine", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"app":"test"`) {
+		t.Fatalf("Expected the quarantined app in the response, got %s", rr.Body.String())
+	}
+
+	rr = requestFixture(h.Quarantine, "POST", "/agents/quarantine", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+// Validates the endpoints to manage IP pools.
+func TestHandlers_Pools(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	for _, method := range []string{"GET", "POST", "DELETE"} {
+		rr := requestFixture(h.Pools, method, "/pools", strings.NewReader(`{"name": "test"}`))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Wrong status code for %s: want %d but got %d", method, http.StatusOK, rr.Code)
+		}
+	}
+
+	h.manager = mockApiManager.MockBrokenApiManager{}
+	for _, method := range []string{"POST", "DELETE"} {
+		rr := requestFixture(h.Pools, method, "/pools", strings.NewReader(junkJSON))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Wrong status code for %s: want %d but got %d", method, http.StatusBadRequest, rr.Code)
+		}
+	}
+}
+
+// Validates the endpoint to back up scheduler state.
+func TestHandlers_Backup(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Backup, "GET", "/admin/backup", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"version":1`) {
+		t.Fatalf("Expected a versioned archive, got %s", rr.Body.String())
+	}
+
+	rr = requestFixture(h.Backup, "POST", "/admin/backup", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+
+	h.manager = mockApiManager.MockBrokenApiManager{}
+	rr = requestFixture(h.Backup, "GET", "/admin/backup", nil)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+// Validates the endpoint to check and repair consistency.
+func TestHandlers_Consistency(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Consistency, "GET", "/consistency", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = requestFixture(h.Consistency, "POST", "/consistency", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = requestFixture(h.Consistency, "DELETE", "/consistency", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+
+	h.manager = mockApiManager.MockBrokenApiManager{}
+	rr = requestFixture(h.Consistency, "GET", "/consistency", nil)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+// Validates the endpoint that lists tasks we don't know about.
+func TestHandlers_Orphans(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Orphans, "GET", "/orphans", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"taskId":"orphan"`) {
+		t.Fatalf("Expected the orphan to be listed, got %s", rr.Body.String())
+	}
+
+	rr = requestFixture(h.Orphans, "POST", "/orphans", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestHandlers_Leader(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Leader, "GET", "/leader", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"api":"http://127.0.0.1:8080"`) {
+		t.Fatalf("Expected the leader's API address, got %s", rr.Body.String())
+	}
+
+	h.manager = mockApiManager.MockBrokenApiManager{}
+	rr = requestFixture(h.Leader, "GET", "/leader", nil)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusInternalServerError, rr.Code)
+	}
+
+	rr = requestFixture(h.Leader, "DELETE", "/leader", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestHandlers_StepDown(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.StepDown, "POST", "/leader/stepdown", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	h.manager = mockApiManager.MockBrokenApiManager{}
+	rr = requestFixture(h.StepDown, "POST", "/leader/stepdown", strings.NewReader(`{"successor": "10.0.0.2"}`))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusInternalServerError, rr.Code)
+	}
+
+	rr = requestFixture(h.StepDown, "GET", "/leader/stepdown", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestHandlers_Mesos(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Mesos, "GET", "/mesos", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `"state":"subscribed"`) {
+		t.Fatalf("Expected the subscription state, got %s", rr.Body.String())
+	}
+
+	rr = requestFixture(h.Mesos, "POST", "/mesos", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestHandlers_Framework(t *testing.T) {
+	h := NewHandlers(apiMgr)
+	h.manager = mockApiManager.MockApiManager{}
+	rr := requestFixture(h.Framework, "DELETE", "/framework", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	h.manager = mockApiManager.MockBrokenApiManager{}
+	rr = requestFixture(h.Framework, "DELETE", "/framework", nil)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusInternalServerError, rr.Code)
+	}
+
+	rr = requestFixture(h.Framework, "GET", "/framework", nil)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Wrong status code: want %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
@@ -28,6 +28,8 @@ var (
 	InternalServerError func(http.ResponseWriter, interface{}) = responseFactory(http.StatusInternalServerError)
 	BadRequest          func(http.ResponseWriter, interface{}) = responseFactory(http.StatusBadRequest)
 	MethodNotAllowed    func(http.ResponseWriter, interface{}) = responseFactory(http.StatusMethodNotAllowed)
+	ServiceUnavailable  func(http.ResponseWriter, interface{}) = responseFactory(http.StatusServiceUnavailable)
+	Unauthorized        func(http.ResponseWriter, interface{}) = responseFactory(http.StatusUnauthorized)
 	Success             func(http.ResponseWriter, interface{}) = responseFactory(http.StatusOK)
 )
 
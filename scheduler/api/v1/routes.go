@@ -36,5 +36,53 @@ func MapRoutes(h *Handlers) map[string]Route {
 			h.Tasks,
 			[]string{"GET"},
 		},
+		baseUrl + "/queue": {
+			h.Queue,
+			[]string{"GET"},
+		},
+		baseUrl + "/agents": {
+			h.Agents,
+			[]string{"GET"},
+		},
+		baseUrl + "/agents/blacklist": {
+			h.Blacklist,
+			[]string{"GET", "POST", "DELETE"},
+		},
+		baseUrl + "/agents/quarantine": {
+			h.Quarantine,
+			[]string{"GET"},
+		},
+		baseUrl + "/pools": {
+			h.Pools,
+			[]string{"GET", "POST", "DELETE"},
+		},
+		baseUrl + "/admin/backup": {
+			h.Backup,
+			[]string{"GET"},
+		},
+		baseUrl + "/consistency": {
+			h.Consistency,
+			[]string{"GET", "POST"},
+		},
+		baseUrl + "/orphans": {
+			h.Orphans,
+			[]string{"GET"},
+		},
+		baseUrl + "/leader": {
+			h.Leader,
+			[]string{"GET"},
+		},
+		baseUrl + "/leader/stepdown": {
+			h.StepDown,
+			[]string{"POST"},
+		},
+		baseUrl + "/mesos": {
+			h.Mesos,
+			[]string{"GET"},
+		},
+		baseUrl + "/framework": {
+			h.Framework,
+			[]string{"DELETE"},
+		},
 	}
 }
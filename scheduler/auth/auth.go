@@ -0,0 +1,42 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"encoding/base64"
+)
+
+// Authenticator supplies the Authorization header sent with our calls to Mesos.
+// The header can change over time, such as when a token is refreshed before it expires.
+type Authenticator interface {
+	Header() (string, error)
+}
+
+// Authenticates with the same principal and secret on every call.
+type Basic struct {
+	header string
+}
+
+// Returns an authenticator that uses HTTP basic authentication.
+func NewBasic(principal, secret string) *Basic {
+	return &Basic{
+		header: "Basic " + base64.StdEncoding.EncodeToString([]byte(principal+":"+secret)),
+	}
+}
+
+// Gets the header for basic authentication, which never changes.
+func (b *Basic) Header() (string, error) {
+	return b.header, nil
+}
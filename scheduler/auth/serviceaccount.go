@@ -0,0 +1,229 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How long the token we sign ourselves is valid for, it's only ever used to log in.
+	loginTTL = 5 * time.Minute
+
+	// How long we assume a token lasts if it doesn't say when it expires.
+	defaultTTL = time.Hour
+)
+
+type (
+	// Body sent to the login endpoint.
+	loginRequest struct {
+		UID   string `json:"uid"`
+		Token string `json:"token"`
+	}
+
+	// Body we get back from the login endpoint.
+	loginResponse struct {
+		Token string `json:"token"`
+	}
+
+	// The claims we read from and write to tokens.
+	claims struct {
+		UID string `json:"uid,omitempty"`
+		Exp int64  `json:"exp,omitempty"`
+	}
+
+	//
+	// ServiceAccount authenticates as a service account.
+	// We sign a short-lived token with the account's private key and exchange it at the login endpoint
+	// for the token that's actually sent to Mesos. That token is refreshed once it's close to expiring.
+	//
+	ServiceAccount struct {
+		uid     string
+		key     *rsa.PrivateKey
+		login   string
+		refresh time.Duration
+		client  *http.Client
+		logger  logging.Logger
+		mutex   sync.Mutex
+		token   string
+		expires time.Time
+	}
+)
+
+// Returns an authenticator for the given service account that logs in at the given endpoint,
+// and logs in again once its token expires within the refresh window.
+func NewServiceAccount(uid string, key *rsa.PrivateKey, login string, refresh time.Duration, l logging.Logger) *ServiceAccount {
+	return &ServiceAccount{
+		uid:     uid,
+		key:     key,
+		login:   login,
+		refresh: refresh,
+		client:  &http.Client{Timeout: time.Minute},
+		logger:  l,
+	}
+}
+
+// Reads an RSA private key from a PEM file in either PKCS #1 or PKCS #8 form.
+func LoadKey(path string) (*rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM data found in " + path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("The key in " + path + " isn't an RSA key")
+	}
+
+	return rsaKey, nil
+}
+
+//
+// Header gets the bearer token header for our service account, logging in first if our token is about to expire.
+// A token that's still valid is kept if logging in again fails, so a brief outage of the login endpoint goes unnoticed.
+//
+func (s *ServiceAccount) Header() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	if s.token != "" && s.expires.Sub(now) > s.refresh {
+		return "Bearer " + s.token, nil
+	}
+
+	token, expires, err := s.exchange(now)
+	if err != nil {
+		if s.token != "" && now.Before(s.expires) {
+			s.logger.Emit(logging.ERROR, "Failed to refresh the token for service account %s, it expires at %s: %s",
+				s.uid, s.expires.Format(time.RFC3339), err.Error())
+			return "Bearer " + s.token, nil
+		}
+
+		return "", err
+	}
+
+	s.logger.Emit(logging.INFO, "Logged in as service account %s, the token expires at %s", s.uid, expires.Format(time.RFC3339))
+	s.token = token
+	s.expires = expires
+
+	return "Bearer " + s.token, nil
+}
+
+// Signs a token with our private key and exchanges it for a new token, returning it along with when it expires.
+func (s *ServiceAccount) exchange(now time.Time) (string, time.Time, error) {
+	signed, err := s.sign(claims{UID: s.uid, Exp: now.Add(loginTTL).Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	body, err := json.Marshal(loginRequest{UID: s.uid, Token: signed})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	resp, err := s.client.Post(s.login, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, errors.New("Login failed with status " + strconv.Itoa(resp.StatusCode) + ": " + strings.TrimSpace(string(data)))
+	}
+
+	var login loginResponse
+	if err := json.Unmarshal(data, &login); err != nil {
+		return "", time.Time{}, err
+	}
+	if login.Token == "" {
+		return "", time.Time{}, errors.New("Login succeeded but no token was returned")
+	}
+
+	return login.Token, expiry(login.Token, now), nil
+}
+
+// Creates a token with the given claims signed with RS256.
+func (s *ServiceAccount) sign(c claims) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Reads when a token expires from its claims without verifying it, since it's only ever checked by Mesos.
+// Tokens that don't say are assumed to last a default amount of time.
+func expiry(token string, now time.Time) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return now.Add(defaultTTL)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return now.Add(defaultTTL)
+	}
+
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil || c.Exp == 0 {
+		return now.Add(defaultTTL)
+	}
+
+	return time.Unix(c.Exp, 0)
+}
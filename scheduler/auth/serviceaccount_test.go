@@ -0,0 +1,223 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stands in for a login endpoint that checks tokens against the service account's public key.
+type fakeLogin struct {
+	key    *rsa.PublicKey
+	ttl    time.Duration
+	mutex  sync.Mutex
+	logins int
+	broken bool
+}
+
+func (f *fakeLogin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.broken {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var login loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	parts := strings.Split(login.Token, ".")
+	if len(parts) != 3 {
+		http.Error(w, "malformed token", http.StatusUnauthorized)
+		return
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(f.key, crypto.SHA256, digest[:], signature); err != nil {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+
+	var c claims
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(payload, &c); err != nil || c.UID != login.UID || c.Exp < time.Now().Unix() {
+		http.Error(w, "bad claims", http.StatusUnauthorized)
+		return
+	}
+
+	f.logins++
+	exp, _ := json.Marshal(claims{UID: login.UID, Exp: time.Now().Add(f.ttl).Unix()})
+	token := "e30." + base64.RawURLEncoding.EncodeToString(exp) + ".login" + strconv.Itoa(f.logins)
+	json.NewEncoder(w).Encode(loginResponse{Token: token})
+}
+
+func (f *fakeLogin) count() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.logins
+}
+
+func (f *fakeLogin) fail(broken bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.broken = broken
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return key
+}
+
+// Tokens are only exchanged again once they're close to expiring.
+func TestServiceAccount_Header(t *testing.T) {
+	key := generateKey(t)
+	login := &fakeLogin{key: &key.PublicKey, ttl: time.Hour}
+	srv := httptest.NewServer(login)
+	defer srv.Close()
+
+	s := NewServiceAccount("hydrogen", key, srv.URL, time.Minute, new(mockLogger.MockLogger))
+	header, err := s.Header()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.HasPrefix(header, "Bearer e30.") || !strings.HasSuffix(header, ".login1") {
+		t.Fatalf("Unexpected header %s", header)
+	}
+	if s.expires.Before(time.Now().Add(59 * time.Minute)) {
+		t.Fatalf("Expected the token to last an hour, it expires at %s", s.expires)
+	}
+
+	if again, err := s.Header(); err != nil || again != header || login.count() != 1 {
+		t.Fatal("A token that isn't close to expiring should be reused")
+	}
+
+	// Logging in again inside the refresh window gets us a new token.
+	s.expires = time.Now().Add(30 * time.Second)
+	refreshed, err := s.Header()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if refreshed == header || login.count() != 2 {
+		t.Fatalf("Expected the token to be refreshed, got %s", refreshed)
+	}
+}
+
+// A token that's still valid is kept when logging in again fails, but never one that's expired.
+func TestServiceAccount_HeaderFailed(t *testing.T) {
+	key := generateKey(t)
+	login := &fakeLogin{key: &key.PublicKey, ttl: time.Hour}
+	srv := httptest.NewServer(login)
+	defer srv.Close()
+
+	s := NewServiceAccount("hydrogen", key, srv.URL, time.Minute, new(mockLogger.MockLogger))
+	header, err := s.Header()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	login.fail(true)
+	s.expires = time.Now().Add(30 * time.Second)
+	if kept, err := s.Header(); err != nil || kept != header {
+		t.Fatalf("Expected to keep the token until it expires, got %s and %v", kept, err)
+	}
+
+	s.expires = time.Now().Add(-time.Second)
+	if _, err := s.Header(); err == nil {
+		t.Fatal("Expected an error once the token expired")
+	}
+}
+
+// Logging in with a key the endpoint doesn't know fails.
+func TestServiceAccount_HeaderWrongKey(t *testing.T) {
+	key := generateKey(t)
+	login := &fakeLogin{key: &generateKey(t).PublicKey, ttl: time.Hour}
+	srv := httptest.NewServer(login)
+	defer srv.Close()
+
+	s := NewServiceAccount("hydrogen", key, srv.URL, time.Minute, new(mockLogger.MockLogger))
+	if _, err := s.Header(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Expected the login to be refused, got %v", err)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	key := generateKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	blocks := map[string]*pem.Block{
+		"pkcs1.pem": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		"pkcs8.pem": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	}
+	for name, block := range blocks {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		loaded, err := LoadKey(path)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if loaded.N.Cmp(key.N) != 0 {
+			t.Fatalf("Loaded the wrong key from %s", name)
+		}
+	}
+
+	junk := filepath.Join(dir, "junk.pem")
+	ioutil.WriteFile(junk, []byte("junk"), 0600)
+	if _, err := LoadKey(junk); err == nil {
+		t.Fatal("Expected an error loading a file without a key")
+	}
+}
@@ -43,20 +43,36 @@ type ExecutorConfiguration struct {
 
 // Persistence connection configuration.
 type PersistenceConfiguration struct {
+	Driver           string
+	Path             string
+	Prefix           string
+	Migrate          bool
+	SchemaDryRun     bool
+	Restore          string
+	EncryptionKeys   string
+	Reencrypt        time.Duration
 	Endpoints        string
 	Timeout          time.Duration
 	KeepaliveTime    time.Duration
 	KeepaliveTimeout time.Duration
 	MaxRetries       int
+	RetryBackoff     time.Duration
+	RetryMaxBackoff  time.Duration
+	RetryJitter      float64
 }
 
 // Configuration for leader (HA) operation.
 type LeaderConfiguration struct {
-	IP            string
-	ServerPort    int
-	AddressFamily string
-	RetryInterval time.Duration
-	ServerRetry   time.Duration
+	IP             string
+	RetryInterval  time.Duration
+	LeaseTTL       time.Duration
+	WatchInterval  time.Duration
+	API            string
+	StandbyRefresh time.Duration
+	Port           int
+	Cert           string
+	Key            string
+	CA             string
 }
 
 // Holds configuration for the built-in REST API.
@@ -78,21 +94,63 @@ type FileServerConfiguration struct {
 
 // Configuration for the main scheduler.
 type SchedulerConfiguration struct {
-	CustomExecutor    bool
-	MesosEndpoint     string
-	Name              string
-	User              string
-	Role              string
-	Checkpointing     bool
-	Principal         string
-	Secret            string
-	ExecutorSrvCfg    server.Configuration
-	ExecutorName      string
-	ExecutorCmd       string
-	Failover          float64
-	Hostname          string
-	ReconcileInterval time.Duration
-	SubscribeRetry    time.Duration
+	CustomExecutor      bool
+	MesosEndpoint       string
+	Name                string
+	User                string
+	Role                string
+	Checkpointing       bool
+	Principal           string
+	Secret              string
+	AuthKey             string
+	AuthLogin           string
+	AuthRefresh         time.Duration
+	ExecutorSrvCfg      server.Configuration
+	ExecutorName        string
+	ExecutorCmd         string
+	Failover            float64
+	Hostname            string
+	ReconcileInterval   time.Duration
+	SubscribeRetry      time.Duration
+	SubscribeRetryMax   time.Duration
+	HeartbeatMisses     int
+	TeardownTimeout     time.Duration
+	ExpiredRelaunch     bool
+	QueueHistory        int
+	BlacklistRefuse     time.Duration
+	QuarantineThreshold int
+	QuarantineWindow    time.Duration
+	QuarantineCooldown  time.Duration
+	QuarantineApps      int
+	ConsistencyInterval time.Duration
+	ConsistencyTimeout  time.Duration
+	ConsistencyRepair   bool
+	OrphanKill          bool
+	OrphanGrace         time.Duration
+	JournalPath         string
+	JournalSize         int64
+	JournalFiles        int
+	ShutdownTimeout     time.Duration
+}
+
+// Flags that no longer do anything but are still accepted so existing command lines keep working.
+var deprecatedFlags = map[string]bool{
+	"ha.leader.server.port":           true,
+	"ha.leader.server.address.family": true,
+	"ha.leader.server.retry":          true,
+}
+
+// Lists the deprecated flags that were set on the command line.
+// Only meaningful once flags have been parsed.
+func DeprecatedFlags() []string {
+	set := []string{}
+	flag.Visit(func(f *flag.Flag) {
+		if deprecatedFlags[f.Name] {
+			set = append(set, f.Name)
+		}
+	})
+
+	return set
 }
 
 // Stores and initializes all of our configuration.
@@ -121,6 +179,20 @@ func (c *ExecutorConfiguration) initialize() *ExecutorConfiguration {
 
 // Applies default configuration for our persistence connection.
 func (c *PersistenceConfiguration) initialize() *PersistenceConfiguration {
+	flag.StringVar(&c.Driver, "persistence.driver", "etcd", "Storage driver to use: etcd, or embedded for a local "+
+		"file on a single node")
+	flag.StringVar(&c.Path, "persistence.path", "hydrogen.db", "File the embedded storage driver keeps its data in")
+	flag.StringVar(&c.Prefix, "persistence.prefix", "", "Namespace every storage key is kept under, defaults to "+
+		"the framework name. Use / to not namespace keys")
+	flag.BoolVar(&c.Migrate, "persistence.migrate", false, "Move keys written before keys were namespaced "+
+		"under the namespace, then exit")
+	flag.BoolVar(&c.SchemaDryRun, "persistence.schema.dryrun", false, "Report what pending storage schema "+
+		"migrations would change without changing anything, then exit")
+	flag.StringVar(&c.Restore, "restore", "", "Restore a backup archive from this file into empty storage, then exit")
+	flag.StringVar(&c.EncryptionKeys, "persistence.encryption.keys", "", "Key file used to encrypt task "+
+		"definitions at rest, encryption is off if this isn't set")
+	flag.DurationVar(&c.Reencrypt, "persistence.encryption.reencrypt", time.Hour, "How often values written "+
+		"with an older key are re-encrypted with the primary key")
 	flag.StringVar(&c.Endpoints, "persistence.endpoints", "http://127.0.0.1:2379", "Comma-separated list of "+
 		"storage endpoints")
 	flag.DurationVar(&c.Timeout, "persistence.timeout", 2*time.Second, "Timeout for CRUD storage operations")
@@ -133,6 +205,12 @@ func (c *PersistenceConfiguration) initialize() *PersistenceConfiguration {
 		"and if no activity is seen "+
 		"even after that the connection is closed")
 	flag.IntVar(&c.MaxRetries, "persistence.retry.max", 3, "How many times persistence operations will be retried")
+	flag.DurationVar(&c.RetryBackoff, "persistence.retry.backoff", 100*time.Millisecond, "How long to wait before "+
+		"the first retry of a persistence operation, doubling after each retry")
+	flag.DurationVar(&c.RetryMaxBackoff, "persistence.retry.backoff.max", 5*time.Second, "Longest wait between "+
+		"retries of a persistence operation")
+	flag.Float64Var(&c.RetryJitter, "persistence.retry.jitter", 0.2, "Fraction of each wait between persistence "+
+		"retries that's randomized")
 
 	return c
 }
@@ -140,12 +218,26 @@ func (c *PersistenceConfiguration) initialize() *PersistenceConfiguration {
 // Applies default leader configuration.
 func (c *LeaderConfiguration) initialize() *LeaderConfiguration {
 	flag.StringVar(&c.IP, "ha.ip", "127.0.0.1", "IP address of the node where this framework is running")
-	flag.IntVar(&c.ServerPort, "ha.leader.server.port", 8082, "Port that the leader server listens on")
-	flag.StringVar(&c.AddressFamily, "ha.leader.server.address.family", "tcp4", "tcp4, tcp6, or tcp for dual stack")
 	flag.DurationVar(&c.RetryInterval, "ha.leader.election.retry", 2*time.Second, "How long to wait before retrying "+
 		"the leader election process")
-	flag.DurationVar(&c.ServerRetry, "ha.leader.server.retry", 2*time.Second, "How long to wait before accepting "+
-		"connections from clients after an error")
+	flag.DurationVar(&c.LeaseTTL, "ha.lease.ttl", 10*time.Second, "How long the leader's lease lasts without being "+
+		"renewed before a standby takes over, rounded down to whole seconds")
+	flag.DurationVar(&c.WatchInterval, "ha.watch.interval", time.Second, "How often standbys check the leader's lease "+
+		"and the leader checks that it's still leading")
+	flag.StringVar(&c.API, "ha.api.address", "", "Address of our API that standbys send requests to while we're leading, "+
+		"defaults to the HA IP and the API port")
+	flag.DurationVar(&c.StandbyRefresh, "ha.standby.refresh", 10*time.Second, "How often standbys reload the agent "+
+		"blacklist and IP pools from storage, zero to keep nothing up to date on standbys")
+	flag.IntVar(&c.Port, "ha.port", 8082, "Port standbys send requests to while we're leading, when TLS is on")
+	flag.StringVar(&c.Cert, "ha.tls.cert", "", "TLS certificate this instance presents to other instances")
+	flag.StringVar(&c.Key, "ha.tls.key", "", "TLS key for the HA certificate")
+	flag.StringVar(&c.CA, "ha.tls.ca", "", "CA every instance's HA certificate is signed by, standbys and the "+
+		"leader only talk over TLS with client certificates if this, the cert and the key are set")
+
+	// The leader used to run its own server for standbys to find it, elections now happen entirely in storage.
+	flag.Int("ha.leader.server.port", 8082, "Deprecated and ignored")
+	flag.String("ha.leader.server.address.family", "tcp4", "Deprecated and ignored")
+	flag.Duration("ha.leader.server.retry", 2*time.Second, "Deprecated and ignored")
 
 	return c
 }
@@ -179,18 +271,60 @@ func (c *SchedulerConfiguration) initialize() *SchedulerConfiguration {
 
 	h, _ := os.Hostname() // If we can't determine the hostname just use the empty string.
 
-	flag.StringVar(&c.MesosEndpoint, "endpoint", "http://127.0.0.1:5050/api/v1/scheduler", "Mesos scheduler API endpoint")
+	flag.StringVar(&c.MesosEndpoint, "endpoint", "http://127.0.0.1:5050/api/v1/scheduler", "Comma separated "+
+		"list of Mesos scheduler API endpoints, one for each master")
 	flag.StringVar(&c.Name, "name", "Hydrogen", "Framework name")
 	flag.StringVar(&c.User, "user", u.Username, "User that the executor/task will be launched as")
 	flag.StringVar(&c.Role, "role", "*", "Framework role")
 	flag.BoolVar(&c.Checkpointing, "checkpointing", true, "Enable or disable checkpointing")
 	flag.StringVar(&c.Principal, "principal", "Hydrogen", "Framework principal")
 	flag.StringVar(&c.Secret, "secret", "", "Used when Mesos requires authentication")
+	flag.StringVar(&c.AuthKey, "auth.key", "", "PEM encoded RSA private key of the service account named by "+
+		"the principal, logs in as the service account instead of using the secret if set")
+	flag.StringVar(&c.AuthLogin, "auth.login", "", "Endpoint that service account tokens are exchanged at "+
+		"for the token sent to Mesos")
+	flag.DurationVar(&c.AuthRefresh, "auth.refresh", 5*time.Minute, "How long before the service account's "+
+		"token expires that it's refreshed")
 	flag.Float64Var(&c.Failover, "failover", 168*time.Hour.Seconds(), "Framework failover timeout") // 1 week is recommended
 	flag.StringVar(&c.Hostname, "hostname", h, "The framework's hostname")
-	flag.DurationVar(&c.SubscribeRetry, "subscribe.retry", 2*time.Second, "Controls the interval at which subscribe "+
-		"calls will be retried")
+	flag.DurationVar(&c.SubscribeRetry, "subscribe.retry", 2*time.Second, "How long to wait before subscribing to "+
+		"the next master, doubled for every attempt in a row that fails")
+	flag.DurationVar(&c.SubscribeRetryMax, "subscribe.retry.max", time.Minute, "The longest we wait before "+
+		"subscribing to the next master")
+	flag.IntVar(&c.HeartbeatMisses, "heartbeat.misses", 3, "How many heartbeats in a row can be missed before "+
+		"we resubscribe, 0 never resubscribes")
+	flag.DurationVar(&c.TeardownTimeout, "framework.teardown.timeout", 5*time.Minute, "How long tearing down the "+
+		"framework waits for every task to stop before giving up")
+	flag.BoolVar(&c.ExpiredRelaunch, "framework.expired.relaunch", true, "Relaunch tasks left over from a framework "+
+		"whose failover timeout expired, otherwise they're forgotten")
 	flag.DurationVar(&c.ReconcileInterval, "reconcile.interval", 15*time.Minute, "How often periodic reconciling happens")
+	flag.IntVar(&c.QueueHistory, "queue.history", 10, "How many match failure reasons are kept for each queued task")
+	flag.DurationVar(&c.BlacklistRefuse, "agent.blacklist.refuse", time.Hour, "How long Mesos should hold back "+
+		"offers from blacklisted agents")
+	flag.IntVar(&c.QuarantineThreshold, "agent.quarantine.threshold", 3, "How many times an app can fail on the "+
+		"same agent within the window before the agent is quarantined for that app, 0 disables quarantining")
+	flag.DurationVar(&c.QuarantineWindow, "agent.quarantine.window", 10*time.Minute, "How long task failures "+
+		"count towards quarantining an agent")
+	flag.DurationVar(&c.QuarantineCooldown, "agent.quarantine.cooldown", 30*time.Minute, "How long a quarantined "+
+		"agent is avoided")
+	flag.IntVar(&c.QuarantineApps, "agent.quarantine.apps", 3, "How many apps can be quarantined on the same "+
+		"agent before it's avoided for all apps, 0 disables")
+	flag.DurationVar(&c.ConsistencyInterval, "consistency.interval", 30*time.Minute, "How often memory, storage "+
+		"and Mesos are checked against each other, 0 only checks on demand")
+	flag.DurationVar(&c.ConsistencyTimeout, "consistency.timeout", 30*time.Second, "How long a consistency check "+
+		"waits for Mesos to report on every task")
+	flag.BoolVar(&c.ConsistencyRepair, "consistency.repair", false, "Repair discrepancies found by periodic "+
+		"consistency checks")
+	flag.BoolVar(&c.OrphanKill, "orphan.kill", false, "Kill tasks that Mesos reports but that we don't know about")
+	flag.DurationVar(&c.OrphanGrace, "orphan.grace", 10*time.Minute, "How long tasks we don't know about are "+
+		"left running before they're killed")
+	flag.StringVar(&c.JournalPath, "journal.path", "", "File that every Mesos event and API request that changes "+
+		"state is journaled to for replaying later, journaling is off if this isn't set")
+	flag.Int64Var(&c.JournalSize, "journal.size", 100*1024*1024, "Size in bytes the journal can grow to before "+
+		"it's rotated")
+	flag.IntVar(&c.JournalFiles, "journal.files", 5, "How many rotated journal files are kept")
+	flag.DurationVar(&c.ShutdownTimeout, "shutdown.timeout", 30*time.Second, "How long shutting down waits for API "+
+		"requests, the event being handled and storage writes to finish")
 
 	return c
 }
@@ -0,0 +1,442 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consistency
+
+import (
+	"context"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/manager"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"github.com/verizonlabs/mesos-framework-sdk/scheduler"
+	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// The task is in memory but was never written to storage.
+	MISSING_FROM_STORAGE = "missing_from_storage"
+
+	// Storage holds an older version of the task than memory does.
+	STALE_STORAGE = "stale_storage"
+
+	// Storage holds a task that isn't in memory, usually left behind by a failed delete.
+	MISSING_FROM_MEMORY = "missing_from_memory"
+
+	// Mesos is still running a task that we no longer track.
+	ORPHANED = "orphaned"
+
+	// Mesos reports a different state than the one we have in memory.
+	STATE_MISMATCH = "state_mismatch"
+
+	// Mesos didn't answer for the task before the check timed out.
+	UNANSWERED = "unanswered"
+
+	// The stored task couldn't be decoded.
+	UNREADABLE = "unreadable"
+)
+
+type (
+	// A single difference between what we have in memory, what's in storage and what Mesos reports.
+	Discrepancy struct {
+		TaskID   string `json:"taskId"`
+		Name     string `json:"name,omitempty"`
+		Kind     string `json:"kind"`
+		Detail   string `json:"detail"`
+		Repaired bool   `json:"repaired"`
+		Error    string `json:"error,omitempty"`
+	}
+
+	// The outcome of a consistency check.
+	Report struct {
+		Started       time.Time     `json:"started"`
+		Finished      time.Time     `json:"finished"`
+		Repair        bool          `json:"repair"`
+		Checked       int           `json:"checked"`
+		Discrepancies []Discrepancy `json:"discrepancies"`
+	}
+
+	// Compares our tasks in memory and in storage against each other and against Mesos, and optionally repairs them.
+	// Mesos is asked about each task with explicit reconciliation and its answers are handed to us by the update handler.
+	Checker struct {
+		taskManager sdkTaskManager.TaskManager
+		storage     persistence.Storage
+		scheduler   scheduler.Scheduler
+		timeout     time.Duration
+		logger      logging.Logger
+		checking    sync.Mutex
+		mutex       sync.Mutex
+		leader      bool
+		round       *round
+	}
+
+	// Answers to the reconciliation request of a check that's in progress.
+	round struct {
+		statuses  map[string]*mesos_v1.TaskStatus
+		remaining int
+		done      chan struct{}
+	}
+
+	// A task as we found it in storage.
+	stored struct {
+		key  string
+		task *sdkTaskManager.Task
+	}
+
+	byTaskID []Discrepancy
+)
+
+func (d byTaskID) Len() int           { return len(d) }
+func (d byTaskID) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byTaskID) Less(i, j int) bool { return d[i].TaskID < d[j].TaskID }
+
+// Returns a checker that waits up to the given timeout for Mesos to answer for every task.
+func NewChecker(
+	t sdkTaskManager.TaskManager,
+	s persistence.Storage,
+	sched scheduler.Scheduler,
+	timeout time.Duration,
+	l logging.Logger) *Checker {
+
+	return &Checker{
+		taskManager: t,
+		storage:     s,
+		scheduler:   sched,
+		timeout:     timeout,
+		logger:      l,
+	}
+}
+
+// Records the answer Mesos gave for a task we asked about.
+// Only updates sent in response to reconciliation count towards a check.
+func (c *Checker) Observe(status *mesos_v1.TaskStatus) {
+	if status.GetReason() != mesos_v1.TaskStatus_REASON_RECONCILIATION {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.round == nil {
+		return
+	}
+
+	id := status.GetTaskId().GetValue()
+	if answer, ok := c.round.statuses[id]; !ok || answer != nil {
+		return
+	}
+
+	c.round.statuses[id] = status
+	c.round.remaining--
+	if c.round.remaining == 0 {
+		close(c.round.done)
+	}
+}
+
+// Allows checks to run, since only the leader has our tasks in memory, and runs one every interval until we're shut down.
+// Checks aren't allowed anymore once the context is done, which happens when we stop leading.
+// Periodic checks are turned off if the interval isn't positive.
+func (c *Checker) Run(ctx context.Context, interval time.Duration, repair bool) {
+	c.mutex.Lock()
+	c.leader = true
+	c.mutex.Unlock()
+
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+
+			c.mutex.Lock()
+			c.leader = false
+			c.mutex.Unlock()
+		}()
+	}
+
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := c.Check(ctx, repair)
+			if err != nil {
+				c.logger.Emit(logging.ERROR, "Consistency check failed: %s", err.Error())
+				continue
+			}
+
+			for _, d := range report.Discrepancies {
+				c.logger.Emit(logging.ALARM, "Task %s is inconsistent (%s): %s", d.TaskID, d.Kind, d.Detail)
+			}
+		}
+	}
+}
+
+// Compares memory, storage and Mesos and reports every discrepancy, repairing them if asked to.
+// Storage is repaired from memory and tasks that Mesos runs but we no longer track are killed.
+// Differences between memory and Mesos don't need repairing since the update handler applies what Mesos tells us.
+func (c *Checker) Check(ctx context.Context, repair bool) (*Report, error) {
+	c.checking.Lock()
+	defer c.checking.Unlock()
+
+	c.mutex.Lock()
+	leader := c.leader
+	c.mutex.Unlock()
+	if !leader {
+		return nil, errors.New("Consistency checks only run on the leader")
+	}
+
+	report := &Report{Started: time.Now(), Repair: repair, Discrepancies: []Discrepancy{}}
+
+	// Storage is read before memory since tasks are written to storage before they're added to or removed from memory.
+	values, err := c.storage.ReadAll(manager.TASK_DIRECTORY)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := make(map[string]stored)
+	for key, value := range values {
+		data, _, err := schema.Unwrap(value)
+		if err == nil {
+			var task *sdkTaskManager.Task
+			task, err = new(sdkTaskManager.Task).Decode(data)
+			if err == nil {
+				storage[task.Info.GetTaskId().GetValue()] = stored{key: key, task: task}
+				continue
+			}
+		}
+
+		report.Discrepancies = append(report.Discrepancies, Discrepancy{
+			TaskID: key,
+			Kind:   UNREADABLE,
+			Detail: err.Error(),
+		})
+	}
+
+	memory := make(map[string]*sdkTaskManager.Task)
+	tasks, _ := c.taskManager.All() // An empty task manager is reported as an error.
+	for _, task := range tasks {
+		memory[task.Info.GetTaskId().GetValue()] = task
+	}
+
+	// Only tasks that have been launched are known to Mesos.
+	toReconcile := []*mesos_v1.TaskInfo{}
+	for id, task := range memory {
+		if task.State != sdkTaskManager.UNKNOWN {
+			toReconcile = append(toReconcile, task.Info)
+		}
+
+		s, ok := storage[id]
+		if !ok {
+			report.Discrepancies = append(report.Discrepancies, c.persist(Discrepancy{
+				TaskID: id,
+				Name:   task.Info.GetName(),
+				Kind:   MISSING_FROM_STORAGE,
+				Detail: "Task is in memory but not in storage",
+			}, repair))
+		} else if s.task.State != task.State {
+			report.Discrepancies = append(report.Discrepancies, c.persist(Discrepancy{
+				TaskID: id,
+				Name:   task.Info.GetName(),
+				Kind:   STALE_STORAGE,
+				Detail: "Memory has " + task.State.String() + " but storage has " + s.task.State.String(),
+			}, repair))
+		}
+	}
+	for id, s := range storage {
+		if _, ok := memory[id]; !ok && s.task.State != sdkTaskManager.UNKNOWN {
+			toReconcile = append(toReconcile, s.task.Info)
+		}
+	}
+
+	statuses, err := c.reconcile(ctx, toReconcile)
+	if err != nil {
+		return nil, err
+	}
+
+	for id, task := range memory {
+		if task.State == sdkTaskManager.UNKNOWN {
+			continue
+		}
+
+		status := statuses[id]
+		if status == nil {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				TaskID: id,
+				Name:   task.Info.GetName(),
+				Kind:   UNANSWERED,
+				Detail: "Mesos didn't report on the task in time",
+			})
+		} else if status.GetState() != task.State {
+			report.Discrepancies = append(report.Discrepancies, Discrepancy{
+				TaskID: id,
+				Name:   task.Info.GetName(),
+				Kind:   STATE_MISMATCH,
+				Detail: "Memory has " + task.State.String() + " but Mesos has " + status.GetState().String(),
+			})
+		}
+	}
+
+	for id, s := range storage {
+		if _, ok := memory[id]; ok {
+			continue
+		}
+
+		d := Discrepancy{
+			TaskID: id,
+			Name:   s.task.Info.GetName(),
+			Kind:   MISSING_FROM_MEMORY,
+			Detail: "Task is in storage but not in memory",
+		}
+
+		status := statuses[id]
+		if status != nil && active(status.GetState()) {
+			d.Kind = ORPHANED
+			d.Detail = "Mesos has the task " + status.GetState().String() + " but we no longer track it"
+		}
+
+		report.Discrepancies = append(report.Discrepancies, c.remove(d, s, status, repair))
+	}
+
+	sort.Sort(byTaskID(report.Discrepancies))
+	report.Checked = len(memory) + len(storage)
+	report.Finished = time.Now()
+
+	return report, nil
+}
+
+// Asks Mesos about the given tasks and waits for it to answer for all of them, or until we time out.
+func (c *Checker) reconcile(ctx context.Context, tasks []*mesos_v1.TaskInfo) (map[string]*mesos_v1.TaskStatus, error) {
+	if len(tasks) == 0 {
+		return map[string]*mesos_v1.TaskStatus{}, nil
+	}
+
+	r := &round{statuses: make(map[string]*mesos_v1.TaskStatus), done: make(chan struct{})}
+	for _, task := range tasks {
+		if _, ok := r.statuses[task.GetTaskId().GetValue()]; !ok {
+			r.statuses[task.GetTaskId().GetValue()] = nil
+			r.remaining++
+		}
+	}
+
+	c.mutex.Lock()
+	c.round = r
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		c.round = nil
+		c.mutex.Unlock()
+	}()
+
+	_, err := c.scheduler.Reconcile(tasks)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-r.done:
+	case <-timer.C:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	statuses := make(map[string]*mesos_v1.TaskStatus, len(r.statuses))
+	for id, status := range r.statuses {
+		statuses[id] = status
+	}
+
+	return statuses, nil
+}
+
+// Writes what we have in memory back to storage.
+// The task is looked up again in case it changed or went away while we were checking.
+func (c *Checker) persist(d Discrepancy, repair bool) Discrepancy {
+	if !repair {
+		return d
+	}
+
+	task, err := c.taskManager.GetById(&mesos_v1.TaskID{Value: &d.TaskID})
+	if err != nil {
+		// Deleted since we looked, so there's nothing left to persist.
+		return d
+	}
+
+	err = c.taskManager.Update(task)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	d.Repaired = true
+	return d
+}
+
+// Kills a task that Mesos is still running for us, if any, and deletes what's left of it in storage.
+// Nothing is removed if the task showed up in memory while we were checking.
+func (c *Checker) remove(d Discrepancy, s stored, status *mesos_v1.TaskStatus, repair bool) Discrepancy {
+	if !repair {
+		return d
+	}
+
+	if _, err := c.taskManager.GetById(s.task.Info.GetTaskId()); err == nil {
+		return d
+	}
+
+	if d.Kind == ORPHANED {
+		_, err := c.scheduler.Kill(s.task.Info.GetTaskId(), status.GetAgentId())
+		if err != nil {
+			d.Error = err.Error()
+			return d
+		}
+	}
+
+	err := c.storage.Delete(s.key)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	d.Repaired = true
+	return d
+}
+
+// Determines if Mesos still considers the task to be running, or about to.
+func active(state mesos_v1.TaskState) bool {
+	switch state {
+	case mesos_v1.TaskState_TASK_STAGING,
+		mesos_v1.TaskState_TASK_STARTING,
+		mesos_v1.TaskState_TASK_RUNNING,
+		mesos_v1.TaskState_TASK_KILLING,
+		mesos_v1.TaskState_TASK_UNREACHABLE:
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,264 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consistency
+
+import (
+	"context"
+	"github.com/verizonlabs/hydrogen/task/manager"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	"github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	sched "github.com/verizonlabs/mesos-framework-sdk/scheduler/test"
+	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Answers reconciliation requests the way Mesos would, through the update handler.
+type reconciler struct {
+	sched.MockScheduler
+	checker *Checker
+	states  map[string]mesos_v1.TaskState
+	killed  []string
+}
+
+func (r *reconciler) Reconcile(tasks []*mesos_v1.TaskInfo) (*http.Response, error) {
+	for _, t := range tasks {
+		state, ok := r.states[t.GetTaskId().GetValue()]
+		if !ok {
+			continue
+		}
+
+		r.checker.Observe(&mesos_v1.TaskStatus{
+			TaskId:  t.GetTaskId(),
+			AgentId: &mesos_v1.AgentID{Value: utils.ProtoString("agent")},
+			State:   state.Enum(),
+			Reason:  mesos_v1.TaskStatus_REASON_RECONCILIATION.Enum(),
+		})
+	}
+
+	return nil, nil
+}
+
+func (r *reconciler) Kill(taskId *mesos_v1.TaskID, agentId *mesos_v1.AgentID) (*http.Response, error) {
+	r.killed = append(r.killed, taskId.GetValue())
+	return nil, nil
+}
+
+func task(id string, state mesos_v1.TaskState) *sdkTaskManager.Task {
+	return &sdkTaskManager.Task{
+		Info: &mesos_v1.TaskInfo{
+			Name:   utils.ProtoString(id),
+			TaskId: &mesos_v1.TaskID{Value: utils.ProtoString(id)},
+		},
+		State:     state,
+		Instances: 1,
+	}
+}
+
+func store(t *testing.T, kv *test.MockFailingKVStore, task *sdkTaskManager.Task) {
+	data, err := task.Encode()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	kv.Data[manager.TASK_DIRECTORY+task.Info.GetTaskId().GetValue()] = schema.Wrap(data)
+}
+
+// Builds a checker where memory, storage and Mesos disagree in every way we know about.
+func checkerFixture(t *testing.T) (*Checker, *reconciler, *test.MockFailingKVStore, sdkTaskManager.TaskManager) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{}}
+	store(t, kv, task("consistent", sdkTaskManager.RUNNING))
+	store(t, kv, task("stale", sdkTaskManager.STAGING))
+	store(t, kv, task("orphan", sdkTaskManager.RUNNING))
+	store(t, kv, task("ghost", sdkTaskManager.RUNNING))
+	store(t, kv, task("queued", sdkTaskManager.UNKNOWN))
+
+	s := persistence.NewPersistence(kv, "", persistence.RetryPolicy{})
+	tasks := manager.NewTaskManager(map[string]*sdkTaskManager.Task{
+		"consistent":  task("consistent", sdkTaskManager.RUNNING),
+		"stale":       task("stale", sdkTaskManager.RUNNING),
+		"unpersisted": task("unpersisted", sdkTaskManager.RUNNING),
+		"unanswered":  task("unanswered", sdkTaskManager.RUNNING),
+		"queued":      task("queued", sdkTaskManager.UNKNOWN),
+	}, s, new(mockLogger.MockLogger))
+	store(t, kv, task("unanswered", sdkTaskManager.RUNNING))
+
+	r := &reconciler{states: map[string]mesos_v1.TaskState{
+		"consistent":  sdkTaskManager.RUNNING,
+		"stale":       sdkTaskManager.RUNNING,
+		"unpersisted": sdkTaskManager.FAILED,
+		"orphan":      sdkTaskManager.RUNNING,
+		"ghost":       sdkTaskManager.LOST,
+	}}
+	c := NewChecker(tasks, s, r, 10*time.Millisecond, new(mockLogger.MockLogger))
+	r.checker = c
+
+	return c, r, kv, tasks
+}
+
+func kinds(report *Report) map[string]string {
+	found := make(map[string]string)
+	for _, d := range report.Discrepancies {
+		found[d.TaskID] = d.Kind
+	}
+
+	return found
+}
+
+// Ensures standbys refuse to check since they don't hold our tasks in memory.
+func TestChecker_NotLeader(t *testing.T) {
+	c, _, _, _ := checkerFixture(t)
+	if _, err := c.Check(context.Background(), false); err == nil {
+		t.Fatal("Checks should only be allowed on the leader")
+	}
+}
+
+// Makes sure every kind of discrepancy is reported and nothing is changed without asking for repairs.
+func TestChecker_Check(t *testing.T) {
+	c, r, kv, _ := checkerFixture(t)
+	c.Run(context.Background(), 0, false)
+
+	before := len(kv.Data)
+	report, err := c.Check(context.Background(), false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := map[string]string{
+		"stale":       STALE_STORAGE,
+		"unpersisted": STATE_MISMATCH,
+		"unanswered":  UNANSWERED,
+		"orphan":      ORPHANED,
+		"ghost":       MISSING_FROM_MEMORY,
+	}
+	found := kinds(report)
+	if len(found) != len(expected) {
+		t.Fatalf("Expected %d discrepancies, got %v", len(expected), found)
+	}
+	for id, kind := range expected {
+		if found[id] != kind {
+			t.Fatalf("Expected %s to be %s, got %s", id, kind, found[id])
+		}
+	}
+
+	for _, d := range report.Discrepancies {
+		if d.Repaired {
+			t.Fatalf("%s shouldn't have been repaired", d.TaskID)
+		}
+	}
+	if len(r.killed) != 0 || len(kv.Data) != before {
+		t.Fatal("Nothing should change without asking for repairs")
+	}
+}
+
+// Makes sure storage is brought back in line with memory and orphans are killed.
+func TestChecker_Repair(t *testing.T) {
+	c, r, kv, _ := checkerFixture(t)
+	c.Run(context.Background(), 0, false)
+
+	report, err := c.Check(context.Background(), true)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, d := range report.Discrepancies {
+		switch d.Kind {
+		case STATE_MISMATCH, UNANSWERED:
+			if d.Repaired {
+				t.Fatalf("%s is left to the update handler", d.TaskID)
+			}
+		default:
+			if !d.Repaired {
+				t.Fatalf("Expected %s to be repaired: %s", d.TaskID, d.Error)
+			}
+		}
+	}
+
+	if len(r.killed) != 1 || r.killed[0] != "orphan" {
+		t.Fatalf("Expected only the orphaned task to be killed, got %v", r.killed)
+	}
+	if _, ok := kv.Data[manager.TASK_DIRECTORY+"orphan"]; ok {
+		t.Fatal("The orphaned task should have been removed from storage")
+	}
+	if _, ok := kv.Data[manager.TASK_DIRECTORY+"ghost"]; ok {
+		t.Fatal("The ghost key should have been removed from storage")
+	}
+	if _, ok := kv.Data[manager.TASK_DIRECTORY+"unpersisted"]; !ok {
+		t.Fatal("The task missing from storage should have been persisted")
+	}
+
+	// Everything we can repair should be gone on the next check.
+	report, err = c.Check(context.Background(), false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, d := range report.Discrepancies {
+		if d.Kind != STATE_MISMATCH && d.Kind != UNANSWERED {
+			t.Fatalf("Expected %s to be repaired, still %s", d.TaskID, d.Kind)
+		}
+	}
+}
+
+// Ensures updates that aren't answers to our reconciliation are ignored.
+func TestChecker_Observe(t *testing.T) {
+	c, _, _, _ := checkerFixture(t)
+	c.round = &round{
+		statuses:  map[string]*mesos_v1.TaskStatus{"id": nil},
+		remaining: 1,
+		done:      make(chan struct{}),
+	}
+
+	status := &mesos_v1.TaskStatus{
+		TaskId: &mesos_v1.TaskID{Value: utils.ProtoString("id")},
+		State:  sdkTaskManager.RUNNING.Enum(),
+	}
+	c.Observe(status)
+	if c.round.remaining != 1 {
+		t.Fatal("Regular status updates shouldn't count as answers")
+	}
+
+	status.Reason = mesos_v1.TaskStatus_REASON_RECONCILIATION.Enum()
+	c.Observe(status)
+	c.Observe(status)
+	select {
+	case <-c.round.done:
+	default:
+		t.Fatal("The round should be done once every task has an answer")
+	}
+}
+
+// Checks stop being allowed once we stop leading.
+func TestChecker_RunStopped(t *testing.T) {
+	c, _, _, _ := checkerFixture(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.Run(ctx, 0, false)
+	if _, err := c.Check(context.Background(), false); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	cancel()
+	for i := 0; i < 100; i++ {
+		if _, err := c.Check(context.Background(), false); err != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Checks should be refused once we've stopped leading")
+}
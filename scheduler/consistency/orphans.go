@@ -0,0 +1,168 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consistency
+
+import (
+	"context"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"github.com/verizonlabs/mesos-framework-sdk/scheduler"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How often orphans are checked against the kill policy.
+const sweepInterval = time.Minute
+
+type (
+	// A task that Mesos runs for us but that we have no record of.
+	Orphan struct {
+		TaskID        string     `json:"taskId"`
+		AgentID       string     `json:"agentId"`
+		State         string     `json:"state"`
+		FirstSeen     time.Time  `json:"firstSeen"`
+		LastSeen      time.Time  `json:"lastSeen"`
+		KillRequested *time.Time `json:"killRequested,omitempty"`
+	}
+
+	// Controls whether orphans are killed and how long they're left alone first.
+	OrphanPolicy struct {
+		Kill  bool
+		Grace time.Duration
+	}
+
+	// Keeps track of tasks that Mesos tells us about through status updates but that we don't know.
+	// These are usually left over from a botched restore or a storage failure and would otherwise run forever.
+	Orphans struct {
+		mutex     sync.Mutex
+		orphans   map[string]*Orphan
+		scheduler scheduler.Scheduler
+		policy    OrphanPolicy
+		logger    logging.Logger
+	}
+
+	byOrphanID []Orphan
+)
+
+func (o byOrphanID) Len() int           { return len(o) }
+func (o byOrphanID) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o byOrphanID) Less(i, j int) bool { return o[i].TaskID < o[j].TaskID }
+
+// Returns an empty orphan tracker that kills orphans according to the given policy.
+func NewOrphans(s scheduler.Scheduler, policy OrphanPolicy, l logging.Logger) *Orphans {
+	return &Orphans{
+		orphans:   make(map[string]*Orphan),
+		scheduler: s,
+		policy:    policy,
+		logger:    l,
+	}
+}
+
+// Records a status update for a task we don't know.
+// Tasks that are no longer running are forgotten.
+func (o *Orphans) Track(status *mesos_v1.TaskStatus) {
+	id := status.GetTaskId().GetValue()
+	if id == "" {
+		return
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !active(status.GetState()) {
+		delete(o.orphans, id)
+		return
+	}
+
+	now := time.Now()
+	orphan, ok := o.orphans[id]
+	if !ok {
+		o.logger.Emit(
+			logging.ALARM,
+			"Mesos reports task %s on agent %s but we don't know about it",
+			id,
+			status.GetAgentId().GetValue(),
+		)
+		orphan = &Orphan{TaskID: id, FirstSeen: now}
+		o.orphans[id] = orphan
+	}
+
+	orphan.AgentID = status.GetAgentId().GetValue()
+	orphan.State = status.GetState().String()
+	orphan.LastSeen = now
+}
+
+// Gets every orphan we're tracking.
+func (o *Orphans) All() []Orphan {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	all := make([]Orphan, 0, len(o.orphans))
+	for _, orphan := range o.orphans {
+		all = append(all, *orphan)
+	}
+	sort.Sort(byOrphanID(all))
+
+	return all
+}
+
+// Kills orphans once they've outlived the grace period, if the policy allows it, until we're shut down.
+func (o *Orphans) Run(ctx context.Context) {
+	if !o.policy.Kill {
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			o.kill(now)
+		}
+	}
+}
+
+// Asks Mesos to kill every orphan that's been around longer than the grace period.
+// Orphans stay tracked until Mesos reports them as terminal, and kills are retried after another grace period.
+func (o *Orphans) kill(now time.Time) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for id, orphan := range o.orphans {
+		if now.Sub(orphan.FirstSeen) < o.policy.Grace {
+			continue
+		}
+		if orphan.KillRequested != nil && now.Sub(*orphan.KillRequested) < o.policy.Grace {
+			continue
+		}
+
+		o.logger.Emit(logging.INFO, "Killing orphaned task %s on agent %s", id, orphan.AgentID)
+		_, err := o.scheduler.Kill(
+			&mesos_v1.TaskID{Value: &orphan.TaskID},
+			&mesos_v1.AgentID{Value: &orphan.AgentID},
+		)
+		if err != nil {
+			o.logger.Emit(logging.ERROR, "Failed to kill orphaned task %s: %s", id, err.Error())
+			continue
+		}
+
+		requested := now
+		orphan.KillRequested = &requested
+	}
+}
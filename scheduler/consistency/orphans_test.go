@@ -0,0 +1,82 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consistency
+
+import (
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"testing"
+	"time"
+)
+
+func status(id string, state mesos_v1.TaskState) *mesos_v1.TaskStatus {
+	return &mesos_v1.TaskStatus{
+		TaskId:  &mesos_v1.TaskID{Value: utils.ProtoString(id)},
+		AgentId: &mesos_v1.AgentID{Value: utils.ProtoString("agent")},
+		State:   state.Enum(),
+	}
+}
+
+// Makes sure only running tasks are tracked and they're forgotten once they stop.
+func TestOrphans_Track(t *testing.T) {
+	o := NewOrphans(&reconciler{}, OrphanPolicy{}, new(mockLogger.MockLogger))
+
+	o.Track(status("b", mesos_v1.TaskState_TASK_RUNNING))
+	o.Track(status("a", mesos_v1.TaskState_TASK_STAGING))
+	o.Track(status("c", mesos_v1.TaskState_TASK_FINISHED))
+	o.Track(&mesos_v1.TaskStatus{State: mesos_v1.TaskState_TASK_RUNNING.Enum()})
+
+	all := o.All()
+	if len(all) != 2 || all[0].TaskID != "a" || all[1].TaskID != "b" {
+		t.Fatalf("Expected the two running tasks in order, got %v", all)
+	}
+
+	o.Track(status("b", mesos_v1.TaskState_TASK_KILLED))
+	if all := o.All(); len(all) != 1 || all[0].TaskID != "a" {
+		t.Fatalf("Expected the killed task to be forgotten, got %v", all)
+	}
+}
+
+// Ensures orphans are only killed after the grace period and kills are retried after another one.
+func TestOrphans_Kill(t *testing.T) {
+	r := &reconciler{}
+	o := NewOrphans(r, OrphanPolicy{Kill: true, Grace: time.Minute}, new(mockLogger.MockLogger))
+	o.Track(status("orphan", mesos_v1.TaskState_TASK_RUNNING))
+
+	now := time.Now()
+	o.kill(now)
+	if len(r.killed) != 0 {
+		t.Fatal("Orphans shouldn't be killed before the grace period is over")
+	}
+
+	o.kill(now.Add(time.Minute))
+	if len(r.killed) != 1 || r.killed[0] != "orphan" {
+		t.Fatalf("Expected the orphan to be killed, got %v", r.killed)
+	}
+	if o.All()[0].KillRequested == nil {
+		t.Fatal("Expected the kill to be recorded")
+	}
+
+	o.kill(now.Add(90 * time.Second))
+	if len(r.killed) != 1 {
+		t.Fatal("Kills shouldn't be retried before another grace period")
+	}
+
+	o.kill(now.Add(2 * time.Minute))
+	if len(r.killed) != 2 {
+		t.Fatal("Expected the kill to be retried")
+	}
+}
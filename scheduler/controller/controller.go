@@ -15,10 +15,17 @@
 package controller
 
 import (
+	"context"
 	scheduler "github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
 	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/master"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	"github.com/verizonlabs/hydrogen/task/manager"
 	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/encryption"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/logging"
@@ -26,8 +33,6 @@ import (
 	"github.com/verizonlabs/mesos-framework-sdk/scheduler/events"
 	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"os"
-	"os/signal"
-	"syscall"
 	"time"
 )
 
@@ -57,6 +62,18 @@ type (
 		storage     persistence.Storage
 		logger      logging.Logger
 		ha          *ha.HA
+		inventory   *agent.Inventory
+		pools       *ipam.Pools
+		migrator    *schema.Migrator
+		encrypted   *encryption.Encrypted
+		consistency *consistency.Checker
+		mirror      *mirror
+		master      *master.Client
+	}
+
+	// Event handlers that can run into something we can't keep leading after.
+	failing interface {
+		Failed() <-chan error
 	}
 )
 
@@ -67,7 +84,13 @@ func NewEventController(
 	manager sdkTaskManager.TaskManager,
 	storage persistence.Storage,
 	logger logging.Logger,
-	ha *ha.HA) *EventController {
+	ha *ha.HA,
+	inventory *agent.Inventory,
+	pools *ipam.Pools,
+	migrator *schema.Migrator,
+	encrypted *encryption.Encrypted,
+	consistency *consistency.Checker,
+	master *master.Client) *EventController {
 
 	return &EventController{
 		config:      config,
@@ -76,6 +99,13 @@ func NewEventController(
 		storage:     storage,
 		logger:      logger,
 		ha:          ha,
+		inventory:   inventory,
+		pools:       pools,
+		migrator:    migrator,
+		encrypted:   encrypted,
+		consistency: consistency,
+		mirror:      newMirror(),
+		master:      master,
 	}
 }
 
@@ -83,53 +113,166 @@ func NewEventController(
 // Main Run() function serves to run all the necessary logic
 // to set up the event controller to subscribe, and listen to events from
 // the mesos master in the cluster.
+// A leader that steps down goes back to being a standby and can be elected again later.
 // This method blocks forever, or until the scheduler is brought down.
 //
-func (s *EventController) Run(events chan *mesos_v1_scheduler.Event, revives chan *sdkTaskManager.Task, handler events.SchedulerEvent) {
+func (s *EventController) Run(ctx context.Context, events chan *mesos_v1_scheduler.Event, revives chan *sdkTaskManager.Task, handler events.SchedulerEvent) {
+	for ctx.Err() == nil {
+
+		// Standbys answer read-only API requests themselves so keep what they answer with from going stale.
+		// Anything still coming in from a term we stepped down from is no longer ours to handle.
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		discarded := make(chan struct{})
+		go s.standby(ctx, stop, stopped)
+		go s.discard(events, revives, stop, discarded)
+
+		// Block here until we become the leader.
+		// Standbys watch the leader's lease and start a new term once it expires.
+		s.logger.Emit(logging.INFO, "Starting leader election")
+		s.ha.Election(ctx)
+		close(stop)
+		<-stopped
+		<-discarded
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Everything we start while leading stops once we step down.
+		term, cancel := context.WithCancel(ctx)
+		successor := s.lead(term, events, revives, handler)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-s.ha.Lost():
+			// Someone else may already be leading so there's nobody to hand over to,
+			// and whatever we fail to clean up here runs out along with our lease.
+			if err := s.ha.Release(ctx, ""); err != nil {
+				s.logger.Emit(logging.ERROR, "Failed to clean up after losing leadership: %s", err.Error())
+			}
+		default:
+			// Let a standby take over and carry on as one ourselves.
+			if err := s.ha.Release(ctx, successor); err != nil {
+				s.logger.Emit(logging.ERROR, "Failed to step down as leader: %s", err.Error())
+				os.Exit(3)
+			}
+		}
+	}
+}
 
-	// Start the election.
-	s.logger.Emit(logging.INFO, "Starting leader election socket server")
-	go s.ha.Communicate()
+// Leads until we're asked to step down, lose leadership or are brought down, returning who we should hand over to.
+func (s *EventController) lead(ctx context.Context, events chan *mesos_v1_scheduler.Event, revives chan *sdkTaskManager.Task, handler events.SchedulerEvent) string {
 
-	// Block here until we either become a leader or a standby.
-	// If we are the leader we break out and continue to execute the rest of the scheduler.
-	// If we are a standby then we connect to the leader and wait for the process to start over again.
-	s.ha.Election()
+	// Someone else has taken over if we ever lose our lease, so anything we'd do from here on could clobber them.
+	// Everything we started for this term is stopped and we go back to standing by.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func(lost <-chan struct{}) {
+		select {
+		case <-lost:
+			s.logger.Emit(logging.ERROR, "We are no longer the leader, going back to standby")
+			cancel()
+		case <-ctx.Done():
+		}
+	}(s.ha.Lost())
 
 	// Get the frameworkId from etcd and set it to our frameworkID in our struct.
-	err := s.setFrameworkId()
+	err := s.setFrameworkId(ctx)
+	known := err == nil
 	if err != nil {
 		s.logger.Emit(logging.ERROR, "Failed to get the framework ID from persistent storage: %s", err.Error())
 	}
 
+	// Bring anything written by older versions up to date before we try to read it back.
+	s.logger.Emit(logging.INFO, "Running any pending storage migrations")
+	_, err = s.migrator.Run(ctx, false)
+	if err != nil {
+		s.logger.Emit(logging.ERROR, "Failed to migrate storage: %s", err.Error())
+		if ctx.Err() != nil {
+			// We lost leadership part way through taking over.
+			return ""
+		}
+		os.Exit(2)
+	}
+
 	// Recover our state (if any) in the event we (or the server) go down.
+	// If we were watching as a standby this only has to pick up what changed since we last saw it.
 	s.logger.Emit(logging.INFO, "Restoring any persisted state from data store")
-	err = s.restoreTasks()
+	changed, err := s.restoreTasks()
 	if err != nil {
 		s.logger.Emit(logging.INFO, "Failed to restore persisted state: %s", err.Error())
+		if ctx.Err() != nil {
+			// We lost leadership part way through taking over.
+			return ""
+		}
+		os.Exit(2)
+	}
+	s.logger.Emit(logging.INFO, "Verified tasks against the data store, %d had changed", changed)
+
+	// Addresses that are still held by running tasks must never be handed out again.
+	// This comes before dealing with an expired framework so the addresses its tasks held can be released.
+	err = s.pools.Restore(ctx)
+	if err != nil {
+		s.logger.Emit(logging.INFO, "Failed to restore IP pools: %s", err.Error())
+		if ctx.Err() != nil {
+			// We lost leadership part way through taking over.
+			return ""
+		}
 		os.Exit(2)
 	}
 
+	// Tasks from a framework that Mesos has already retired aren't running anymore.
+	if known && s.scheduler.FrameworkInfo().GetId() == nil {
+		if err := s.expired(ctx); err != nil {
+			s.logger.Emit(logging.ERROR, "Failed to handle tasks left over from an expired framework: %s", err.Error())
+			if ctx.Err() != nil {
+				// We lost leadership part way through taking over.
+				return ""
+			}
+			os.Exit(2)
+		}
+	}
+
+	// Operators expect blacklisted agents to stay blacklisted across failovers.
+	err = s.inventory.Restore(ctx)
+	if err != nil {
+		s.logger.Emit(logging.ERROR, "Failed to restore the agent blacklist: %s", err.Error())
+	}
+
+	// Only the leader writes so only the leader moves old values over to the newest key.
+	if s.encrypted != nil {
+		go s.encrypted.RunReencrypt(ctx, s.config.Persistence.Reencrypt)
+	}
+
+	// Our tasks are only in memory on the leader so that's the only place they can be checked.
+	s.logger.Emit(logging.INFO, "Starting consistency checks")
+	go s.consistency.Run(ctx, s.config.Scheduler.ConsistencyInterval, s.config.Scheduler.ConsistencyRepair)
+
 	// Kick off our scheduled reconciling.
 	s.logger.Emit(logging.INFO, "Starting periodic reconciler thread with a %g minute interval", s.config.Scheduler.ReconcileInterval.Minutes())
-	go s.periodicReconcile()
+	go s.periodicReconcile(ctx)
+
+	// A stream that stalls without dropping looks just like a quiet cluster so make sure heartbeats keep coming.
+	go s.master.Watch(ctx, s.config.Scheduler.HeartbeatMisses)
 
 	go func() {
 		for {
-			leader, err := s.ha.GetLeader()
-			if err != nil {
-				s.logger.Emit(logging.ERROR, "Failed to get leader information: %s", err.Error())
-				os.Exit(5)
+			// We should only ever reach here if our connection to Mesos dropped.
+			// If we stalled for long enough that a standby took over then subscribing would disconnect the new leader,
+			// and the two of us would keep disconnecting each other.
+			err := s.storage.Retry(ctx, s.ha.Fence)
+			if ctx.Err() != nil {
+				// We stepped down so whoever takes over subscribes instead.
+				return
 			}
-
-			// We should only ever reach here if we hit a network partition and the standbys lose connection to the leader.
-			// If this happens we need to check if there really is another leader alive that we just can't reach.
-			// If we wrongly think we are the leader and try to subscribe when there's already a leader then we will disconnect the leader.
-			// Both the leader and the incorrectly determined new leader will continue to disconnect each other.
-			if leader != s.config.Leader.IP {
-				s.logger.Emit(logging.ERROR, "We are not the leader so we should not be subscribing. "+
-					"This is most likely caused by a network partition between the leader and standbys")
-				os.Exit(1)
+			if err != nil {
+				// Our term is cancelled as soon as the lease monitor notices, so hold off until then.
+				s.logger.Emit(logging.ERROR, "We are not the leader so we should not be subscribing: %s", err.Error())
+				<-ctx.Done()
+				return
 			}
 
 			resp, err := s.scheduler.Subscribe(events)
@@ -140,27 +283,65 @@ func (s *EventController) Run(events chan *mesos_v1_scheduler.Event, revives cha
 					os.Exit(8)
 				}
 
-				time.Sleep(time.Duration(s.config.Scheduler.SubscribeRetry))
+			}
+
+			// Try the next master, backing off further each time none of them take us.
+			select {
+			case <-time.After(s.master.Disconnected()):
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
-	s.listen(events, revives, handler)
+	return s.listen(ctx, events, revives, handler)
 }
 
-// Listens for Mesos events, tasks that need to be revived, and signals and routes to the appropriate handler.
-func (s *EventController) listen(c chan *mesos_v1_scheduler.Event, r chan *sdkTaskManager.Task, h events.SchedulerEvent) {
-	sigs := make(chan os.Signal)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+// Listens for Mesos events and tasks that need to be revived and routes them to the appropriate handler.
+// Events are handled one at a time so stepping down or shutting down only takes effect once we're done with the current one.
+// Returns who we should hand over to once we've been asked to step down,
+// or nobody in particular if handling an event failed in a way we can't keep leading after.
+func (s *EventController) listen(ctx context.Context, c chan *mesos_v1_scheduler.Event, r chan *sdkTaskManager.Task, h events.SchedulerEvent) string {
+	var failed <-chan error
+	if f, ok := h.(failing); ok {
+		failed = f.Failed()
+	}
 
 	for {
 		select {
 		case event := <-c:
+			s.master.Received(event)
 			h.Run(event)
+
+			select {
+			case err := <-failed:
+				s.logger.Emit(logging.ERROR, "Stepping down as leader: %s", err.Error())
+				return ""
+			default:
+			}
 		case task := <-r:
 			h.Reschedule(task)
-		case <-sigs:
-			h.Signals()
+		case successor := <-s.ha.SteppingDown():
+			s.logger.Emit(logging.INFO, "Stepping down as leader")
+			return successor
+		case <-ctx.Done():
+			return ""
+		}
+	}
+}
+
+// Drops events and revives until told to stop.
+// Mesos keeps sending to a leader that stepped down until whoever takes over has subscribed,
+// and none of it is ours to act on anymore.
+func (s *EventController) discard(c chan *mesos_v1_scheduler.Event, r chan *sdkTaskManager.Task, stop, discarded chan struct{}) {
+	defer close(discarded)
+
+	for {
+		select {
+		case <-c:
+		case <-r:
+		case <-stop:
+			return
 		}
 	}
 }
@@ -168,31 +349,100 @@ func (s *EventController) listen(c chan *mesos_v1_scheduler.Event, r chan *sdkTa
 //
 // Get all of our persisted tasks, convert them back into TaskInfo's, and add them to our task manager.
 // If no tasks exist in the data store then we can consider this a fresh run and safely move on.
+// Only tasks that changed since we last looked are decoded. Returns how many were added, changed or removed.
 //
-func (s *EventController) restoreTasks() error {
+func (s *EventController) restoreTasks() (int, error) {
 	tasks, err := s.storage.ReadAll(manager.TASK_DIRECTORY)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	for _, value := range tasks {
-		task, err := new(sdkTaskManager.Task).Decode([]byte(value))
-		if err != nil {
-			return err
+	return s.mirror.sync(s.taskManager, tasks)
+}
+
+//
+// Keeps the tasks in memory in step with storage while we're a standby by watching the task directory,
+// so the API can answer read-only requests and taking over only has to verify what we already have.
+// The agent blacklist and IP pools are small enough that they're just reloaded every so often.
+// Stops once told to, which happens as soon as we win the election and before the leader restores anything itself.
+//
+func (s *EventController) standby(ctx context.Context, stop, stopped chan struct{}) {
+	defer close(stopped)
+
+	interval := s.config.Leader.StandbyRefresh
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Start watching before reading everything so nothing written in between is missed.
+		watch, cancel := context.WithCancel(ctx)
+		changes := s.storage.Watch(watch, manager.TASK_DIRECTORY)
+		if _, err := s.restoreTasks(); err != nil {
+			s.logger.Emit(logging.ERROR, "Failed to load tasks on standby: %s", err.Error())
+		}
+		s.reload(ctx)
+
+		if !s.follow(ctx, changes, ticker, stop) {
+			cancel()
+			return
 		}
+		cancel()
 
-		s.taskManager.Restore(task)
+		s.logger.Emit(logging.ERROR, "Stopped watching tasks on standby, loading them again")
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
+}
+
+// Applies changes to tasks until the watch ends, reloading everything else along the way.
+// Returns false once we should stop being a standby.
+func (s *EventController) follow(ctx context.Context, changes <-chan persistence.Operation, ticker *time.Ticker, stop chan struct{}) bool {
+	for {
+		select {
+		case op, ok := <-changes:
+			if !ok {
+				return ctx.Err() == nil
+			}
+			if err := s.mirror.apply(s.taskManager, op); err != nil {
+				s.logger.Emit(logging.ERROR, "Failed to apply change to %s on standby: %s", op.Key, err.Error())
+			}
+		case <-ticker.C:
+			s.reload(ctx)
+		case <-stop:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
 
-	return nil
+// Reloads the agent blacklist and IP pools.
+func (s *EventController) reload(ctx context.Context) {
+	if err := s.inventory.Restore(ctx); err != nil {
+		s.logger.Emit(logging.ERROR, "Failed to reload the agent blacklist on standby: %s", err.Error())
+	}
+	if err := s.pools.Restore(ctx); err != nil {
+		s.logger.Emit(logging.ERROR, "Failed to reload IP pools on standby: %s", err.Error())
+	}
 }
 
-// Keep our state in check by periodically reconciling.
-func (s *EventController) periodicReconcile() {
+// Keep our state in check by periodically reconciling until we stop leading.
+func (s *EventController) periodicReconcile(ctx context.Context) {
 	ticker := time.NewTicker(s.config.Scheduler.ReconcileInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			recon, err := s.taskManager.AllByState(sdkTaskManager.RUNNING)
 			if err != nil {
@@ -210,17 +460,66 @@ func (s *EventController) periodicReconcile() {
 	}
 }
 
+//
+// Deals with tasks we still have after our framework ID expired.
+// Mesos kills every task a framework runs once its failover timeout expires, so tasks we launched before then are gone
+// and subscribing as a new framework must not carry them over as if they were running.
+// They're either relaunched or forgotten depending on how we're configured.
+//
+func (s *EventController) expired(ctx context.Context) error {
+	tasks, err := s.taskManager.All()
+	if err != nil {
+		// There's nothing left over.
+		return nil
+	}
+
+	launched := []*sdkTaskManager.Task{}
+	for _, task := range tasks {
+		if task.State != sdkTaskManager.UNKNOWN {
+			launched = append(launched, task)
+		}
+	}
+	if len(launched) == 0 {
+		return nil
+	}
+
+	if !s.config.Scheduler.ExpiredRelaunch {
+		s.logger.Emit(logging.ALARM, "Our framework ID expired, forgetting %d tasks that Mesos has killed", len(launched))
+		if err := s.taskManager.Delete(launched...); err != nil {
+			return err
+		}
+		for _, task := range launched {
+			s.pools.Release(ctx, task.Info.GetTaskId().GetValue())
+		}
+
+		return nil
+	}
+
+	s.logger.Emit(logging.ALARM, "Our framework ID expired, relaunching %d tasks that Mesos has killed", len(launched))
+	for _, task := range launched {
+		task.State = sdkTaskManager.UNKNOWN
+	}
+
+	return s.taskManager.Update(launched...)
+}
+
 // Set our framework ID in memory from what's currently persisted.
 // The framework ID is needed by the scheduler for almost any call to Mesos.
-func (s *EventController) setFrameworkId() error {
-	policy := s.storage.CheckPolicy(nil)
-	return s.storage.RunPolicy(policy, func() error {
+func (s *EventController) setFrameworkId(ctx context.Context) error {
+	return s.storage.Retry(ctx, func() error {
 		id, err := s.storage.Read(frameworkIDKey)
 		if err != nil {
 			s.logger.Emit(logging.ERROR, "Failed to set the framework ID: %s", err.Error())
 			return err
 		}
 
+		// We've never subscribed, or our framework ID expired along with its lease.
+		// Either way we have to subscribe as a new framework.
+		if id == "" {
+			s.scheduler.FrameworkInfo().Id = nil
+			return nil
+		}
+
 		s.scheduler.FrameworkInfo().Id = &mesos_v1.FrameworkID{Value: &id}
 		return nil
 	})
@@ -15,6 +15,7 @@
 package controller
 
 import (
+	"context"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/logging"
@@ -26,11 +27,19 @@ import (
 	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
 	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/auth"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	"github.com/verizonlabs/hydrogen/scheduler/events"
 	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/master"
+	taskManager "github.com/verizonlabs/hydrogen/task/manager"
 	mockTaskManager "github.com/verizonlabs/hydrogen/task/manager/test"
 	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
 	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"testing"
 	"time"
 )
@@ -38,12 +47,19 @@ import (
 // TODO (tim): Factory function that takes in a list of broken items,
 // generates an event controller with those items broken.
 
+// Elections need storage that behaves like etcd, unlike the controller's mock storage.
+func leaderStorage() persistence.Storage {
+	return persistence.NewPersistence(&mockStorage.MockFailingKVStore{Data: map[string]string{}}, "", persistence.RetryPolicy{})
+}
+
 // Creates a new working event controller.
 func workingEventController() *EventController {
 	var (
 		cfg *scheduler.Configuration = &scheduler.Configuration{
 			Leader: &scheduler.LeaderConfiguration{
-				IP: "1", // Make sure we break out of our HA loop by matching on what mock storage gives us.
+				IP:            "1",
+				LeaseTTL:      time.Minute,
+				WatchInterval: time.Millisecond,
 			},
 			Executor:  &scheduler.ExecutorConfiguration{},
 			Scheduler: &scheduler.SchedulerConfiguration{ReconcileInterval: time.Nanosecond},
@@ -55,7 +71,7 @@ func workingEventController() *EventController {
 		m  manager.TaskManager    = &mockTaskManager.MockTaskManager{}
 		s  persistence.Storage    = &mockStorage.MockStorage{}
 		l  logging.Logger         = &mockLogger.MockLogger{}
-		ha                        = ha.NewHA(s, l, cfg.Leader)
+		ha                        = ha.NewHA(leaderStorage(), l, cfg.Leader)
 	)
 	return NewEventController(
 		cfg,
@@ -64,13 +80,19 @@ func workingEventController() *EventController {
 		s,
 		l,
 		ha,
+		agent.NewInventory(s, 0, agent.QuarantinePolicy{}, l),
+		ipam.NewPools(s, l),
+		schema.NewMigrator(s, nil, l),
+		nil,
+		consistency.NewChecker(m, s, sh, 0, l),
+		master.NewClient([]string{"http://127.0.0.1:5050/api/v1/scheduler"}, auth.NewBasic("", ""), time.Millisecond, time.Millisecond, l),
 	)
 }
 
 func brokenSchedulerEventController() *EventController {
 	var (
 		cfg *scheduler.Configuration = &scheduler.Configuration{
-			Leader:    &scheduler.LeaderConfiguration{},
+			Leader:    &scheduler.LeaderConfiguration{LeaseTTL: time.Minute, WatchInterval: time.Millisecond},
 			Executor:  &scheduler.ExecutorConfiguration{},
 			Scheduler: &scheduler.SchedulerConfiguration{ReconcileInterval: time.Nanosecond},
 			Persistence: &scheduler.PersistenceConfiguration{
@@ -81,7 +103,7 @@ func brokenSchedulerEventController() *EventController {
 		m  manager.TaskManager    = &mockTaskManager.MockTaskManager{}
 		s  persistence.Storage    = &mockStorage.MockStorage{}
 		l  logging.Logger         = &mockLogger.MockLogger{}
-		ha                        = ha.NewHA(s, l, cfg.Leader)
+		ha                        = ha.NewHA(leaderStorage(), l, cfg.Leader)
 	)
 	return NewEventController(
 		cfg,
@@ -90,6 +112,12 @@ func brokenSchedulerEventController() *EventController {
 		s,
 		l,
 		ha,
+		agent.NewInventory(s, 0, agent.QuarantinePolicy{}, l),
+		ipam.NewPools(s, l),
+		schema.NewMigrator(s, nil, l),
+		nil,
+		consistency.NewChecker(m, s, sh, 0, l),
+		master.NewClient([]string{"http://127.0.0.1:5050/api/v1/scheduler"}, auth.NewBasic("", ""), time.Millisecond, time.Millisecond, l),
 	)
 }
 
@@ -108,16 +136,18 @@ func TestEventController_Run(t *testing.T) {
 	ch := make(chan *mesos_v1_scheduler.Event)
 	r := mockResourceManager.MockResourceManager{}
 	v := make(chan *sdkTaskManager.Task)
-	h := events.NewHandler(ctrl.taskManager, r, ctrl.config, ctrl.scheduler, ctrl.storage, v, ctrl.logger)
-	go ctrl.Run(ch, v, h)
+	h := events.NewHandler(context.Background(), ctrl.taskManager, r, ctrl.config, ctrl.scheduler, ctrl.storage, v, queue.NewLaunchQueue(1), ctrl.inventory, ctrl.pools, ctrl.consistency, consistency.NewOrphans(ctrl.scheduler, consistency.OrphanPolicy{}, ctrl.logger), ctrl.logger)
+	go ctrl.Run(context.Background(), ch, v, h)
 }
 
 // Test our periodic reconciling.
 func TestEventController_periodicReconcile(t *testing.T) {
 	ctrl := workingEventController()
-	go ctrl.periodicReconcile()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ctrl.periodicReconcile(ctx)
 	broken := brokenSchedulerEventController()
-	go broken.periodicReconcile()
+	go broken.periodicReconcile(ctx)
 }
 
 func TestEventController_listen(t *testing.T) {
@@ -125,8 +155,8 @@ func TestEventController_listen(t *testing.T) {
 	ctrl := workingEventController()
 	r := mockResourceManager.MockResourceManager{}
 	v := make(chan *sdkTaskManager.Task)
-	h := events.NewHandler(ctrl.taskManager, r, ctrl.config, ctrl.scheduler, ctrl.storage, v, ctrl.logger)
-	go ctrl.Run(ch, v, h)
+	h := events.NewHandler(context.Background(), ctrl.taskManager, r, ctrl.config, ctrl.scheduler, ctrl.storage, v, queue.NewLaunchQueue(1), ctrl.inventory, ctrl.pools, ctrl.consistency, consistency.NewOrphans(ctrl.scheduler, consistency.OrphanPolicy{}, ctrl.logger), ctrl.logger)
+	go ctrl.Run(context.Background(), ch, v, h)
 
 	ch <- &mesos_v1_scheduler.Event{
 		Type: mesos_v1_scheduler.Event_SUBSCRIBED.Enum(),
@@ -165,3 +195,217 @@ func TestEventController_listen(t *testing.T) {
 		Update: &mesos_v1_scheduler.Event_Update{},
 	}
 }
+
+// A leader that can't save its framework ID stops leading instead of exiting.
+func TestEventController_listenFailed(t *testing.T) {
+	ch := make(chan *mesos_v1_scheduler.Event, 1)
+	ctrl := workingEventController()
+	r := mockResourceManager.MockResourceManager{}
+	v := make(chan *sdkTaskManager.Task)
+	broken := mockStorage.MockBrokenStorage{}
+	h := events.NewHandler(context.Background(), ctrl.taskManager, r, ctrl.config, ctrl.scheduler, broken, v, queue.NewLaunchQueue(1), ctrl.inventory, ctrl.pools, ctrl.consistency, consistency.NewOrphans(ctrl.scheduler, consistency.OrphanPolicy{}, ctrl.logger), ctrl.logger)
+
+	ch <- &mesos_v1_scheduler.Event{
+		Type: mesos_v1_scheduler.Event_SUBSCRIBED.Enum(),
+		Subscribed: &mesos_v1_scheduler.Event_Subscribed{
+			FrameworkId: &mesos_v1.FrameworkID{Value: utils.ProtoString("Test")},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if successor := ctrl.listen(ctx, ch, v, h); successor != "" || ctx.Err() != nil {
+		t.Fatalf("Expected to stop leading once the framework ID couldn't be saved, got %q and %v", successor, ctx.Err())
+	}
+}
+
+// Standbys keep reloading tasks from storage, including noticing ones that were removed.
+func TestEventController_standby(t *testing.T) {
+	ctrl := workingEventController()
+	ctrl.config.Leader.StandbyRefresh = time.Millisecond
+
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	ctrl.storage = persistence.NewPersistence(kv, "", persistence.RetryPolicy{})
+	ctrl.taskManager = taskManager.NewTaskManager(make(map[string]*manager.Task), ctrl.storage, ctrl.logger)
+	ctrl.inventory = agent.NewInventory(ctrl.storage, 0, agent.QuarantinePolicy{}, ctrl.logger)
+	ctrl.pools = ipam.NewPools(ctrl.storage, ctrl.logger)
+
+	data, err := (&manager.Task{Info: &mesos_v1.TaskInfo{Name: utils.ProtoString("a")}}).Encode()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	kv.Update(taskManager.TASK_DIRECTORY+"a", schema.Wrap(data))
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go ctrl.standby(context.Background(), stop, stopped)
+
+	wait := func(total int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for ctrl.taskManager.TotalTasks() != total {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d tasks on the standby, got %d", total, ctrl.taskManager.TotalTasks())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait(1)
+	kv.Delete(taskManager.TASK_DIRECTORY + "a")
+	wait(0)
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Standby never stopped")
+	}
+}
+
+// A leader that steps down stops handling anything and carries on as a standby.
+func TestEventController_StepDown(t *testing.T) {
+	ctrl := workingEventController()
+	ch := make(chan *mesos_v1_scheduler.Event)
+	r := mockResourceManager.MockResourceManager{}
+	v := make(chan *sdkTaskManager.Task)
+	h := events.NewHandler(context.Background(), ctrl.taskManager, r, ctrl.config, ctrl.scheduler, ctrl.storage, v, queue.NewLaunchQueue(1), ctrl.inventory, ctrl.pools, ctrl.consistency, consistency.NewOrphans(ctrl.scheduler, consistency.OrphanPolicy{}, ctrl.logger), ctrl.logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ctrl.Run(ctx, ch, v, h)
+		close(done)
+	}()
+
+	wait := func(f func() bool, msg string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !f() {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait(ctrl.ha.Leading, "Never became the leader")
+	if err := ctrl.ha.StepDown(""); err != nil {
+		t.Fatal(err.Error())
+	}
+	wait(func() bool { return ctrl.ha.Token() == 0 }, "Never stepped down")
+
+	// Whatever Mesos still sends us is dropped rather than handled.
+	select {
+	case ch <- &mesos_v1_scheduler.Event{Type: mesos_v1_scheduler.Event_HEARTBEAT.Enum()}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events aren't drained after stepping down")
+	}
+	wait(func() bool {
+		_, err := ctrl.consistency.Check(context.Background(), false)
+		return err != nil
+	}, "Consistency checks should stop once we've stepped down")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't stop after being cancelled")
+	}
+}
+
+// A leader that loses its lease stops handling anything and goes back to standing by instead of exiting.
+func TestEventController_Lost(t *testing.T) {
+	ctrl := workingEventController()
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	ctrl.ha = ha.NewHA(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), ctrl.logger, ctrl.config.Leader)
+	ch := make(chan *mesos_v1_scheduler.Event)
+	r := mockResourceManager.MockResourceManager{}
+	v := make(chan *sdkTaskManager.Task)
+	h := events.NewHandler(context.Background(), ctrl.taskManager, r, ctrl.config, ctrl.scheduler, ctrl.storage, v, queue.NewLaunchQueue(1), ctrl.inventory, ctrl.pools, ctrl.consistency, consistency.NewOrphans(ctrl.scheduler, consistency.OrphanPolicy{}, ctrl.logger), ctrl.logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ctrl.Run(ctx, ch, v, h)
+		close(done)
+	}()
+
+	wait := func(f func() bool, msg string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !f() {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait(ctrl.ha.Leading, "Never became the leader")
+
+	// Someone else wins a newer term behind our back.
+	kv.Update("/leader", `{"id":"other","token":1000}`)
+	wait(func() bool { return ctrl.ha.Token() == 0 }, "Never noticed leadership was lost")
+
+	select {
+	case ch <- &mesos_v1_scheduler.Event{Type: mesos_v1_scheduler.Event_HEARTBEAT.Enum()}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Events aren't drained after losing leadership")
+	}
+	wait(func() bool {
+		_, err := ctrl.consistency.Check(context.Background(), false)
+		return err != nil
+	}, "Consistency checks should stop once we've lost leadership")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't stop after being cancelled")
+	}
+}
+
+// Tasks left over from an expired framework are relaunched or forgotten, but never treated as running.
+// Forgotten tasks give their addresses back, relaunched ones keep them.
+func TestEventController_expired(t *testing.T) {
+	for _, relaunch := range []bool{true, false} {
+		ctrl := workingEventController()
+		ctrl.config.Scheduler.ExpiredRelaunch = relaunch
+		ctrl.storage = persistence.NewPersistence(&mockStorage.MockFailingKVStore{Data: map[string]string{}}, "", persistence.RetryPolicy{})
+		ctrl.taskManager = taskManager.NewTaskManager(make(map[string]*manager.Task), ctrl.storage, ctrl.logger)
+		ctrl.pools = ipam.NewPools(ctrl.storage, ctrl.logger)
+		if err := ctrl.pools.Create(context.Background(), ipam.Pool{Name: "test", Network: "cni", CIDR: "10.0.0.0/30"}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		for _, name := range []string{"launched", "queued"} {
+			task := manager.NewTask(&mesos_v1.TaskInfo{
+				Name:   utils.ProtoString(name),
+				TaskId: &mesos_v1.TaskID{Value: utils.ProtoString(name)},
+			}, manager.UNKNOWN, nil, nil, 1, manager.GroupInfo{})
+			if err := ctrl.taskManager.Add(task); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		launched, _ := ctrl.taskManager.Get(utils.ProtoString("launched"))
+		launched.State = manager.RUNNING
+		ctrl.taskManager.Update(launched)
+		if err := ctrl.pools.Attach(launched.Info, []string{"test"}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := ctrl.pools.Allocate(context.Background(), launched.Info); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := ctrl.expired(context.Background()); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		task, err := ctrl.taskManager.Get(utils.ProtoString("launched"))
+		if relaunch && (err != nil || task.State != manager.UNKNOWN) {
+			t.Fatal("Launched tasks should be relaunched")
+		}
+		if !relaunch && err == nil {
+			t.Fatal("Launched tasks should be forgotten")
+		}
+		if err := ctrl.pools.Delete(context.Background(), "test"); (err == nil) == relaunch {
+			t.Fatalf("Expected addresses to be released only when tasks are forgotten, relaunch %t: %v", relaunch, err)
+		}
+		if _, err := ctrl.taskManager.Get(utils.ProtoString("queued")); err != nil {
+			t.Fatal("Tasks that were never launched should be left alone")
+		}
+	}
+}
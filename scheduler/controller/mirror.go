@@ -0,0 +1,107 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/verizonlabs/hydrogen/task/manager"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+)
+
+//
+// Keeps the task manager in step with the tasks in storage.
+// Every value that's been applied is remembered so catching up with storage only has to decode what's changed,
+// which is what lets a standby that's been watching take over without restoring every task from scratch.
+// Task managers that can't forget tasks without deleting them from storage never have tasks removed.
+//
+type mirror struct {
+	values map[string]string // Raw values that have been applied, by storage key.
+	names  map[string]string // Names of the tasks they decoded to, by storage key.
+}
+
+func newMirror() *mirror {
+	return &mirror{
+		values: make(map[string]string),
+		names:  make(map[string]string),
+	}
+}
+
+// Applies a single change to the task manager.
+func (m *mirror) apply(t sdkTaskManager.TaskManager, op persistence.Operation) error {
+	switch op.Type {
+	case persistence.PUT:
+		if value, ok := m.values[op.Key]; ok && value == op.Value {
+			return nil
+		}
+
+		data, _, err := schema.Unwrap(op.Value)
+		if err != nil {
+			return err
+		}
+		task, err := new(sdkTaskManager.Task).Decode(data)
+		if err != nil {
+			return err
+		}
+
+		name := task.Info.GetName()
+		if old, ok := m.names[op.Key]; ok && old != name {
+			m.forget(t, old)
+		}
+		t.Restore(task)
+		m.values[op.Key] = op.Value
+		m.names[op.Key] = name
+	case persistence.DELETE:
+		if name, ok := m.names[op.Key]; ok {
+			m.forget(t, name)
+		}
+		delete(m.values, op.Key)
+		delete(m.names, op.Key)
+	}
+
+	return nil
+}
+
+// Brings the task manager in line with everything that's in storage.
+// Returns how many tasks were added, changed or removed.
+func (m *mirror) sync(t sdkTaskManager.TaskManager, all map[string]string) (int, error) {
+	changed := 0
+	for key, value := range all {
+		if old, ok := m.values[key]; ok && old == value {
+			continue
+		}
+		if err := m.apply(t, persistence.Operation{Type: persistence.PUT, Key: key, Value: value}); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	for key := range m.values {
+		if _, ok := all[key]; ok {
+			continue
+		}
+		if err := m.apply(t, persistence.Operation{Type: persistence.DELETE, Key: key}); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, nil
+}
+
+func (m *mirror) forget(t sdkTaskManager.TaskManager, name string) {
+	if f, ok := t.(manager.Forgetful); ok {
+		f.Forget(name)
+	}
+}
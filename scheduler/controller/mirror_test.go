@@ -0,0 +1,82 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/verizonlabs/hydrogen/task/manager"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"testing"
+)
+
+func stored(t *testing.T, name, cmd string) string {
+	data, err := (&sdkTaskManager.Task{Info: &mesos_v1.TaskInfo{
+		Name:    utils.ProtoString(name),
+		Command: &mesos_v1.CommandInfo{Value: utils.ProtoString(cmd)},
+	}}).Encode()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return schema.Wrap(data)
+}
+
+func TestMirror(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	m := manager.NewTaskManager(make(map[string]*sdkTaskManager.Task), persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), new(mockLogger.MockLogger))
+	mirror := newMirror()
+
+	all := map[string]string{
+		"/tasks/a": stored(t, "a", "sleep 1"),
+		"/tasks/b": stored(t, "b", "sleep 1"),
+	}
+	changed, err := mirror.sync(m, all)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if changed != 2 || m.TotalTasks() != 2 {
+		t.Fatalf("Expected both tasks to be loaded, %d changed and %d in memory", changed, m.TotalTasks())
+	}
+
+	// Nothing has to be decoded again unless it changed.
+	all["/tasks/a"] = stored(t, "a", "sleep 2")
+	changed, err = mirror.sync(m, all)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	task, _ := m.Get(utils.ProtoString("a"))
+	if changed != 1 || task.Info.GetCommand().GetValue() != "sleep 2" {
+		t.Fatalf("Expected only the changed task to be applied, %d changed", changed)
+	}
+
+	if err := mirror.apply(m, persistence.Operation{Type: persistence.DELETE, Key: "/tasks/b"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if m.TotalTasks() != 1 {
+		t.Fatalf("Expected the deleted task to be forgotten, got %d tasks", m.TotalTasks())
+	}
+
+	if err := mirror.apply(m, persistence.Operation{Type: persistence.PUT, Key: "/tasks/c", Value: "junk"}); err == nil {
+		t.Fatal("Values that can't be decoded should be reported")
+	}
+	if len(kv.Data) != 0 {
+		t.Fatalf("Mirroring should never write to storage, got %v", kv.Data)
+	}
+}
@@ -15,48 +15,85 @@
 package events
 
 import (
+	"context"
 	sched "github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/logging"
 	resourceManager "github.com/verizonlabs/mesos-framework-sdk/resources/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/scheduler/events"
 	taskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
-	"os"
 	"sync"
 )
 
 // Handler contains various event handlers and holds data that callbacks need to access/modify.
 type Handler struct {
+	ctx             context.Context
 	taskManager     taskManager.TaskManager
 	resourceManager resourceManager.ResourceManager
 	config          *sched.Configuration
 	scheduler       scheduler.Scheduler
 	storage         persistence.Storage
 	revive          chan *taskManager.Task
+	queue           *queue.LaunchQueue
+	inventory       *agent.Inventory
+	pools           *ipam.Pools
+	consistency     *consistency.Checker
+	orphans         *consistency.Orphans
 	logger          logging.Logger
 	frameworkLease  int64
+	failed          chan error
 	sync.RWMutex
 }
 
 // NewEvent returns a new Event type which adheres to the SchedulerEvent interface.
-func NewHandler(t taskManager.TaskManager,
+func NewHandler(ctx context.Context,
+	t taskManager.TaskManager,
 	r resourceManager.ResourceManager,
 	c *sched.Configuration,
 	s scheduler.Scheduler,
 	o persistence.Storage,
 	v chan *taskManager.Task,
+	q *queue.LaunchQueue,
+	a *agent.Inventory,
+	i *ipam.Pools,
+	k *consistency.Checker,
+	n *consistency.Orphans,
 	l logging.Logger) events.SchedulerEvent {
 
 	return &Handler{
+		ctx:             ctx,
 		taskManager:     t,
 		resourceManager: r,
 		config:          c,
 		scheduler:       s,
 		storage:         o,
 		revive:          v,
+		queue:           q,
+		inventory:       a,
+		pools:           i,
+		consistency:     k,
+		orphans:         n,
 		logger:          l,
+		failed:          make(chan error, 1),
+	}
+}
+
+// Failed receives anything that happened while handling an event that we can't keep leading after.
+func (h *Handler) Failed() <-chan error {
+	return h.failed
+}
+
+// Reports a failure without waiting for it to be received, one is enough to stop leading.
+func (h *Handler) fail(err error) {
+	select {
+	case h.failed <- err:
+	default:
 	}
 }
 
@@ -82,34 +119,41 @@ func (h *Handler) Run(event *mesos_v1_scheduler.Event) {
 	case mesos_v1_scheduler.Event_UPDATE:
 		h.Update(event.GetUpdate())
 	case mesos_v1_scheduler.Event_HEARTBEAT:
-		h.refreshFrameworkIdLease()
+		h.refreshFrameworkIdLease(h.ctx)
 	case mesos_v1_scheduler.Event_UNKNOWN:
 		h.logger.Emit(logging.ALARM, "Unknown event received")
 	}
 
 }
 
+//
+// Signals is called once we're shutting down and the event loop has stopped.
+// Any offers we're still holding are declined so Mesos can hand them to other frameworks straight away.
+// Our framework ID lease is refreshed so the countdown until it expires starts from when we actually stopped.
+//
 func (h *Handler) Signals() {
-	h.RLock()
-	if h.frameworkLease == 0 {
-		os.Exit(0)
+	err := h.declineOffers(h.resourceManager.Offers(), refuseSeconds)
+	if err != nil {
+		h.logger.Emit(logging.ERROR, "Failed to decline held offers before exiting: %s", err.Error())
 	}
+
+	h.RLock()
+	lease := h.frameworkLease
 	h.RUnlock()
+	if lease == 0 {
+		return
+	}
 
-	// Refresh our lease before we die so that we start an accurate countdown.
-	err := h.refreshFrameworkIdLease()
+	// Our context is cancelled as part of shutting down so this last refresh can't use it.
+	err = h.refreshFrameworkIdLease(context.Background())
 	if err != nil {
-		h.logger.Emit(logging.ERROR, "Failed to refresh leader lease before exiting: %s", err.Error())
-		os.Exit(6)
+		h.logger.Emit(logging.ERROR, "Failed to refresh framework ID lease before exiting: %s", err.Error())
 	}
-
-	os.Exit(0)
 }
 
 // Refreshes the lifetime of our persisted framework ID.
-func (h *Handler) refreshFrameworkIdLease() error {
-	policy := h.storage.CheckPolicy(nil)
-	return h.storage.RunPolicy(policy, func() error {
+func (h *Handler) refreshFrameworkIdLease(ctx context.Context) error {
+	return h.storage.Retry(ctx, func() error {
 		h.RLock()
 		err := h.storage.RefreshLease(h.frameworkLease)
 		if err != nil {
@@ -15,25 +15,36 @@
 package events
 
 import (
+	"context"
 	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
 	mockResourceManager "github.com/verizonlabs/mesos-framework-sdk/resources/manager/test"
 	sched "github.com/verizonlabs/mesos-framework-sdk/scheduler/test"
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	mockTaskManager "github.com/verizonlabs/hydrogen/task/manager/test"
 	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"testing"
 )
 
 // Tests creation of a new handler.
 func TestHandler_NewHandler(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	if e == nil {
@@ -44,12 +55,18 @@ func TestHandler_NewHandler(t *testing.T) {
 // Ensure our signal handlers are valid.
 func TestHandler_Signals(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	e.Signals()
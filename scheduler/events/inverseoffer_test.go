@@ -15,6 +15,7 @@
 package events
 
 import (
+	"context"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
@@ -23,19 +24,29 @@ import (
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
 	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	mockTaskManager "github.com/verizonlabs/hydrogen/task/manager/test"
 	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"testing"
 )
 
 func TestHandler_InverseOffer(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	e.InverseOffer(&mesos_v1_scheduler.Event_InverseOffers{
@@ -51,12 +62,18 @@ func TestHandler_InverseOffer(t *testing.T) {
 
 func TestHandler_InverseOfferWithNilOffer(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	e.InverseOffer(&mesos_v1_scheduler.Event_InverseOffers{
@@ -15,6 +15,7 @@
 package events
 
 import (
+	"context"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
@@ -23,20 +24,30 @@ import (
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
 	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	mockTaskManager "github.com/verizonlabs/hydrogen/task/manager/test"
 	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"testing"
 )
 
 // Test that we can pass a message.
 func TestHandler_Message(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	e.Message(&mesos_v1_scheduler.Event_Message{
@@ -49,12 +60,18 @@ func TestHandler_Message(t *testing.T) {
 // Test if we send an empty message
 func TestHandler_MessageNoData(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	e.Message(&mesos_v1_scheduler.Event_Message{
@@ -66,12 +83,18 @@ func TestHandler_MessageNoData(t *testing.T) {
 // Test what we do if we get a nil message
 func TestHandler_NilMessage(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	e.Message(nil)
@@ -80,12 +103,18 @@ func TestHandler_NilMessage(t *testing.T) {
 // Test if we get a nil agent or nil value within the agent protobuf.
 func TestHandler_MessageWithNoAgent(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	e.Message(&mesos_v1_scheduler.Event_Message{
@@ -103,12 +132,18 @@ func TestHandler_MessageWithNoAgent(t *testing.T) {
 // Test if we get a nil executor or nil value inside the protobuf.
 func TestHandler_MessageWithNoExecutor(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 	e.Message(&mesos_v1_scheduler.Event_Message{
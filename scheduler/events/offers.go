@@ -15,6 +15,9 @@
 package events
 
 import (
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/ports"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/logging"
@@ -22,7 +25,9 @@ import (
 	"github.com/verizonlabs/mesos-framework-sdk/scheduler/strategy"
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -37,6 +42,14 @@ const (
 // master.
 //
 func (e *Handler) Offers(offerEvent *mesos_v1_scheduler.Event_Offers) {
+	e.inventory.Observe(offerEvent.GetOffers())
+
+	// Offers from blacklisted agents are never used, tell Mesos to hold them back for a while.
+	offers, blacklisted := e.inventory.Filter(offerEvent.GetOffers())
+	err := e.declineOffers(blacklisted, e.inventory.RefuseSeconds())
+	if err != nil {
+		e.logger.Emit(logging.ERROR, "Failed to decline offers from blacklisted agents: %s", err.Error())
+	}
 
 	// Check if we have any in the task manager we want to launch
 	queued, err := e.taskManager.AllByState(manager.UNKNOWN)
@@ -48,7 +61,7 @@ func (e *Handler) Offers(offerEvent *mesos_v1_scheduler.Event_Offers) {
 			e.logger.Emit(logging.ERROR, "Failed to suppress offers: %s", err.Error())
 		}
 
-		err = e.declineOffers(offerEvent.GetOffers(), refuseSeconds)
+		err = e.declineOffers(offers, refuseSeconds)
 		if err != nil {
 			e.logger.Emit(logging.ERROR, "Failed to decline offers: %s", err.Error())
 		}
@@ -57,30 +70,45 @@ func (e *Handler) Offers(offerEvent *mesos_v1_scheduler.Event_Offers) {
 	}
 
 	// Update our resources in the manager
-	e.resourceManager.AddOffers(offerEvent.GetOffers())
+	e.resourceManager.AddOffers(offers)
 	accepts := make(map[*mesos_v1.OfferID][]*mesos_v1.Offer_Operation)
 
-	for _, task := range queued {
+	for i, task := range queued {
+		name := task.Info.GetName()
+
 		// If we've hit max retries of a task, kill itself.
 		if task.IsKill {
 			e.taskManager.Delete(task)
+			e.pools.Release(e.ctx, task.Info.GetTaskId().GetValue())
+			e.queue.Remove(name)
 			continue
 		}
 
 		if !e.resourceManager.HasResources() {
+			// Nothing left for the rest of the queue to be matched against.
+			for _, t := range queued[i:] {
+				e.queue.Miss(t.Info.GetName(), queue.Miss{Reason: queue.NoOffers, Time: time.Now()})
+			}
 			break
 		}
 
-		offer, err := e.resourceManager.Assign(task)
+		offer, skipped, err := e.assign(task)
 
 		if err != nil {
 			// It didn't match any offers.
 			e.logger.Emit(logging.ERROR, err.Error())
+			e.queue.Miss(name, append(queue.Diagnose(task, e.resourceManager.Offers(), err), skipped...)...)
 			task.Reschedule(e.revive)
 			continue
 		}
 
 		if !e.applyStrategy(task, offer) {
+			e.queue.Miss(name, queue.Miss{
+				Reason: queue.StrategyConflict,
+				Detail: "strategy " + task.Strategy.Type + " rejected the agent",
+				Agents: []string{offer.GetHostname()},
+				Time:   time.Now(),
+			})
 			continue
 		}
 		mesosTask := task.Info
@@ -93,6 +121,37 @@ func (e *Handler) Offers(offerEvent *mesos_v1_scheduler.Event_Offers) {
 			Container:   mesosTask.GetContainer(),
 			Resources:   mesosTask.GetResources(),
 			HealthCheck: mesosTask.GetHealthCheck(),
+			Labels:      mesosTask.GetLabels(),
+			Discovery:   mesosTask.GetDiscovery(),
+		}
+
+		// Carve out host ports and let the task know which ones it got.
+		// The offer was already popped by assign so it goes back if we can't use it,
+		// otherwise it would never be used by another task or declined.
+		err = ports.Allocate(t, offer)
+		if err != nil {
+			e.logger.Emit(logging.ERROR, "Failed to allocate ports for task %s: %s", name, err.Error())
+			e.queue.Miss(name, queue.Miss{
+				Reason: queue.InsufficientPorts,
+				Detail: err.Error(),
+				Agents: []string{offer.GetHostname()},
+				Time:   time.Now(),
+			})
+			e.resourceManager.AddOffers(append(e.resourceManager.Offers(), offer))
+			continue
+		}
+
+		// Give the task its own address on every network backed by an IP pool.
+		err = e.pools.Allocate(e.ctx, t)
+		if err != nil {
+			e.logger.Emit(logging.ERROR, "Failed to allocate addresses for task %s: %s", name, err.Error())
+			e.queue.Miss(name, queue.Miss{
+				Reason: queue.NoAddresses,
+				Detail: err.Error(),
+				Time:   time.Now(),
+			})
+			e.resourceManager.AddOffers(append(e.resourceManager.Offers(), offer))
+			continue
 		}
 
 		if e.config.Executor.CustomExecutor && t.Executor == nil {
@@ -106,6 +165,7 @@ func (e *Handler) Offers(offerEvent *mesos_v1_scheduler.Event_Offers) {
 		if err != nil {
 			e.logger.Emit(logging.ERROR, "Failed to update task: %s", err.Error())
 		}
+		e.queue.Remove(name)
 
 		accepts[offer.Id] = append(accepts[offer.Id], resources.LaunchOfferOperation([]*mesos_v1.TaskInfo{t}))
 	}
@@ -127,6 +187,77 @@ func (e *Handler) Offers(offerEvent *mesos_v1_scheduler.Event_Offers) {
 	}
 }
 
+//
+// Assigns the task to an offer while skipping agents that are quarantined for the task's app
+// and offers that don't have the host ports the task needs.
+// Skipped offers are handed back to the resource manager so other tasks can still use them.
+// The reasons offers were skipped are returned so they can be reported if nothing else matched.
+//
+func (e *Handler) assign(task *manager.Task) (*mesos_v1.Offer, []queue.Miss, error) {
+	app := appName(task)
+	skipped := []*mesos_v1.Offer{}
+	defer func() {
+		if len(skipped) > 0 {
+			e.resourceManager.AddOffers(append(e.resourceManager.Offers(), skipped...))
+		}
+	}()
+
+	quarantined := []string{}
+	noPorts := []string{}
+
+	// Every call to Assign pops the matched offer so we never need more attempts than there are offers.
+	var err error
+	for attempts := len(e.resourceManager.Offers()); attempts >= 0; attempts-- {
+		var offer *mesos_v1.Offer
+		offer, err = e.resourceManager.Assign(task)
+		if err != nil {
+			break
+		}
+
+		switch {
+		case e.inventory.Quarantined(offer.GetAgentId().GetValue(), app):
+			quarantined = append(quarantined, offer.GetHostname())
+		case !ports.Fits(task.Info, offer):
+			noPorts = append(noPorts, offer.GetHostname())
+		default:
+			return offer, nil, nil
+		}
+		skipped = append(skipped, offer)
+	}
+	if err == nil {
+		err = errors.New("No usable offers match task " + task.Info.GetName())
+	}
+
+	misses := []queue.Miss{}
+	if len(quarantined) > 0 {
+		misses = append(misses, queue.Miss{
+			Reason: queue.Quarantined,
+			Detail: "agents are quarantined after repeated failures",
+			Agents: quarantined,
+			Time:   time.Now(),
+		})
+	}
+	if len(noPorts) > 0 {
+		misses = append(misses, queue.Miss{
+			Reason: queue.InsufficientPorts,
+			Detail: "requires " + strconv.Itoa(len(task.Info.GetDiscovery().GetPorts().GetPorts())) + " host ports",
+			Agents: noPorts,
+			Time:   time.Now(),
+		})
+	}
+
+	return nil, misses, err
+}
+
+// Instances of the same app share their group name, standalone tasks are identified by their own name.
+func appName(task *manager.Task) string {
+	if task.GroupInfo.InGroup {
+		return strings.TrimSuffix(task.GroupInfo.GroupName, "/")
+	}
+
+	return task.Info.GetName()
+}
+
 func (e *Handler) setupExecutor(t *mesos_v1.TaskInfo) {
 	// If we're using our custom executor then make sure we remove the original CommandInfo.
 	// Set up our ExecutorInfo and pass the user's command as data to the executor.
@@ -156,12 +287,17 @@ func (e *Handler) setupExecutor(t *mesos_v1.TaskInfo) {
 		protocol = "https"
 	}
 
-	t.Executor.Command.Environment = &mesos_v1.Environment{Variables: []*mesos_v1.Environment_Variable{
-		{
-			Name:  utils.ProtoString("PROTOCOL"),
-			Value: utils.ProtoString(protocol),
-		},
-	}}
+	// Keep the task's own environment, including its port variables, so the executor can pass it on.
+	vars := []*mesos_v1.Environment_Variable{}
+	for _, v := range t.Executor.Command.GetEnvironment().GetVariables() {
+		if v.GetName() != "PROTOCOL" {
+			vars = append(vars, v)
+		}
+	}
+	t.Executor.Command.Environment = &mesos_v1.Environment{Variables: append(vars, &mesos_v1.Environment_Variable{
+		Name:  utils.ProtoString("PROTOCOL"),
+		Value: utils.ProtoString(protocol),
+	})}
 	t.Command = nil
 }
 
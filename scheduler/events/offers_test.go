@@ -15,6 +15,7 @@
 package events
 
 import (
+	"context"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
@@ -23,19 +24,29 @@ import (
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
 	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	mockTaskManager "github.com/verizonlabs/hydrogen/task/manager/test"
 	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"testing"
 )
 
 func TestHandler_Offers(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 
@@ -71,12 +82,18 @@ func TestHandler_Offers(t *testing.T) {
 
 func TestHandler_OffersWithQueuedTasks(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 
@@ -109,3 +126,52 @@ func TestHandler_OffersWithQueuedTasks(t *testing.T) {
 		Offers: offers,
 	})
 }
+
+// The custom executor is given the task's environment along with its own settings.
+func TestHandler_setupExecutor(t *testing.T) {
+	config := &scheduler.Configuration{Executor: &scheduler.ExecutorConfiguration{
+		CustomExecutor: true,
+		Name:           "Oxygen",
+		Command:        "executor",
+		URI:            "http://127.0.0.1:8081/executor",
+		TLS:            true,
+	}}
+	e := NewHandler(
+		context.Background(),
+		mockTaskManager.MockTaskManager{},
+		mockResourceManager.MockResourceManager{},
+		config,
+		sched.MockScheduler{},
+		&mockStorage.MockStorage{},
+		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
+		&mockLogger.MockLogger{},
+	).(*Handler)
+
+	info := &mesos_v1.TaskInfo{
+		Name: utils.ProtoString("test"),
+		Command: &mesos_v1.CommandInfo{
+			Value: utils.ProtoString("echo"),
+			Environment: &mesos_v1.Environment{Variables: []*mesos_v1.Environment_Variable{
+				{Name: utils.ProtoString("PORT_HTTP"), Value: utils.ProtoString("31000")},
+				{Name: utils.ProtoString("PROTOCOL"), Value: utils.ProtoString("gopher")},
+			}},
+		},
+	}
+	e.setupExecutor(info)
+
+	if info.Command != nil || string(info.GetExecutor().GetData()) != "echo" {
+		t.Fatal("Expected the task's command to be handed to the executor")
+	}
+	env := make(map[string]string)
+	for _, v := range info.GetExecutor().GetCommand().GetEnvironment().GetVariables() {
+		env[v.GetName()] = v.GetValue()
+	}
+	if len(env) != 2 || env["PORT_HTTP"] != "31000" || env["PROTOCOL"] != "https" {
+		t.Fatalf("Unexpected executor environment %v", env)
+	}
+}
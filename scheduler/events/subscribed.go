@@ -19,12 +19,12 @@ import (
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/logging"
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
-	"os"
 )
 
 // Subscribed is a public method that handles subscription events from the master.
 // We handle our subscription by writing the framework ID to storage.
 // Then we gather all of our launched non-terminal tasks and reconcile them explicitly.
+// If the framework ID can't be saved we can't lead safely, so the failure is reported through Failed.
 func (h *Handler) Subscribed(subEvent *mesos_v1_scheduler.Event_Subscribed) {
 	id := subEvent.GetFrameworkId()
 	idVal := id.GetValue()
@@ -34,13 +34,17 @@ func (h *Handler) Subscribed(subEvent *mesos_v1_scheduler.Event_Subscribed) {
 	err := h.createFrameworkIdLease(idVal)
 	if err != nil {
 		h.logger.Emit(logging.ERROR, "Failed to persist leader information: %s", err.Error())
-		os.Exit(3)
+		h.fail(err)
+		return
 	}
 
 	h.scheduler.Revive() // Reset to revive offers regardless if there are tasks or not.
 	// We do this to force a check for any tasks that we might have missed during downtime.
 	// Reconcile after we subscribe in case we resubscribed due to a failure.
 
+	// Implicit reconciliation has Mesos report every task it runs for us, including any we've lost track of.
+	h.scheduler.Reconcile([]*mesos_v1.TaskInfo{})
+
 	// Get all launched non-terminal tasks.
 	launched, err := h.taskManager.AllByState(manager.RUNNING)
 	if err != nil {
@@ -58,8 +62,7 @@ func (h *Handler) Subscribed(subEvent *mesos_v1_scheduler.Event_Subscribed) {
 
 // Create and persist our framework ID with an attached lifetime.
 func (h *Handler) createFrameworkIdLease(idVal string) error {
-	policy := h.storage.CheckPolicy(nil)
-	return h.storage.RunPolicy(policy, func() error {
+	return h.storage.Retry(h.ctx, func() error {
 		lease, err := h.storage.CreateWithLease("/frameworkId", idVal, int64(h.scheduler.FrameworkInfo().GetFailoverTimeout()))
 		if err != nil {
 			h.logger.Emit(logging.ERROR, "Failed to save framework ID of %s to persistent data store", idVal)
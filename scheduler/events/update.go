@@ -46,13 +46,22 @@ func (e *Handler) Update(updateEvent *mesos_v1_scheduler.Event_Update) {
 		}
 	}()
 
+	// Answers to explicit reconciliation are also how consistency checks learn what Mesos is running.
+	e.consistency.Observe(status)
+
 	task, err := e.taskManager.GetById(taskID)
 	if err != nil {
+		// Tasks we've already forgotten about don't need their addresses anymore once they've stopped.
+		if terminal(status.GetState()) {
+			e.pools.Release(e.ctx, taskID.GetValue())
+		}
+
 		// The event is from a task that has been deleted from the task manager,
-		// ignore updates.
+		// or one we lost track of. Keep an eye on it in case it's still running.
 		// NOTE (tim): Do we want to keep deleted task history for a certain amount of time
 		// before it's deleted? We would record status updates after it's killed here.
 		// ACK update, return.
+		e.orphans.Track(status)
 		return
 	}
 
@@ -61,6 +70,12 @@ func (e *Handler) Update(updateEvent *mesos_v1_scheduler.Event_Update) {
 	taskIdVal := taskID.GetValue()
 	agentIdVal := agentID.GetValue()
 
+	// Tasks that are no longer running don't need their addresses anymore, even if we fail to update them below.
+	// A late update from an earlier launch of the same task must not free what the current launch holds.
+	if terminal(state) && current(task, status) {
+		e.pools.Release(e.ctx, taskIdVal)
+	}
+
 	// Update the state of the task.
 	task.State = state
 	err = e.taskManager.Update(task)
@@ -73,6 +88,9 @@ func (e *Handler) Update(updateEvent *mesos_v1_scheduler.Event_Update) {
 	switch state {
 	case mesos_v1.TaskState_TASK_FAILED:
 		e.logger.Emit(logging.ERROR, "Task %s failed: %s", taskIdVal, message)
+
+		// Keep track of where tasks fail so we can stop placing them on bad agents.
+		e.inventory.Fail(agentIdVal, appName(task))
 		task.Reschedule(e.revive)
 	case mesos_v1.TaskState_TASK_STAGING:
 		// NOP, keep task set to "launched".
@@ -149,8 +167,40 @@ func (e *Handler) Reschedule(task *manager.Task) {
 	if err != nil || task.IsKill {
 		// Task was killed in-between rescheduling.
 		e.taskManager.Delete(task)
+		e.pools.Release(e.ctx, task.Info.GetTaskId().GetValue())
+		e.queue.Remove(task.Info.GetName())
 	} else {
 		e.taskManager.Update(task)
 		e.scheduler.Revive()
 	}
 }
+
+// Determines if the update is about the launch of the task we're tracking rather than an earlier one.
+// Updates that don't say where they came from are assumed to be current.
+func current(task *manager.Task, status *mesos_v1.TaskStatus) bool {
+	if status.GetAgentId() != nil && status.GetAgentId().GetValue() != task.Info.GetAgentId().GetValue() {
+		return false
+	}
+	if status.GetExecutorId() != nil && task.Info.GetExecutor() != nil &&
+		status.GetExecutorId().GetValue() != task.Info.GetExecutor().GetExecutorId().GetValue() {
+		return false
+	}
+
+	return true
+}
+
+// Determines if the task has stopped running for good.
+func terminal(state mesos_v1.TaskState) bool {
+	switch state {
+	case mesos_v1.TaskState_TASK_FINISHED,
+		mesos_v1.TaskState_TASK_FAILED,
+		mesos_v1.TaskState_TASK_KILLED,
+		mesos_v1.TaskState_TASK_ERROR,
+		mesos_v1.TaskState_TASK_LOST,
+		mesos_v1.TaskState_TASK_DROPPED,
+		mesos_v1.TaskState_TASK_GONE:
+		return true
+	}
+
+	return false
+}
@@ -15,6 +15,7 @@
 package events
 
 import (
+	"context"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
@@ -23,8 +24,14 @@ import (
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
 	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/task/ipam"
+	taskManager "github.com/verizonlabs/hydrogen/task/manager"
 	mockTaskManager "github.com/verizonlabs/hydrogen/task/manager/test"
+	"github.com/verizonlabs/hydrogen/task/persistence"
 	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"testing"
 )
 
@@ -48,12 +55,18 @@ var states []*mesos_v1.TaskState = []*mesos_v1.TaskState{
 
 func TestHandler_Update(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 
@@ -68,12 +81,18 @@ func TestHandler_Update(t *testing.T) {
 
 func TestHandler_UpdateWithNilTaskId(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 
@@ -96,12 +115,18 @@ func TestHandler_UpdateWithNilTaskId(t *testing.T) {
 
 func TestHandler_UpdateWithInvalidState(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 
@@ -120,12 +145,18 @@ func TestHandler_UpdateWithInvalidState(t *testing.T) {
 
 func TestHandler_UpdateWith(t *testing.T) {
 	e := NewHandler(
+		context.Background(),
 		mockTaskManager.MockTaskManager{},
 		mockResourceManager.MockResourceManager{},
 		new(scheduler.Configuration),
 		sched.MockScheduler{},
 		&mockStorage.MockStorage{},
 		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{}),
+		consistency.NewChecker(mockTaskManager.MockTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
 		&mockLogger.MockLogger{},
 	)
 
@@ -137,3 +168,117 @@ func TestHandler_UpdateWith(t *testing.T) {
 			}})
 	}
 }
+
+// Ensures running tasks we don't know about are tracked until Mesos says they've stopped,
+// and that whatever addresses they held are given back.
+func TestHandler_UpdateUnknownTask(t *testing.T) {
+	orphans := consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{})
+	pools := ipam.NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{})
+	if err := pools.Create(context.Background(), ipam.Pool{Name: "test", Network: "cni", CIDR: "10.0.0.0/30"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	info := &mesos_v1.TaskInfo{TaskId: &mesos_v1.TaskID{Value: utils.ProtoString("unknown")}}
+	if err := pools.Attach(info, []string{"test"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := pools.Allocate(context.Background(), info); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	e := NewHandler(
+		context.Background(),
+		mockTaskManager.MockBrokenTaskManager{},
+		mockResourceManager.MockResourceManager{},
+		new(scheduler.Configuration),
+		sched.MockScheduler{},
+		&mockStorage.MockStorage{},
+		make(chan *manager.Task),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(&mockStorage.MockStorage{}, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		pools,
+		consistency.NewChecker(mockTaskManager.MockBrokenTaskManager{}, &mockStorage.MockStorage{}, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		orphans,
+		&mockLogger.MockLogger{},
+	)
+
+	update := func(state mesos_v1.TaskState) {
+		e.Update(&mesos_v1_scheduler.Event_Update{
+			Status: &mesos_v1.TaskStatus{
+				TaskId:  &mesos_v1.TaskID{Value: utils.ProtoString("unknown")},
+				AgentId: &mesos_v1.AgentID{Value: utils.ProtoString("agent")},
+				State:   state.Enum(),
+			}})
+	}
+
+	update(mesos_v1.TaskState_TASK_RUNNING)
+	if all := orphans.All(); len(all) != 1 || all[0].TaskID != "unknown" || all[0].AgentID != "agent" {
+		t.Fatalf("Expected the unknown task to be tracked, got %v", all)
+	}
+
+	update(mesos_v1.TaskState_TASK_KILLED)
+	if all := orphans.All(); len(all) != 0 {
+		t.Fatalf("Expected the task to be forgotten once it stopped, got %v", all)
+	}
+	if err := pools.Delete(context.Background(), "test"); err != nil {
+		t.Fatalf("Expected the address to be released once the task stopped: %s", err.Error())
+	}
+}
+
+// Late updates from an earlier launch of a task don't free the addresses of the current launch.
+func TestHandler_UpdateEarlierLaunch(t *testing.T) {
+	storage := persistence.NewPersistence(&mockStorage.MockFailingKVStore{Data: map[string]string{}}, "", persistence.RetryPolicy{})
+	tasks := taskManager.NewTaskManager(make(map[string]*manager.Task), storage, &mockLogger.MockLogger{})
+	pools := ipam.NewPools(storage, &mockLogger.MockLogger{})
+	if err := pools.Create(context.Background(), ipam.Pool{Name: "test", Network: "cni", CIDR: "10.0.0.0/30"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	info := &mesos_v1.TaskInfo{
+		Name:    utils.ProtoString("task"),
+		TaskId:  &mesos_v1.TaskID{Value: utils.ProtoString("task")},
+		AgentId: &mesos_v1.AgentID{Value: utils.ProtoString("current")},
+	}
+	if err := pools.Attach(info, []string{"test"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := pools.Allocate(context.Background(), info); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := tasks.Add(manager.NewTask(info, manager.RUNNING, nil, nil, 1, manager.GroupInfo{})); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	e := NewHandler(
+		context.Background(),
+		tasks,
+		mockResourceManager.MockResourceManager{},
+		new(scheduler.Configuration),
+		sched.MockScheduler{},
+		storage,
+		make(chan *manager.Task, 1),
+		queue.NewLaunchQueue(1),
+		agent.NewInventory(storage, 0, agent.QuarantinePolicy{}, &mockLogger.MockLogger{}),
+		pools,
+		consistency.NewChecker(tasks, storage, sched.MockScheduler{}, 0, &mockLogger.MockLogger{}),
+		consistency.NewOrphans(sched.MockScheduler{}, consistency.OrphanPolicy{}, &mockLogger.MockLogger{}),
+		&mockLogger.MockLogger{},
+	)
+
+	update := func(agent string) {
+		e.Update(&mesos_v1_scheduler.Event_Update{
+			Status: &mesos_v1.TaskStatus{
+				TaskId:  &mesos_v1.TaskID{Value: utils.ProtoString("task")},
+				AgentId: &mesos_v1.AgentID{Value: utils.ProtoString(agent)},
+				State:   mesos_v1.TaskState_TASK_LOST.Enum(),
+			}})
+	}
+
+	update("earlier")
+	if err := pools.Delete(context.Background(), "test"); err == nil {
+		t.Fatal("An update from an earlier launch shouldn't release the current address")
+	}
+	update("current")
+	if err := pools.Delete(context.Background(), "test"); err != nil {
+		t.Fatalf("Expected the address to be released once the current launch stopped: %s", err.Error())
+	}
+}
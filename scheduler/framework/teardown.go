@@ -0,0 +1,212 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"context"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/ipam"
+	"github.com/verizonlabs/hydrogen/task/manager"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/queue"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"github.com/verizonlabs/mesos-framework-sdk/scheduler"
+	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	frameworkIDKey = "/frameworkId"
+
+	// How often we check whether every task we killed has stopped.
+	pollInterval = time.Second
+)
+
+var ErrTearingDown = errors.New("The framework is being torn down")
+
+//
+// Teardown retires our framework for good.
+// Every task is killed and we wait for Mesos to report that they've all stopped before telling it we're done,
+// after which everything we kept about the framework is removed from storage.
+// We carry on as a brand new framework with no tasks afterwards.
+//
+type Teardown struct {
+	ctx         context.Context
+	taskManager sdkTaskManager.TaskManager
+	scheduler   scheduler.Scheduler
+	queue       *queue.LaunchQueue
+	pools       *ipam.Pools
+	storage     persistence.Storage
+	timeout     time.Duration
+	interval    time.Duration
+	logger      logging.Logger
+	mutex       sync.Mutex
+	running     bool
+}
+
+// Returns a teardown that waits up to the given timeout for tasks to stop, or doesn't wait at all if it's zero.
+// The context should live as long as we do, a teardown carries on even if whoever asked for it goes away.
+func NewTeardown(
+	ctx context.Context,
+	t sdkTaskManager.TaskManager,
+	s scheduler.Scheduler,
+	q *queue.LaunchQueue,
+	i *ipam.Pools,
+	storage persistence.Storage,
+	timeout time.Duration,
+	l logging.Logger) *Teardown {
+
+	return &Teardown{
+		ctx:         ctx,
+		taskManager: t,
+		scheduler:   s,
+		queue:       q,
+		pools:       i,
+		storage:     storage,
+		timeout:     timeout,
+		interval:    pollInterval,
+		logger:      l,
+	}
+}
+
+// Reports whether a teardown is in progress, during which no new tasks should be deployed.
+func (t *Teardown) Running() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.running
+}
+
+// Run tears down our framework and returns its ID.
+// Nothing is torn down if our tasks don't all stop in time, so it's safe to run again.
+func (t *Teardown) Run() (string, error) {
+	t.mutex.Lock()
+	if t.running {
+		t.mutex.Unlock()
+		return "", ErrTearingDown
+	}
+	t.running = true
+	t.mutex.Unlock()
+
+	defer func() {
+		t.mutex.Lock()
+		t.running = false
+		t.mutex.Unlock()
+	}()
+
+	id := t.scheduler.FrameworkInfo().GetId().GetValue()
+	t.logger.Emit(logging.INFO, "Tearing down framework %s", id)
+
+	if err := t.kill(); err != nil {
+		return "", err
+	}
+	if err := t.wait(); err != nil {
+		return "", err
+	}
+
+	if _, err := t.scheduler.Teardown(); err != nil {
+		return "", err
+	}
+	if err := t.purge(); err != nil {
+		return "", err
+	}
+
+	// Whatever subscribes next does so as a new framework.
+	t.scheduler.FrameworkInfo().Id = nil
+	t.logger.Emit(logging.INFO, "Tore down framework %s", id)
+
+	return id, nil
+}
+
+// Kills every task we know about.
+// Tasks that were never launched are simply forgotten, the rest are forgotten once Mesos reports they've stopped.
+func (t *Teardown) kill() error {
+	tasks, err := t.taskManager.All()
+	if err != nil {
+		// There's nothing to kill.
+		return nil
+	}
+
+	for _, task := range tasks {
+		t.queue.Remove(task.Info.GetName())
+		if task.State == sdkTaskManager.UNKNOWN {
+			if err := t.taskManager.Delete(task); err != nil {
+				return err
+			}
+			t.pools.Release(t.ctx, task.Info.GetTaskId().GetValue())
+			continue
+		}
+
+		// Tasks that fail instead of being killed are forgotten rather than rescheduled.
+		task.IsKill = true
+		if err := t.taskManager.Update(task); err != nil {
+			return err
+		}
+		if _, err := t.scheduler.Kill(task.Info.GetTaskId(), task.Info.GetAgentId()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Waits for every task we killed to stop.
+func (t *Teardown) wait() error {
+	if t.timeout <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(t.ctx, t.timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		remaining := t.taskManager.TotalTasks()
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// We're shutting down rather than out of time.
+			if err := t.ctx.Err(); err != nil {
+				return err
+			}
+			return errors.New("Timed out waiting for " + strconv.Itoa(remaining) + " tasks to stop")
+		}
+	}
+}
+
+// Removes our framework ID and anything left of our tasks from storage.
+func (t *Teardown) purge() error {
+	return t.storage.Retry(t.ctx, func() error {
+		tasks, err := t.storage.ReadAll(manager.TASK_DIRECTORY)
+		if err != nil {
+			return err
+		}
+
+		ops := []persistence.Operation{{Type: persistence.DELETE, Key: frameworkIDKey}}
+		for key := range tasks {
+			ops = append(ops, persistence.Operation{Type: persistence.DELETE, Key: key})
+		}
+
+		return t.storage.Transaction(ops...)
+	})
+}
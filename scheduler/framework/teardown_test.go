@@ -0,0 +1,187 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package framework
+
+import (
+	"context"
+	"github.com/verizonlabs/hydrogen/task/ipam"
+	"github.com/verizonlabs/hydrogen/task/manager"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	sched "github.com/verizonlabs/mesos-framework-sdk/scheduler/test"
+	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Stands in for Mesos by forgetting tasks once they're killed, the same as we do when they're reported killed.
+type killingScheduler struct {
+	sched.MockScheduler
+	info        *mesos_v1.FrameworkInfo
+	taskManager sdkTaskManager.TaskManager
+	stop        bool
+	mutex       sync.Mutex
+	killed      []string
+	teardown    bool
+}
+
+func (s *killingScheduler) FrameworkInfo() *mesos_v1.FrameworkInfo {
+	return s.info
+}
+
+func (s *killingScheduler) Kill(taskId *mesos_v1.TaskID, agentId *mesos_v1.AgentID) (*http.Response, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.killed = append(s.killed, taskId.GetValue())
+	if s.stop {
+		go func() {
+			task, err := s.taskManager.GetById(taskId)
+			if err == nil {
+				s.taskManager.Delete(task)
+			}
+		}()
+	}
+
+	return nil, nil
+}
+
+func (s *killingScheduler) Teardown() (*http.Response, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.teardown = true
+	return nil, nil
+}
+
+func teardownFixture(stop bool, timeout time.Duration) (*Teardown, *killingScheduler, *mockStorage.MockFailingKVStore) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{frameworkIDKey: "framework"}}
+	storage := persistence.NewPersistence(kv, "", persistence.RetryPolicy{})
+	m := manager.NewTaskManager(make(map[string]*sdkTaskManager.Task), storage, new(mockLogger.MockLogger))
+
+	for _, name := range []string{"launched", "queued"} {
+		task := sdkTaskManager.NewTask(&mesos_v1.TaskInfo{
+			Name:    utils.ProtoString(name),
+			TaskId:  &mesos_v1.TaskID{Value: utils.ProtoString(name + "-id")},
+			AgentId: &mesos_v1.AgentID{Value: utils.ProtoString("agent")},
+		}, sdkTaskManager.UNKNOWN, nil, nil, 1, sdkTaskManager.GroupInfo{})
+		if err := m.Add(task); err != nil {
+			panic(err)
+		}
+	}
+	launched, _ := m.Get(utils.ProtoString("launched"))
+	launched.State = sdkTaskManager.RUNNING
+	m.Update(launched)
+
+	// A task that never launched can still hold an address from an earlier attempt.
+	pools := ipam.NewPools(storage, new(mockLogger.MockLogger))
+	queued, _ := m.Get(utils.ProtoString("queued"))
+	if err := pools.Create(context.Background(), ipam.Pool{Name: "test", Network: "cni", CIDR: "10.0.0.0/30"}); err != nil {
+		panic(err)
+	}
+	if err := pools.Attach(queued.Info, []string{"test"}); err != nil {
+		panic(err)
+	}
+	if err := pools.Allocate(context.Background(), queued.Info); err != nil {
+		panic(err)
+	}
+
+	s := &killingScheduler{
+		info:        &mesos_v1.FrameworkInfo{Id: &mesos_v1.FrameworkID{Value: utils.ProtoString("framework")}},
+		taskManager: m,
+		stop:        stop,
+	}
+	t := NewTeardown(context.Background(), m, s, queue.NewLaunchQueue(1), pools, storage, timeout, new(mockLogger.MockLogger))
+	t.interval = time.Millisecond
+
+	return t, s, kv
+}
+
+// Tasks are killed and stopped before the framework is torn down and forgotten.
+func TestTeardown_Run(t *testing.T) {
+	teardown, s, kv := teardownFixture(true, time.Second)
+
+	id, err := teardown.Run()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if id != "framework" {
+		t.Fatalf("Expected to tear down framework, got %s", id)
+	}
+	if len(s.killed) != 1 || s.killed[0] != "launched-id" {
+		t.Fatalf("Only launched tasks should be killed, got %v", s.killed)
+	}
+	if !s.teardown {
+		t.Fatal("Mesos should be told to tear down the framework")
+	}
+	if s.info.GetId() != nil {
+		t.Fatal("The framework ID should be forgotten")
+	}
+	if err := teardown.pools.Delete(context.Background(), "test"); err != nil {
+		t.Fatalf("Expected addresses to be released: %s", err.Error())
+	}
+	if len(kv.Data) != 0 {
+		t.Fatalf("Expected storage to be purged, got %v", kv.Data)
+	}
+	if teardown.Running() {
+		t.Fatal("The teardown should be over")
+	}
+}
+
+// Tasks that don't stop in time leave the framework as it was.
+func TestTeardown_RunTimeout(t *testing.T) {
+	teardown, s, kv := teardownFixture(false, 10*time.Millisecond)
+
+	if _, err := teardown.Run(); err == nil {
+		t.Fatal("Expected to time out waiting for tasks to stop")
+	}
+	if s.teardown {
+		t.Fatal("Mesos shouldn't be told to tear down the framework while tasks are running")
+	}
+	if s.info.GetId().GetValue() != "framework" || kv.Data[frameworkIDKey] != "framework" {
+		t.Fatal("The framework ID should be kept")
+	}
+}
+
+// Shutting down stops the wait without pretending we ran out of time.
+func TestTeardown_RunCancelled(t *testing.T) {
+	teardown, s, _ := teardownFixture(false, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	teardown.ctx = ctx
+	cancel()
+
+	if _, err := teardown.Run(); err != context.Canceled {
+		t.Fatalf("Expected the teardown to be cancelled, got %v", err)
+	}
+	if s.teardown {
+		t.Fatal("Mesos shouldn't be told to tear down the framework while tasks are running")
+	}
+}
+
+// Only one teardown runs at a time.
+func TestTeardown_Running(t *testing.T) {
+	teardown, _, _ := teardownFixture(false, time.Second)
+	teardown.running = true
+
+	if _, err := teardown.Run(); err != ErrTearingDown {
+		t.Fatalf("Expected a teardown to already be running, got %v", err)
+	}
+}
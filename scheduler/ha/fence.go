@@ -0,0 +1,81 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ha
+
+import (
+	"github.com/verizonlabs/hydrogen/task/persistence"
+)
+
+//
+// Fenced wraps storage so nothing is written unless we're still the leader.
+// A leader that stalls long enough for its lease to expire will find out here before it overwrites
+// anything the new leader has written. Reads and lease renewals are always let through.
+// The check isn't atomic with the write since not every store can compare and write in one transaction,
+// so a write that's already past the check can still land if our lease runs out while it's in flight.
+// That window is bounded by how long a single write takes, and renewing a few times per TTL
+// keeps our lease from running out that close to a check in the first place.
+//
+type Fenced struct {
+	persistence.Storage
+	ha *HA
+}
+
+// Returns storage that's only writable while we're leading.
+func NewFenced(s persistence.Storage, h *HA) *Fenced {
+	return &Fenced{
+		Storage: s,
+		ha:      h,
+	}
+}
+
+func (f *Fenced) Create(key, value string) error {
+	if err := f.ha.Fence(); err != nil {
+		return err
+	}
+
+	return f.Storage.Create(key, value)
+}
+
+func (f *Fenced) CreateWithLease(key, value string, ttl int64) (int64, error) {
+	if err := f.ha.Fence(); err != nil {
+		return 0, err
+	}
+
+	return f.Storage.CreateWithLease(key, value, ttl)
+}
+
+func (f *Fenced) Update(key, value string) error {
+	if err := f.ha.Fence(); err != nil {
+		return err
+	}
+
+	return f.Storage.Update(key, value)
+}
+
+func (f *Fenced) Delete(key string) error {
+	if err := f.ha.Fence(); err != nil {
+		return err
+	}
+
+	return f.Storage.Delete(key)
+}
+
+func (f *Fenced) Transaction(ops ...persistence.Operation) error {
+	if err := f.ha.Fence(); err != nil {
+		return err
+	}
+
+	return f.Storage.Transaction(ops...)
+}
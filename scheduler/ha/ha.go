@@ -15,212 +15,601 @@
 package ha
 
 import (
-	"github.com/verizonlabs/mesos-framework-sdk/logging"
-	"net"
-	"os"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/verizonlabs/hydrogen/scheduler"
 	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	leaderKey = "/leader"
+	leaderKey      = "/leader"
+	leaseDirectory = "/leader/leases/"
+	termDirectory  = "/leader/terms/"
+	handoffKey     = "/leader/handoff"
 )
 
+var (
+	ErrNotLeader = errors.New("We are not the leader")
+	ErrStepping  = errors.New("We are already stepping down")
+)
+
+// Describes who's leading and for which term.
+// The token is the term number and only ever increases, so writes from an old leader can be told apart.
+// Standbys send API requests that change anything to the leader's API address.
+type Leader struct {
+	ID    string `json:"id"`
+	IP    string `json:"ip"`
+	API   string `json:"api"`
+	Token uint64 `json:"token"`
+}
+
 type HA struct {
-	logger  logging.Logger
-	config  *scheduler.LeaderConfiguration
-	storage persistence.Storage
+	logger   logging.Logger
+	config   *scheduler.LeaderConfiguration
+	storage  persistence.Storage
+	id       string
+	ttl      time.Duration
+	interval time.Duration
+	mutex    sync.Mutex
+	lease    int64
+	renewed  time.Time
+	token    uint64
+	verified time.Time
+	lost     chan struct{}
+	stepping bool
+	stepdown chan string
+	released time.Time
+	start    sync.Once
 }
 
 func NewHA(s persistence.Storage, l logging.Logger, c *scheduler.LeaderConfiguration) *HA {
+	ttl := c.LeaseTTL / time.Second * time.Second
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	interval := c.WatchInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
 	return &HA{
-		logger:  l,
-		config:  c,
-		storage: s,
+		logger:   l,
+		config:   c,
+		storage:  s,
+		id:       candidate(c.IP),
+		ttl:      ttl,
+		interval: interval,
+		lost:     make(chan struct{}),
+		stepdown: make(chan string, 1),
 	}
 }
 
-// Communicate is a public method that defines how we communicate in our HA implementation.
-// Here we set up a listening mechanism on a TCP channel for other nodes to talk to us.
-func (h *HA) Communicate() {
-	// NOTE (tim): Using a generic Listener might be better so we don't have to assume TCP
-	// for all implementations in future.
-	addr, err := net.ResolveTCPAddr(h.config.AddressFamily, "["+h.config.IP+"]:"+
-		strconv.Itoa(h.config.ServerPort))
-	if err != nil {
-		h.logger.Emit(logging.ERROR, "Leader server exiting: %s", err.Error())
+// Every candidacy gets its own ID so a leader that steps down is never mistaken for a live leader afterwards.
+func candidate(ip string) string {
+	return ip + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+//
+// Election defines how we elect our leader in our HA mode.
+// Every instance holds a lease on a key of its own that it keeps renewing for as long as it's alive.
+// Leadership is claimed one term at a time by atomically creating the key for the term after the highest one.
+// Only one instance can create that key so only one instance wins each term.
+// The leader stays the leader for as long as its lease is alive or until it steps down. Standbys watch the leader's lease
+// and start the next term once it's gone, giving way to the standby the leader handed off to if there is one.
+// Blocks until we're leading or the context is cancelled. Can be called again after stepping down.
+//
+func (h *HA) Election(ctx context.Context) {
+	var err error
+	h.start.Do(func() {
+		err = h.storage.Retry(ctx, h.register)
+		if err == nil {
+			go h.renew(ctx)
+		}
+	})
+	if ctx.Err() != nil {
 		return
 	}
-
-	// We start listening for connections.
-	tcp, err := net.ListenTCP(h.config.AddressFamily, addr)
 	if err != nil {
-		h.logger.Emit(logging.ERROR, "Leader server exiting: %s", err.Error())
-		return
+		h.logger.Emit(logging.ERROR, "Failed to create our leader lease: %s", err.Error())
+		os.Exit(3)
 	}
 
-	for {
-
-		// Block here until we get a new connection.
-		conn, err := tcp.AcceptTCP()
+	var yielding time.Time
+	for ctx.Err() == nil {
+		var term uint64
+		var owner string
+		err := h.storage.Retry(ctx, func() error {
+			var err error
+			term, owner, err = h.term()
+			return err
+		})
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			h.logger.Emit(logging.ERROR, "Failed to accept client: %s", err.Error())
-			time.Sleep(h.config.ServerRetry)
+			h.logger.Emit(logging.ERROR, "Failed to get leader information: %s", err.Error())
+			os.Exit(5)
+		}
+
+		// Follow whoever is leading until their lease runs out.
+		if owner != "" && h.alive(ctx, owner) {
+			h.logger.Emit(logging.INFO, "Following leader %s for term %d", owner, term)
+			h.watch(ctx, owner)
+			yielding = time.Time{}
+			continue
+		}
+
+		// Give whoever the last leader handed off to a chance to take over first.
+		// If we just stepped down ourselves, give any standby that chance.
+		if yielding.IsZero() {
+			yielding = time.Now()
+		}
+		if successor := h.handoff(ctx); successor != "" && time.Since(yielding) < h.ttl {
+			h.logger.Emit(logging.INFO, "Waiting for %s to take over", successor)
+			time.Sleep(h.interval)
+			continue
+		}
+		h.mutex.Lock()
+		released := h.released
+		h.mutex.Unlock()
+		if time.Since(released) < h.ttl {
+			time.Sleep(h.interval)
 			continue
 		}
 
-		remote := conn.RemoteAddr().String()
-		host, _, err := net.SplitHostPort(remote)
+		won, err := h.claim(ctx, term+1)
+		if ctx.Err() != nil {
+			return
+		}
 		if err != nil {
-			h.logger.Emit(logging.ERROR, "Failed to parse IP and port of incoming standby connection")
+			h.logger.Emit(logging.ERROR, "Failed to persist leader information: %s", err.Error())
+			os.Exit(3)
+		}
+		if won {
+			h.logger.Emit(logging.INFO, "We're leading term %d", term+1)
+			go h.monitor(ctx)
+			return
+		}
+
+		time.Sleep(h.config.RetryInterval)
+	}
+}
+
+// Gets who the last leader handed off to, if it's someone other than us.
+func (h *HA) handoff(ctx context.Context) string {
+	var successor string
+	err := h.storage.Retry(ctx, func() error {
+		var err error
+		successor, err = h.storage.Read(handoffKey)
+		return err
+	})
+	if err != nil || successor == h.ID() || successor == h.config.IP {
+		return ""
+	}
+
+	return successor
+}
+
+// Creates the key our lease is bound to, replacing any lease we held before.
+func (h *HA) register() error {
+	renewed := time.Now()
+	lease, err := h.storage.CreateWithLease(leaseDirectory+h.ID(), h.config.IP, int64(h.ttl/time.Second))
+	if err != nil {
+		h.logger.Emit(logging.ERROR, "Failed to create leader lease: %s", err.Error())
+		return err
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lease = lease
+	h.renewed = renewed
+
+	return nil
+}
+
+// Keeps our lease alive by renewing it a few times per TTL.
+// Standbys take out a new lease if theirs ran out. A leader that loses its lease has lost leadership for good.
+func (h *HA) renew(ctx context.Context) {
+	ticker := time.NewTicker(h.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-ticker.C:
 		}
 
-		h.logger.Emit(logging.INFO, "Standby "+host+" connected")
-		go func(conn *net.TCPConn) {
-			buff := make([]byte, 1)
-			_, err := conn.Read(buff)
+		h.mutex.Lock()
+		lease := h.lease
+		h.mutex.Unlock()
 
-			// This cast requires Go 1.6+
-			if err, ok := err.(net.Error); ok && err.Timeout() {
-				h.logger.Emit(logging.ERROR, "Connection from "+host+" timed out")
-			} else {
-				h.logger.Emit(logging.ERROR, "Lost connection to standby "+host)
+		renewed := time.Now()
+		if err := h.storage.RefreshLease(lease); err != nil {
+			h.logger.Emit(logging.ERROR, "Failed to renew leader lease: %s", err.Error())
+			if h.Token() == 0 {
+				h.register()
 			}
-		}(conn)
+			continue
+		}
+
+		h.mutex.Lock()
+		h.renewed = renewed
+		h.mutex.Unlock()
+	}
+}
 
-		// TODO build out some config to use for setting the keep alive period here
-		if err := conn.SetKeepAlive(true); err != nil {
-			h.logger.Emit(logging.ERROR, "Failed to set keep alive: %s", err.Error())
+// Gets the highest term that's been claimed and who claimed it.
+func (h *HA) term() (uint64, string, error) {
+	terms, err := h.storage.ReadAll(termDirectory)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var highest uint64
+	var owner string
+	for key, id := range terms {
+		term, err := strconv.ParseUint(strings.TrimPrefix(key, termDirectory), 10, 64)
+		if err != nil {
+			continue
+		}
+		if term > highest {
+			highest = term
+			owner = id
 		}
 	}
 
+	return highest, owner, nil
 }
 
-//
-// Election defines how we elect our leader in our HA mode.
-// All running schedulers attempt to write themselves as the leader to etcd.
-// If there is another existing leader we connect to the leader as a client using TCP keepalive.
-// Should the leader ever go down, we start the election again and wait for an instance to win the election.
-// This is a simple approach in which who ever gets to write to etcd first becomes the leader.
-// Note that reads and writes are atomic operations.
-//
-func (h *HA) Election() {
+// Checks if an instance's lease is still alive.
+func (h *HA) alive(ctx context.Context, id string) bool {
+	var value string
+	err := h.storage.Retry(ctx, func() error {
+		var err error
+		value, err = h.storage.Read(leaseDirectory + id)
+		return err
+	})
+	if err != nil {
+		h.logger.Emit(logging.ERROR, "Failed to read the lease of %s: %s", id, err.Error())
+
+		// We can't tell so assume they're alive rather than risk two leaders.
+		return true
+	}
+
+	return value != ""
+}
+
+// Blocks until an instance's lease expires or the context is cancelled.
+func (h *HA) watch(ctx context.Context, id string) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
 	for {
-		// This will only set us as the leader if there isn't an already existing leader.
-		// If there's an already existing leader then this call is effectively a no-op.
-		err := h.CreateLeader()
-		if err != nil {
-			h.logger.Emit(logging.ERROR, "Failed to persist leader information: %s", err.Error())
-			os.Exit(3)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !h.alive(ctx, id) {
+			h.logger.Emit(logging.INFO, "Lease of leader %s has expired", id)
+			return
+		}
+	}
+}
+
+// Tries to claim a term. The term key is only created if nobody else has created it,
+// so whoever reads their own ID back from it has won the term as long as no later term exists.
+func (h *HA) claim(ctx context.Context, term uint64) (bool, error) {
+	key := termDirectory + fmt.Sprintf("%020d", term)
+	id := h.ID()
+
+	var owner string
+	err := h.storage.Retry(ctx, func() error {
+		if err := h.storage.Create(key, id); err != nil {
+			return err
 		}
 
-		leader, err := h.GetLeader()
+		var err error
+		owner, err = h.storage.Read(key)
+		return err
+	})
+	if err != nil || owner != id {
+		return false, err
+	}
+
+	var highest uint64
+	err = h.storage.Retry(ctx, func() error {
+		var err error
+		highest, _, err = h.term()
+		return err
+	})
+	if err != nil || highest != term {
+		return false, err
+	}
+
+	h.mutex.Lock()
+	h.token = term
+	h.verified = time.Time{}
+	h.lost = make(chan struct{})
+	h.mutex.Unlock()
+
+	leader, err := json.Marshal(Leader{
+		ID:    id,
+		IP:    h.config.IP,
+		API:   h.config.API,
+		Token: term,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	err = h.storage.Retry(ctx, func() error {
+		// Never overwrite a newer term if we stalled long enough for someone to win it.
+		current, err := h.leader()
+		if err == nil && current != nil && current.Token > term {
+			return nil
+		}
+
+		return h.storage.Update(leaderKey, string(leader))
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Older terms and handoffs are only kept around until someone wins a newer term.
+	h.storage.Delete(handoffKey)
+	h.storage.Retry(ctx, func() error {
+		terms, err := h.storage.ReadAll(termDirectory)
 		if err != nil {
-			h.logger.Emit(logging.ERROR, "Failed to get leader information: %s", err.Error())
-			os.Exit(5)
+			return err
+		}
+		for k := range terms {
+			if k != key {
+				h.storage.Delete(k)
+			}
 		}
 
-		// If the leader fetched from persistent storage does not match the IP provided to this instance
-		// then we should connect to the already existing leader.
-		if leader != h.config.IP {
-			h.logger.Emit(logging.INFO, "Connecting to existing leader")
-
-			// Block here until we lose connection to the leader.
-			// Once the connection has been lost, elect a new leader.
-			err := h.leaderClient(leader)
-
-			// Only delete the key if we've lost the connection, not timed out.
-			// This cast requires Go 1.6+
-			// NOTE (tim): Casting here is dangerous and could lead to a panic
-			if err, ok := err.(net.Error); ok && err.Timeout() {
-				h.logger.Emit(logging.ERROR, "Timed out connecting to leader")
-			} else {
-				h.logger.Emit(logging.ERROR, "Lost connection to leader")
-				err := h.deleteLeader()
-				if err != nil {
-					h.logger.Emit(logging.ERROR, "Failed to delete leader information: %s", err.Error())
-					os.Exit(4)
-				}
+		return nil
+	})
+
+	return true, nil
+}
+
+// Keeps checking that we're still the leader once we've won.
+// Gives up leadership for good as soon as our lease runs out or someone else wins a newer term.
+// Stops quietly once we've stepped down.
+func (h *HA) monitor(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	h.mutex.Lock()
+	token := h.token
+	lost := h.lost
+	h.mutex.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := h.check()
+		if err == ErrNotLeader || h.Token() != token {
+			return
+		}
+		if err != nil {
+			h.logger.Emit(logging.ERROR, "Lost leadership: %s", err.Error())
+
+			h.mutex.Lock()
+			if h.token == token {
+				h.token = 0
 			}
-		} else {
-			h.logger.Emit(logging.INFO, "We're leading")
-			break // We are the leader, exit the loop and start the scheduler/API.
+			h.mutex.Unlock()
+
+			close(lost)
+			return
 		}
 	}
 }
 
 //
-// Connects to the leader and determines if and when we should start the leader election process.
+// Fence checks that we're still the leader before anything is written.
+// We stop trusting our own lease as soon as it could have expired, even if we couldn't reach storage to find out.
+// Nobody can win a newer term while our lease is alive, so once the leader record shows our token
+// it's trusted for a lease period instead of being read again for every write.
+// The monitor keeps reading it every watch interval regardless and fences us off as soon as it changes.
 //
-func (h *HA) leaderClient(leader string) error {
-	conn, err := net.DialTimeout(h.config.AddressFamily, "["+leader+"]:"+
-		strconv.Itoa(h.config.ServerPort), 2*time.Second)
-	if err != nil {
-		return err
+func (h *HA) Fence() error {
+	h.mutex.Lock()
+	trusted := h.token != 0 && time.Since(h.renewed) <= h.ttl && time.Since(h.verified) < h.ttl
+	h.mutex.Unlock()
+
+	if trusted {
+		return nil
 	}
 
-	h.logger.Emit(logging.INFO, "Successfully connected to leader %s", leader)
+	return h.check()
+}
+
+// Checks our lease and that the token in the leader record is still ours, otherwise someone has won a newer term.
+func (h *HA) check() error {
+	h.mutex.Lock()
+	token := h.token
+	renewed := h.renewed
+	h.mutex.Unlock()
+
+	if token == 0 {
+		return ErrNotLeader
+	}
+	if time.Since(renewed) > h.ttl {
+		return errors.New("Our leader lease has expired")
+	}
 
-	// NOTE (tim): This cast has the potential to panic.
-	tcp := conn.(*net.TCPConn)
-	if err := tcp.SetKeepAlive(true); err != nil {
+	checked := time.Now()
+	leader, err := h.leader()
+	if err != nil {
 		return err
 	}
+	if leader == nil {
+		return errors.New("There's no leader record")
+	}
+	if leader.Token != token {
+		return fmt.Errorf("Term %d has been taken over by %s in term %d", token, leader.ID, leader.Token)
+	}
 
-	// Currently the leader server does not send us anything as we rely on TCP keepalive.
-	// Allocate the smallest buffer we can and block on reading data.
-	buffer := make([]byte, 1)
-	_, err = tcp.Read(buffer)
+	h.mutex.Lock()
+	if h.token == token {
+		h.verified = checked
+	}
+	h.mutex.Unlock()
 
-	return err
+	return nil
 }
 
-// Deletes the current leader information.
-func (h *HA) deleteLeader() error {
-	policy := h.storage.CheckPolicy(nil)
-	return h.storage.RunPolicy(policy, func() error {
-		err := h.storage.Delete(leaderKey)
-		if err != nil {
-			h.logger.Emit(logging.ERROR, "Failed to delete leader: %s", err.Error())
-		}
+// Gets the fencing token of our term, or zero if we're not leading.
+func (h *HA) Token() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 
-		return err
-	})
+	return h.token
+}
+
+// Closed once we've lost leadership of the current term.
+// Stepping down doesn't count as losing it.
+func (h *HA) Lost() <-chan struct{} {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.lost
 }
 
-// Atomically create leader information.
-func (h *HA) CreateLeader() error {
-	policy := h.storage.CheckPolicy(nil)
-	return h.storage.RunPolicy(policy, func() error {
-		err := h.storage.Create(leaderKey, h.config.IP)
+// Gets the ID we're currently running for leadership under.
+func (h *HA) ID() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.id
+}
+
+// Checks if we're leading and not on our way out.
+// Nothing should be changed once we've started stepping down.
+func (h *HA) Leading() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.token != 0 && !h.stepping
+}
+
+//
+// StepDown asks the leader to give up leadership.
+// The successor is the ID or IP of the standby that should take over, or empty to let any standby take over.
+// Whoever runs the leader is told through SteppingDown and should call Release once it's done with what it's doing.
+//
+func (h *HA) StepDown(successor string) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.token == 0 {
+		return ErrNotLeader
+	}
+	if h.stepping {
+		return ErrStepping
+	}
+	h.stepping = true
+	h.stepdown <- successor
+
+	return nil
+}
+
+// Receives the successor whenever we've been asked to step down.
+func (h *HA) SteppingDown() <-chan string {
+	return h.stepdown
+}
+
+//
+// Release gives up leadership after stepping down.
+// We drop our lease so standbys see we're gone right away and run again under a new ID as a standby.
+// We hold off running for the next term for a while so a standby takes over rather than us.
+//
+func (h *HA) Release(ctx context.Context, successor string) error {
+	h.mutex.Lock()
+	old := h.id
+	h.token = 0
+	h.stepping = false
+
+	// A request to step down that nobody got to, say because we lost leadership first, mustn't end our next term.
+	select {
+	case <-h.stepdown:
+	default:
+	}
+	h.released = time.Now()
+	h.id = candidate(h.config.IP)
+	h.mutex.Unlock()
+
+	if successor != "" {
+		err := h.storage.Retry(ctx, func() error {
+			return h.storage.Update(handoffKey, successor)
+		})
 		if err != nil {
-			h.logger.Emit(logging.ERROR, "Failed to set leader: %s", err.Error())
+			return err
 		}
+	}
 
-		return err
+	err := h.storage.Retry(ctx, func() error {
+		return h.storage.Delete(leaseDirectory + old)
 	})
+	if err != nil {
+		return err
+	}
+
+	h.logger.Emit(logging.INFO, "Stepped down as leader")
+
+	return h.storage.Retry(ctx, h.register)
 }
 
-// Atomically get leader information.
-func (h *HA) GetLeader() (string, error) {
-	var leader string
-	policy := h.storage.CheckPolicy(nil)
-	err := h.storage.RunPolicy(policy, func() error {
-		l, err := h.storage.Read(leaderKey)
+// Gets the current leader information.
+// Returns nil if nobody has led yet.
+func (h *HA) GetLeader(ctx context.Context) (*Leader, error) {
+	var leader *Leader
+	err := h.storage.Retry(ctx, func() error {
+		var err error
+		leader, err = h.leader()
 		if err != nil {
 			h.logger.Emit(logging.ERROR, "Failed to get the leader: %s", err.Error())
-			return err
 		}
 
-		leader = l
-		return nil
+		return err
 	})
 
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return leader, nil
+}
+
+// Reads the leader record once.
+func (h *HA) leader() (*Leader, error) {
+	value, err := h.storage.Read(leaderKey)
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	leader := new(Leader)
+	if err := json.Unmarshal([]byte(value), leader); err != nil {
+		return nil, errors.New("Leader record is invalid: " + err.Error())
 	}
 
 	return leader, nil
@@ -15,54 +15,308 @@
 package ha
 
 import (
-	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"context"
 	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/task/persistence"
 	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
 	"testing"
+	"time"
 )
 
-// Possible to test communication with a variadic input for a channel.
-// If a channel is passed in, it communicates on that channel.
-// Can block on the "read" of that channel.
-func TestHA_Communicate(t *testing.T) {
-	ha := NewHA(new(mockStorage.MockStorage), new(mockLogger.MockLogger), &scheduler.LeaderConfiguration{
-		IP: "1", // Make sure we break out of our HA loop by matching on what mock storage gives us.
+func haFixture(kv *mockStorage.MockFailingKVStore, ip string) *HA {
+	return NewHA(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), new(mockLogger.MockLogger), &scheduler.LeaderConfiguration{
+		IP:            ip,
+		LeaseTTL:      time.Minute,
+		WatchInterval: time.Millisecond,
 	})
-	go ha.Communicate()
-	ha.Election()
+}
+
+// Elects an instance in the background, reporting when it's leading.
+func elect(ctx context.Context, h *HA) chan struct{} {
+	elected := make(chan struct{})
+	go func() {
+		h.Election(ctx)
+		if ctx.Err() == nil {
+			close(elected)
+		}
+	}()
+
+	return elected
 }
 
 func TestHA_Election(t *testing.T) {
-	ha := NewHA(new(mockStorage.MockStorage), new(mockLogger.MockLogger), &scheduler.LeaderConfiguration{
-		IP: "1", // Make sure we break out of our HA loop by matching on what mock storage gives us.
-	})
-	ha.Election()
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	ha := haFixture(kv, "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ha.Election(ctx)
+	if ha.Token() != 1 {
+		t.Fatalf("Expected to lead the first term, got token %d", ha.Token())
+	}
+	if err := ha.Fence(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	leader, err := ha.GetLeader(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if leader == nil || leader.IP != "1" || leader.Token != 1 || leader.ID != ha.id {
+		t.Fatalf("Unexpected leader %+v", leader)
+	}
 }
 
-// Can we create a leader?
-func TestHA_CreateLeader(t *testing.T) {
-	ha := NewHA(new(mockStorage.MockStorage), new(mockLogger.MockLogger), &scheduler.LeaderConfiguration{
-		IP: "1", // Make sure we break out of our HA loop by matching on what mock storage gives us.
-	})
-	if err := ha.CreateLeader(); err != nil {
-		t.Logf("Failed to create a leader %v", err.Error())
-		t.Fail()
+// A standby has to wait for the leader's lease to expire before taking over with a newer token.
+func TestHA_Takeover(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	leader := haFixture(kv, "1")
+	standby := haFixture(kv, "2")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader.Election(ctx)
+	elected := elect(ctx, standby)
+
+	select {
+	case <-elected:
+		t.Fatal("Standby took over while the leader's lease was alive")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := standby.Fence(); err != ErrNotLeader {
+		t.Fatalf("Standby shouldn't pass the fence, got %v", err)
+	}
+
+	kv.Expire(kv.Lease(leaseDirectory + leader.id))
+	select {
+	case <-elected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Standby never took over")
+	}
+	if standby.Token() != 2 {
+		t.Fatalf("Expected the standby to lead the second term, got token %d", standby.Token())
+	}
+
+	// The old leader has to notice that it's been replaced.
+	select {
+	case <-leader.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Old leader never noticed it lost leadership")
+	}
+	if err := leader.Fence(); err == nil {
+		t.Fatal("Old leader shouldn't pass the fence")
+	}
+	if err := standby.Fence(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if value, _ := kv.Read(termDirectory + "00000000000000000001"); value != "" {
+		t.Fatal("Older terms should be cleaned up")
+	}
+}
+
+// Writes from a leader that's been replaced must never reach storage.
+func TestFenced(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	ha := haFixture(kv, "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fenced := NewFenced(ha.storage, ha)
+	if err := fenced.Update("/tasks/a", "a"); err != ErrNotLeader {
+		t.Fatalf("Writes before we're leading should be refused, got %v", err)
+	}
+
+	ha.Election(ctx)
+	if err := fenced.Update("/tasks/a", "a"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Another instance winning a newer term fences us off as soon as we notice, before our lease runs out.
+	kv.Update(leaderKey, `{"id": "other", "ip": "2", "token": 2}`)
+	select {
+	case <-ha.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Never noticed another instance took over")
+	}
+	if err := fenced.Update("/tasks/a", "b"); err == nil {
+		t.Fatal("Writes after another instance took over should be refused")
+	}
+	if err := fenced.Delete("/tasks/a"); err == nil {
+		t.Fatal("Deletes after another instance took over should be refused")
+	}
+	if err := fenced.Transaction(persistence.Operation{Type: persistence.PUT, Key: "/tasks/b", Value: "b"}); err == nil {
+		t.Fatal("Transactions after another instance took over should be refused")
+	}
+	a, _ := kv.Read("/tasks/a")
+	b, _ := kv.Read("/tasks/b")
+	if a != "a" || b != "" {
+		t.Fatalf("Fenced writes reached storage: %s, %s", a, b)
+	}
+	if value, err := fenced.Read("/tasks/a"); err != nil || value != "a" {
+		t.Fatal("Reads should always be let through")
+	}
+}
+
+// The leader record is only read again once what we last saw of it is a lease period old.
+func TestHA_FenceVerified(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	ha := haFixture(kv, "1")
+	ha.token = 1
+	ha.renewed = time.Now()
+	kv.Update(leaderKey, `{"id": "other", "ip": "2", "token": 2}`)
+
+	if err := ha.Fence(); err == nil {
+		t.Fatal("A term we haven't verified shouldn't pass the fence")
+	}
+	ha.verified = time.Now()
+	if err := ha.Fence(); err != nil {
+		t.Fatalf("A term we've just verified should pass without reading storage, got %v", err)
+	}
+	ha.verified = time.Now().Add(-ha.ttl)
+	if err := ha.Fence(); err == nil {
+		t.Fatal("The leader record should be read again once our verification is a lease period old")
 	}
 }
 
-// Can we create and get the leader?
-func TestHA_GetLeader(t *testing.T) {
-	ha := NewHA(new(mockStorage.MockStorage), new(mockLogger.MockLogger), &scheduler.LeaderConfiguration{
-		IP: "1", // Make sure we break out of our HA loop by matching on what mock storage gives us.
+// Elections stop as soon as we're shutting down.
+func TestHA_ElectionCancelled(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	leader := haFixture(kv, "1")
+	standby := haFixture(kv, "2")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	leader.Election(ctx)
+	done := make(chan struct{})
+	go func() {
+		standby.Election(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Election didn't stop after being cancelled")
+	}
+	if standby.Token() != 0 {
+		t.Fatal("Standby shouldn't lead after being cancelled")
+	}
+}
+
+// A leader that steps down hands over to the standby it chose, even with other standbys around.
+func TestHA_StepDown(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	leader := haFixture(kv, "1")
+	other := haFixture(kv, "2")
+	successor := haFixture(kv, "3")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader.Election(ctx)
+	if err := leader.StepDown("3"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := leader.StepDown("3"); err != ErrStepping {
+		t.Fatalf("Stepping down twice should fail, got %v", err)
+	}
+	if leader.Leading() {
+		t.Fatal("A leader that's stepping down shouldn't take changes")
+	}
+	if <-leader.SteppingDown() != "3" {
+		t.Fatal("Expected to be told who to hand over to")
+	}
+
+	old := leader.ID()
+	otherElected := elect(ctx, other)
+	successorElected := elect(ctx, successor)
+	if err := leader.Release(ctx, "3"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if leader.ID() == old {
+		t.Fatal("Expected to run again under a new ID")
+	}
+
+	select {
+	case <-successorElected:
+	case <-otherElected:
+		t.Fatal("Standby took over instead of the successor")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Successor never took over")
+	}
+	if successor.Token() != 2 {
+		t.Fatalf("Expected the successor to lead the second term, got token %d", successor.Token())
+	}
+	if err := leader.Fence(); err != ErrNotLeader {
+		t.Fatalf("Old leader shouldn't pass the fence, got %v", err)
+	}
+	if handoff, _ := kv.Read(handoffKey); handoff != "" {
+		t.Fatal("The handoff should be cleaned up once the successor has taken over")
+	}
+
+	// Stepping down isn't losing leadership, and we're still around as a standby.
+	select {
+	case <-leader.Lost():
+		t.Fatal("Stepping down shouldn't count as losing leadership")
+	default:
+	}
+	if lease, _ := kv.Read(leaseDirectory + leader.ID()); lease == "" {
+		t.Fatal("Expected to stay registered as a standby")
+	}
+}
+
+// A leader that stepped down without any standby around takes over again once nobody else has.
+func TestHA_StepDownRejoin(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	ha := NewHA(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), new(mockLogger.MockLogger), &scheduler.LeaderConfiguration{
+		IP:            "1",
+		LeaseTTL:      time.Second,
+		WatchInterval: time.Millisecond,
 	})
-	if err := ha.CreateLeader(); err != nil {
-		t.Logf("Failed to create a leader %v\n", err.Error())
-		t.Fail()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ha.Election(ctx)
+	if err := ha.StepDown(""); err != nil {
+		t.Fatal(err.Error())
 	}
-	// Leader string is simply an empty string during testing...
-	_, err := ha.GetLeader()
-	if err != nil {
-		t.Logf("Failed to grab the leader %v\n", err.Error())
-		t.Fail()
+	if err := ha.Release(ctx, <-ha.SteppingDown()); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	elected := elect(ctx, ha)
+	select {
+	case <-elected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Never took over again")
+	}
+	if !ha.Leading() || ha.Token() != 2 {
+		t.Fatalf("Expected to lead the second term, got token %d", ha.Token())
+	}
+	if err := ha.Fence(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// A request to step down that was never acted on doesn't carry over once we've released leadership.
+func TestHA_ReleaseDrainsStepDown(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{Data: map[string]string{}}
+	ha := haFixture(kv, "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ha.Election(ctx)
+	if err := ha.StepDown("2"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := ha.Release(ctx, ""); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	select {
+	case successor := <-ha.SteppingDown():
+		t.Fatalf("Expected no request to step down left over, got %q", successor)
+	default:
 	}
 }
@@ -0,0 +1,102 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+//
+// Certificates writes a new CA, and a certificate and key signed by it for each IP, into the directory.
+// Returns the path of the CA followed by the certificate and key paths for each IP in the same order.
+//
+func Certificates(dir string, ips ...string) (string, []string, []string, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Hydrogen test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	ca := filepath.Join(dir, "ca.pem")
+	if err := write(ca, "CERTIFICATE", caDER); err != nil {
+		return "", nil, nil, err
+	}
+
+	certs := make([]string, 0, len(ips))
+	keys := make([]string, 0, len(ips))
+	for i, ip := range ips {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: ip},
+			IPAddresses:  []net.IP{net.ParseIP(ip)},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return "", nil, nil, err
+		}
+
+		cert := filepath.Join(dir, ip+".pem")
+		if err := write(cert, "CERTIFICATE", der); err != nil {
+			return "", nil, nil, err
+		}
+		keyPath := filepath.Join(dir, ip+"-key.pem")
+		if err := write(keyPath, "EC PRIVATE KEY", keyDER); err != nil {
+			return "", nil, nil, err
+		}
+
+		certs = append(certs, cert)
+		keys = append(keys, keyPath)
+	}
+
+	return ca, certs, keys, nil
+}
+
+// Writes a single PEM block to a file.
+func write(path, kind string, der []byte) error {
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600)
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ha
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/verizonlabs/hydrogen/scheduler"
+	"io/ioutil"
+)
+
+//
+// LoadTLS loads what instances use to authenticate each other when standbys send requests to the leader.
+// Every instance presents its certificate both when serving and when sending, and only accepts certificates signed by the CA.
+// Certificates have to name the instance's HA IP. Returns nil if TLS isn't configured.
+//
+func LoadTLS(c *scheduler.LeaderConfiguration) (*tls.Config, error) {
+	if c.Cert == "" && c.Key == "" && c.CA == "" {
+		return nil, nil
+	}
+	if c.Cert == "" || c.Key == "" || c.CA == "" {
+		return nil, errors.New("HA TLS needs a certificate, a key and a CA")
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := ioutil.ReadFile(c.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("No certificates found in " + c.CA)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ha
+
+import (
+	"crypto/tls"
+	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/ha/test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hydrogen-tls")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	ca, certs, keys, err := test.Certificates(dir, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	config, err := LoadTLS(&scheduler.LeaderConfiguration{})
+	if err != nil || config != nil {
+		t.Fatalf("TLS should be off if it's not configured, got %v and %v", config, err)
+	}
+	if _, err := LoadTLS(&scheduler.LeaderConfiguration{Cert: certs[0], Key: keys[0]}); err == nil {
+		t.Fatal("TLS without a CA should fail")
+	}
+	if _, err := LoadTLS(&scheduler.LeaderConfiguration{Cert: certs[0], Key: keys[0], CA: keys[0]}); err == nil {
+		t.Fatal("A CA without any certificates should fail")
+	}
+	if _, err := LoadTLS(&scheduler.LeaderConfiguration{Cert: certs[0], Key: filepath.Join(dir, "missing"), CA: ca}); err == nil {
+		t.Fatal("A missing key should fail")
+	}
+
+	config, err = LoadTLS(&scheduler.LeaderConfiguration{Cert: certs[0], Key: keys[0], CA: ca})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(config.Certificates) != 1 || config.ClientAuth != tls.RequireAndVerifyClientCert || config.RootCAs == nil || config.ClientCAs == nil {
+		t.Fatalf("Peers should have to authenticate each other, got %+v", config)
+	}
+}
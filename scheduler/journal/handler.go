@@ -0,0 +1,51 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
+	"github.com/verizonlabs/mesos-framework-sdk/scheduler/events"
+)
+
+// Journals every event before handing it to the scheduler's event handler.
+type Handler struct {
+	events.SchedulerEvent
+	journal *Journal
+}
+
+// Wraps an event handler so that every event it runs is journaled.
+func NewHandler(h events.SchedulerEvent, j *Journal) events.SchedulerEvent {
+	return &Handler{
+		SchedulerEvent: h,
+		journal:        j,
+	}
+}
+
+// Records the event and then runs it.
+func (h *Handler) Run(event *mesos_v1_scheduler.Event) {
+	h.journal.Event(event)
+	h.SchedulerEvent.Run(event)
+}
+
+// Passes on failures from the handler we journal for, if it reports any.
+func (h *Handler) Failed() <-chan error {
+	if f, ok := h.SchedulerEvent.(interface {
+		Failed() <-chan error
+	}); ok {
+		return f.Failed()
+	}
+
+	return nil
+}
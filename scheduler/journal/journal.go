@@ -0,0 +1,249 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// An event from Mesos that was handed to the scheduler.
+	EVENT = "event"
+
+	// A request to the API that could change the scheduler's state.
+	API = "api"
+)
+
+type (
+	// A single journaled event or API request.
+	Entry struct {
+		Time    time.Time                 `json:"time"`
+		Type    string                    `json:"type"`
+		Event   *mesos_v1_scheduler.Event `json:"event,omitempty"`
+		Request *Request                  `json:"request,omitempty"`
+	}
+
+	// An API request as it was received.
+	Request struct {
+		Method string `json:"method"`
+		Path   string `json:"path"`
+		Body   string `json:"body,omitempty"`
+	}
+
+	// Records everything that's handed to the scheduler to a local file, one JSON entry per line.
+	// The file is rotated once it grows past its maximum size and only a fixed number of old files are kept.
+	// Failing to journal never affects the scheduler, it's only logged.
+	Journal struct {
+		mutex    sync.Mutex
+		path     string
+		maxSize  int64
+		maxFiles int
+		file     *os.File
+		size     int64
+		logger   logging.Logger
+	}
+)
+
+// Opens the journal at the given path, appending to it if it already exists.
+func NewJournal(path string, maxSize int64, maxFiles int, l logging.Logger) (*Journal, error) {
+	j := &Journal{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		logger:   l,
+	}
+
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Records an event from Mesos.
+func (j *Journal) Event(event *mesos_v1_scheduler.Event) {
+	j.write(Entry{Time: time.Now(), Type: EVENT, Event: event})
+}
+
+// Records an API request.
+func (j *Journal) Request(method, path string, body []byte) {
+	j.write(Entry{Time: time.Now(), Type: API, Request: &Request{Method: method, Path: path, Body: string(body)}})
+}
+
+// Wraps an API handler so that every request that can change our state is recorded before it's handled.
+func (j *Journal) Record(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = ioutil.ReadAll(r.Body)
+			r.Body.Close()
+			if err != nil {
+				j.logger.Emit(logging.ERROR, "Failed to read request body for the journal: %s", err.Error())
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		j.Request(r.Method, r.URL.Path, body)
+		next(w, r)
+	}
+}
+
+// Closes the current journal file.
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return j.file.Close()
+}
+
+// Appends an entry to the journal, rotating first if it would grow too large.
+func (j *Journal) write(entry Entry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		j.logger.Emit(logging.ERROR, "Failed to encode journal entry: %s", err.Error())
+		return
+	}
+	data = append(data, '\n')
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.maxSize > 0 && j.size > 0 && j.size+int64(len(data)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			j.logger.Emit(logging.ERROR, "Failed to rotate journal: %s", err.Error())
+		}
+	}
+
+	n, err := j.file.Write(data)
+	j.size += int64(n)
+	if err != nil {
+		j.logger.Emit(logging.ERROR, "Failed to write journal entry: %s", err.Error())
+	}
+}
+
+// Shifts every old file up by one, dropping the oldest, and starts a new file.
+// The newest old file is path.1 and the oldest is path.N.
+func (j *Journal) rotate() error {
+	if err := j.file.Close(); err != nil {
+		return err
+	}
+
+	if j.maxFiles > 0 {
+		os.Remove(j.path + "." + strconv.Itoa(j.maxFiles))
+		for i := j.maxFiles - 1; i > 0; i-- {
+			os.Rename(j.path+"."+strconv.Itoa(i), j.path+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(j.path, j.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(j.path); err != nil {
+		return err
+	}
+
+	return j.open()
+}
+
+// Opens the current journal file for appending.
+func (j *Journal) open() error {
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	j.file = f
+	j.size = info.Size()
+
+	return nil
+}
+
+// Gets the journal files that exist for the given path, oldest first, so they can be read back in order.
+func Files(path string) []string {
+	files := []string{}
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path + "." + strconv.Itoa(i)); err != nil {
+			break
+		}
+		files = append([]string{path + "." + strconv.Itoa(i)}, files...)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+
+	return files
+}
+
+// Reads every entry from the given journal files in order.
+func Load(paths ...string) ([]Entry, error) {
+	entries := []Entry{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		read, err := Read(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, read...)
+	}
+
+	return entries, nil
+}
+
+// Reads every entry from a journal.
+func Read(r io.Reader) ([]Entry, error) {
+	entries := []Entry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // Offers can be large.
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
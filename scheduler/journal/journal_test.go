@@ -0,0 +1,186 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"bytes"
+	"github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	sdkEvents "github.com/verizonlabs/mesos-framework-sdk/scheduler/events"
+	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Remembers the events it's given.
+type mockEvents struct {
+	sdkEvents.SchedulerEvent
+	run        []*mesos_v1_scheduler.Event
+	reschedule []*manager.Task
+}
+
+func (e *mockEvents) Run(event *mesos_v1_scheduler.Event) { e.run = append(e.run, event) }
+func (e *mockEvents) Reschedule(task *manager.Task)       { e.reschedule = append(e.reschedule, task) }
+
+func journalFixture(t *testing.T, maxSize int64, maxFiles int) (*Journal, string) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	j, err := NewJournal(filepath.Join(dir, "journal"), maxSize, maxFiles, new(mockLogger.MockLogger))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return j, dir
+}
+
+func heartbeat() *mesos_v1_scheduler.Event {
+	return &mesos_v1_scheduler.Event{Type: mesos_v1_scheduler.Event_HEARTBEAT.Enum()}
+}
+
+// Makes sure events and requests are read back the way they were written.
+func TestJournal_Load(t *testing.T) {
+	j, dir := journalFixture(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	h := &mockEvents{}
+	NewHandler(h, j).Run(heartbeat())
+	j.Request(http.MethodPost, "/v1/api/app", []byte(`[{"name": "test"}]`))
+	j.Close()
+
+	entries, err := Load(Files(j.path)...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(h.run) != 1 {
+		t.Fatal("Journaled events should still be run")
+	}
+	if len(entries) != 2 || entries[0].Type != EVENT || entries[1].Type != API {
+		t.Fatalf("Expected an event and a request, got %v", entries)
+	}
+	if entries[0].Event.GetType() != mesos_v1_scheduler.Event_HEARTBEAT {
+		t.Fatal("Expected the event to be read back")
+	}
+	if entries[1].Request.Path != "/v1/api/app" || entries[1].Request.Body != `[{"name": "test"}]` {
+		t.Fatalf("Expected the request to be read back, got %v", entries[1].Request)
+	}
+}
+
+// Ensures the journal is rotated once it's too large and only the configured number of old files are kept.
+func TestJournal_Rotate(t *testing.T) {
+	j, dir := journalFixture(t, 1, 2)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 5; i++ {
+		j.Event(heartbeat())
+	}
+	j.Close()
+
+	files := Files(j.path)
+	if len(files) != 3 || files[0] != j.path+".2" || files[2] != j.path {
+		t.Fatalf("Expected the current file and two old ones oldest first, got %v", files)
+	}
+
+	entries, err := Load(files...)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected the oldest entries to be dropped, got %d entries", len(entries))
+	}
+}
+
+// Makes sure only requests that can change state are recorded and handlers can still read the body.
+func TestJournal_Record(t *testing.T) {
+	j, dir := journalFixture(t, 0, 0)
+	defer os.RemoveAll(dir)
+
+	var read string
+	handler := j.Record(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		read = string(body)
+	})
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/api/app", nil))
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/v1/api/app", strings.NewReader(`{"name": "test"}`)))
+	j.Close()
+
+	if read != `{"name": "test"}` {
+		t.Fatalf("Expected the handler to read the whole body, got %s", read)
+	}
+
+	entries, err := Load(j.path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(entries) != 1 || entries[0].Request.Method != http.MethodDelete {
+		t.Fatalf("Expected only the DELETE to be recorded, got %v", entries)
+	}
+}
+
+// Ensures entries are fed back in order and the calls they make are printed.
+func TestReplay(t *testing.T) {
+	out := new(bytes.Buffer)
+	s := NewRecorder(&mesos_v1.FrameworkInfo{}, out)
+	store := &test.MockFailingKVStore{Data: map[string]string{}}
+	kv := NewRecordingStore(store, s)
+
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kv.Update("/tasks/test", "task")
+		s.Revive()
+		w.Write([]byte("queued"))
+	})
+
+	revives := make(chan *manager.Task, 1)
+	revives <- &manager.Task{Info: &mesos_v1.TaskInfo{Name: utils.ProtoString("test")}}
+
+	h := &mockEvents{}
+	Replay([]Entry{
+		{Type: EVENT, Event: heartbeat()},
+		{Type: API, Request: &Request{Method: http.MethodPost, Path: "/v1/api/app"}},
+	}, h, api, revives, out)
+
+	if len(h.run) != 1 || len(h.reschedule) != 1 {
+		t.Fatal("Expected the event to be run and the task to be rescheduled")
+	}
+
+	printed := out.String()
+	for _, expected := range []string{
+		"#1",
+		"reschedule test",
+		"#2",
+		"api POST /v1/api/app",
+		`storage.Update ["/tasks/test","task"]`,
+		"scheduler.Revive",
+		"response 200 queued",
+	} {
+		if !strings.Contains(printed, expected) {
+			t.Fatalf("Expected %q to be printed, got:\n%s", expected, printed)
+		}
+	}
+	if store.Data["/tasks/test"] != "task" {
+		t.Fatal("Recorded storage calls should still be made")
+	}
+}
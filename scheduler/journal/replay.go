@@ -0,0 +1,200 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
+	"github.com/verizonlabs/mesos-framework-sdk/persistence"
+	"github.com/verizonlabs/mesos-framework-sdk/scheduler/events"
+	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// A scheduler that prints every call made to it instead of sending it to Mesos.
+	Recorder struct {
+		mutex sync.Mutex
+		out   io.Writer
+		info  *mesos_v1.FrameworkInfo
+	}
+
+	// Key value store that prints every call made to it before passing it on to the store it wraps.
+	RecordingStore struct {
+		persistence.KeyValueStore
+		recorder *Recorder
+	}
+)
+
+// Returns a scheduler that prints its calls to the given writer.
+func NewRecorder(info *mesos_v1.FrameworkInfo, out io.Writer) *Recorder {
+	return &Recorder{out: out, info: info}
+}
+
+// Returns a store that prints its calls through the given recorder so they're interleaved with scheduler calls.
+func NewRecordingStore(kv persistence.KeyValueStore, r *Recorder) *RecordingStore {
+	return &RecordingStore{KeyValueStore: kv, recorder: r}
+}
+
+// Prints a call along with its arguments.
+func (r *Recorder) print(call string, args ...interface{}) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		data = []byte(err.Error())
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	fmt.Fprintf(r.out, "  %s %s\n", call, data)
+}
+
+func (r *Recorder) FrameworkInfo() *mesos_v1.FrameworkInfo {
+	return r.info
+}
+
+func (r *Recorder) Subscribe(chan *mesos_v1_scheduler.Event) (*http.Response, error) {
+	r.print("scheduler.Subscribe")
+	return nil, nil
+}
+
+func (r *Recorder) Teardown() (*http.Response, error) {
+	r.print("scheduler.Teardown")
+	return nil, nil
+}
+
+func (r *Recorder) Accept(offerIds []*mesos_v1.OfferID, tasks []*mesos_v1.Offer_Operation, filters *mesos_v1.Filters) (*http.Response, error) {
+	r.print("scheduler.Accept", offerIds, tasks, filters)
+	return nil, nil
+}
+
+func (r *Recorder) Decline(offerIds []*mesos_v1.OfferID, filters *mesos_v1.Filters) (*http.Response, error) {
+	r.print("scheduler.Decline", offerIds, filters)
+	return nil, nil
+}
+
+func (r *Recorder) Revive() (*http.Response, error) {
+	r.print("scheduler.Revive")
+	return nil, nil
+}
+
+func (r *Recorder) Kill(taskId *mesos_v1.TaskID, agentid *mesos_v1.AgentID) (*http.Response, error) {
+	r.print("scheduler.Kill", taskId, agentid)
+	return nil, nil
+}
+
+func (r *Recorder) Shutdown(execId *mesos_v1.ExecutorID, agentId *mesos_v1.AgentID) (*http.Response, error) {
+	r.print("scheduler.Shutdown", execId, agentId)
+	return nil, nil
+}
+
+func (r *Recorder) Acknowledge(agentId *mesos_v1.AgentID, taskId *mesos_v1.TaskID, uuid []byte) (*http.Response, error) {
+	r.print("scheduler.Acknowledge", agentId, taskId, uuid)
+	return nil, nil
+}
+
+func (r *Recorder) Reconcile(tasks []*mesos_v1.TaskInfo) (*http.Response, error) {
+	r.print("scheduler.Reconcile", tasks)
+	return nil, nil
+}
+
+func (r *Recorder) Message(agentId *mesos_v1.AgentID, executorId *mesos_v1.ExecutorID, data []byte) (*http.Response, error) {
+	r.print("scheduler.Message", agentId, executorId, data)
+	return nil, nil
+}
+
+func (r *Recorder) SchedRequest(resources []*mesos_v1.Request) (*http.Response, error) {
+	r.print("scheduler.SchedRequest", resources)
+	return nil, nil
+}
+
+func (r *Recorder) Suppress() (*http.Response, error) {
+	r.print("scheduler.Suppress")
+	return nil, nil
+}
+
+func (s *RecordingStore) Create(key, value string) error {
+	s.recorder.print("storage.Create", key, value)
+	return s.KeyValueStore.Create(key, value)
+}
+
+func (s *RecordingStore) CreateWithLease(key, value string, ttl int64) (int64, error) {
+	s.recorder.print("storage.CreateWithLease", key, value, ttl)
+	return s.KeyValueStore.CreateWithLease(key, value, ttl)
+}
+
+func (s *RecordingStore) Update(key, value string) error {
+	s.recorder.print("storage.Update", key, value)
+	return s.KeyValueStore.Update(key, value)
+}
+
+func (s *RecordingStore) RefreshLease(id int64) error {
+	s.recorder.print("storage.RefreshLease", id)
+	return s.KeyValueStore.RefreshLease(id)
+}
+
+func (s *RecordingStore) Delete(key string) error {
+	s.recorder.print("storage.Delete", key)
+	return s.KeyValueStore.Delete(key)
+}
+
+// Feeds journaled entries back in order, events to the event handler and API requests to the API handler.
+// Each entry is printed before the calls it results in, along with the response to API requests.
+// Tasks that are due to be rescheduled are handled in between entries so everything runs one call at a time.
+func Replay(entries []Entry, h events.SchedulerEvent, api http.Handler, revives chan *manager.Task, out io.Writer) {
+	for i, entry := range entries {
+		stamp := entry.Time.Format(time.RFC3339Nano)
+		switch entry.Type {
+		case EVENT:
+			fmt.Fprintf(out, "#%d %s event %s\n", i+1, stamp, entry.Event.GetType().String())
+			h.Run(entry.Event)
+		case API:
+			fmt.Fprintf(out, "#%d %s api %s %s\n", i+1, stamp, entry.Request.Method, entry.Request.Path)
+
+			req, err := http.NewRequest(entry.Request.Method, entry.Request.Path, strings.NewReader(entry.Request.Body))
+			if err != nil {
+				fmt.Fprintf(out, "  invalid request: %s\n", err.Error())
+				continue
+			}
+
+			rr := httptest.NewRecorder()
+			api.ServeHTTP(rr, req)
+			fmt.Fprintf(out, "  response %d %s\n", rr.Code, strings.TrimSpace(rr.Body.String()))
+		default:
+			fmt.Fprintf(out, "#%d %s unknown entry type %s\n", i+1, stamp, entry.Type)
+		}
+
+		reschedule(h, revives, out)
+	}
+}
+
+// Reschedules every task that's waiting to be without blocking.
+func reschedule(h events.SchedulerEvent, revives chan *manager.Task, out io.Writer) {
+	for {
+		select {
+		case task := <-revives:
+			fmt.Fprintf(out, "  reschedule %s\n", task.Info.GetName())
+			h.Reschedule(task)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"context"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Stops part of the scheduler, giving up once the context is done.
+type StopFunc func(ctx context.Context) error
+
+type step struct {
+	name string
+	stop StopFunc
+}
+
+//
+// Lifecycle owns the root context of the scheduler and shuts everything down in order once we're told to stop.
+// Everything long-running should run off the root context so it winds down as soon as shutdown starts.
+// Whatever has to finish cleanly registers a step that's run once the root context is cancelled.
+//
+type Lifecycle struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+	logger  logging.Logger
+	mutex   sync.Mutex
+	steps   []step
+	stop    chan struct{}
+	force   chan struct{}
+	once    sync.Once
+}
+
+// Returns a lifecycle manager whose steps all have to be done within the timeout.
+// We start shutting down on SIGINT or SIGTERM from here on. A second signal gives up on shutting down cleanly.
+func NewLifecycle(timeout time.Duration, l logging.Logger) *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := &Lifecycle{
+		ctx:     ctx,
+		cancel:  cancel,
+		timeout: timeout,
+		logger:  l,
+		stop:    make(chan struct{}),
+		force:   make(chan struct{}),
+	}
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		l.Emit(logging.INFO, "Received %s, shutting down", sig.String())
+		lc.Stop()
+
+		<-sigs
+		l.Emit(logging.ERROR, "Received another signal, not waiting for shutdown to finish")
+		close(lc.force)
+	}()
+
+	return lc
+}
+
+// Gets the root context, which is cancelled as soon as we start shutting down.
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Registers a step to run when shutting down. Steps are run one at a time in the order they were registered.
+func (l *Lifecycle) OnStop(name string, f StopFunc) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.steps = append(l.steps, step{name: name, stop: f})
+}
+
+// Starts shutting down by cancelling the root context.
+func (l *Lifecycle) Stop() {
+	l.once.Do(func() {
+		l.cancel()
+		close(l.stop)
+	})
+}
+
+//
+// Wait blocks until we start shutting down, then runs every step in order and returns the status to exit with.
+// The status is only clean if every step finished in time.
+//
+func (l *Lifecycle) Wait() int {
+	<-l.stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-l.force:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	l.mutex.Lock()
+	steps := l.steps
+	l.mutex.Unlock()
+
+	status := 0
+	for _, s := range steps {
+		if err := s.stop(ctx); err != nil {
+			l.logger.Emit(logging.ERROR, "Failed to stop the %s cleanly: %s", s.name, err.Error())
+			status = 1
+			continue
+		}
+
+		l.logger.Emit(logging.INFO, "Stopped the %s", s.name)
+	}
+
+	return status
+}
+
+// Returns a step that waits for a goroutine to signal it's finished by closing the channel.
+func Done(done <-chan struct{}) StopFunc {
+	return func(ctx context.Context) error {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
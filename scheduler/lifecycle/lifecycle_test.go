@@ -0,0 +1,88 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"testing"
+	"time"
+)
+
+// Steps run in order once the root context is cancelled.
+func TestLifecycle_Wait(t *testing.T) {
+	lc := NewLifecycle(time.Second, new(mockLogger.MockLogger))
+	ctx := lc.Context()
+
+	order := []string{}
+	lc.OnStop("first", func(context.Context) error {
+		if ctx.Err() == nil {
+			t.Fatal("The root context should be cancelled before any step runs")
+		}
+		order = append(order, "first")
+		return nil
+	})
+	lc.OnStop("second", func(context.Context) error {
+		order = append(order, "second")
+		return nil
+	})
+
+	lc.Stop()
+	lc.Stop()
+	if status := lc.Wait(); status != 0 {
+		t.Fatalf("Expected a clean exit, got %d", status)
+	}
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Fatalf("Steps ran in the wrong order: %v", order)
+	}
+}
+
+// A step that fails or runs out of time doesn't stop the rest from running but the exit isn't clean.
+func TestLifecycle_WaitFailed(t *testing.T) {
+	lc := NewLifecycle(10*time.Millisecond, new(mockLogger.MockLogger))
+
+	ran := false
+	lc.OnStop("failing", func(context.Context) error {
+		return errors.New("Broken")
+	})
+	lc.OnStop("stuck", Done(make(chan struct{})))
+	lc.OnStop("last", func(context.Context) error {
+		ran = true
+		return nil
+	})
+
+	lc.Stop()
+	if status := lc.Wait(); status != 1 {
+		t.Fatalf("Expected an unclean exit, got %d", status)
+	}
+	if !ran {
+		t.Fatal("Every step should run even if earlier ones failed")
+	}
+}
+
+func TestDone(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	if err := Done(done)(context.Background()); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Done(make(chan struct{}))(ctx); err != context.Canceled {
+		t.Fatalf("Expected to give up once the context is done, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"sync"
+)
+
+var ErrShuttingDown = errors.New("Storage no longer takes writes since we're shutting down")
+
+//
+// Tracked wraps storage so shutting down can wait for every write that's in flight to finish.
+// Once flushed nothing new is written, so what's in storage when we exit is exactly what was acknowledged.
+// Reads and lease renewals are always let through.
+//
+type Tracked struct {
+	persistence.Storage
+	mutex   sync.Mutex
+	writes  int
+	flushed bool
+	drained chan struct{}
+	once    sync.Once
+}
+
+// Returns storage that keeps track of writes in flight.
+func NewTracked(s persistence.Storage) *Tracked {
+	return &Tracked{
+		Storage: s,
+		drained: make(chan struct{}),
+	}
+}
+
+//
+// Flush stops taking writes and waits for the ones in flight to finish.
+// Gives up once the context is done.
+//
+func (t *Tracked) Flush(ctx context.Context) error {
+	t.mutex.Lock()
+	t.flushed = true
+	if t.writes == 0 {
+		t.once.Do(func() { close(t.drained) })
+	}
+	t.mutex.Unlock()
+
+	select {
+	case <-t.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Counts a write as in flight unless we've been flushed.
+func (t *Tracked) begin() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.flushed {
+		return ErrShuttingDown
+	}
+	t.writes++
+
+	return nil
+}
+
+// Counts a write as done.
+func (t *Tracked) end() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.writes--
+	if t.flushed && t.writes == 0 {
+		t.once.Do(func() { close(t.drained) })
+	}
+}
+
+func (t *Tracked) Create(key, value string) error {
+	if err := t.begin(); err != nil {
+		return err
+	}
+	defer t.end()
+
+	return t.Storage.Create(key, value)
+}
+
+func (t *Tracked) CreateWithLease(key, value string, ttl int64) (int64, error) {
+	if err := t.begin(); err != nil {
+		return 0, err
+	}
+	defer t.end()
+
+	return t.Storage.CreateWithLease(key, value, ttl)
+}
+
+func (t *Tracked) Update(key, value string) error {
+	if err := t.begin(); err != nil {
+		return err
+	}
+	defer t.end()
+
+	return t.Storage.Update(key, value)
+}
+
+func (t *Tracked) Delete(key string) error {
+	if err := t.begin(); err != nil {
+		return err
+	}
+	defer t.end()
+
+	return t.Storage.Delete(key)
+}
+
+func (t *Tracked) Transaction(ops ...persistence.Operation) error {
+	if err := t.begin(); err != nil {
+		return err
+	}
+	defer t.end()
+
+	return t.Storage.Transaction(ops...)
+}
@@ -0,0 +1,77 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lifecycle
+
+import (
+	"context"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"testing"
+	"time"
+)
+
+// Storage that holds writes until it's told to let them through.
+type slowKV struct {
+	*mockStorage.MockFailingKVStore
+	release chan struct{}
+}
+
+func (s slowKV) Update(key, value string) error {
+	<-s.release
+	return s.MockFailingKVStore.Update(key, value)
+}
+
+// Flushing waits for writes in flight and refuses new ones.
+func TestTracked_Flush(t *testing.T) {
+	kv := slowKV{&mockStorage.MockFailingKVStore{Data: map[string]string{}}, make(chan struct{})}
+	tracked := NewTracked(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}))
+
+	written := make(chan error)
+	go func() {
+		written <- tracked.Update("/a", "a")
+	}()
+	for {
+		tracked.mutex.Lock()
+		writes := tracked.writes
+		tracked.mutex.Unlock()
+		if writes == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tracked.Flush(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Flushing shouldn't finish while a write is in flight, got %v", err)
+	}
+	if err := tracked.Create("/b", "b"); err != ErrShuttingDown {
+		t.Fatalf("Writes after flushing should be refused, got %v", err)
+	}
+
+	close(kv.release)
+	if err := <-written; err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := tracked.Flush(context.Background()); err != nil {
+		t.Fatal(err.Error())
+	}
+	if kv.Data["/a"] != "a" || kv.Data["/b"] != "" {
+		t.Fatalf("Unexpected data after flushing: %v", kv.Data)
+	}
+	if value, err := tracked.Read("/a"); err != nil || value != "a" {
+		t.Fatal("Reads should always be let through")
+	}
+}
@@ -15,27 +15,45 @@
 package main
 
 import (
-	"encoding/base64"
+	"context"
+	"encoding/json"
 	"flag"
 	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
 	"github.com/verizonlabs/hydrogen/scheduler/api"
 	apiManager "github.com/verizonlabs/hydrogen/scheduler/api/manager"
+	"github.com/verizonlabs/hydrogen/scheduler/auth"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
 	"github.com/verizonlabs/hydrogen/scheduler/controller"
 	"github.com/verizonlabs/hydrogen/scheduler/events"
+	"github.com/verizonlabs/hydrogen/scheduler/framework"
 	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/journal"
+	"github.com/verizonlabs/hydrogen/scheduler/lifecycle"
+	"github.com/verizonlabs/hydrogen/scheduler/master"
+	"github.com/verizonlabs/hydrogen/task/ipam"
 	"github.com/verizonlabs/hydrogen/task/manager"
 	"github.com/verizonlabs/hydrogen/task/persistence"
-	"github.com/verizonlabs/mesos-framework-sdk/client"
+	"github.com/verizonlabs/hydrogen/task/persistence/backup"
+	"github.com/verizonlabs/hydrogen/task/persistence/drivers/embedded"
+	"github.com/verizonlabs/hydrogen/task/persistence/drivers/etcd"
+	"github.com/verizonlabs/hydrogen/task/persistence/encryption"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	"github.com/verizonlabs/hydrogen/task/queue"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/logging"
-	"github.com/verizonlabs/mesos-framework-sdk/persistence/drivers/etcd"
+	sdkPersistence "github.com/verizonlabs/mesos-framework-sdk/persistence"
 	resourceManager "github.com/verizonlabs/mesos-framework-sdk/resources/manager"
 	sched "github.com/verizonlabs/mesos-framework-sdk/scheduler"
 	"github.com/verizonlabs/mesos-framework-sdk/server"
 	"github.com/verizonlabs/mesos-framework-sdk/server/file"
 	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	t "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 )
 
@@ -57,6 +75,9 @@ func main() {
 	}
 
 	flag.Parse()
+	for _, name := range scheduler.DeprecatedFlags() {
+		logger.Emit(logging.ALARM, "-%s is deprecated and has no effect, remove it from the command line", name)
+	}
 
 	// Executor Server
 	execSrvCfg := server.NewConfiguration(
@@ -71,13 +92,177 @@ func main() {
 	logger.Emit(logging.INFO, "Starting executor file server")
 	go executorSrv.Serve()
 
+	// Cancelled when we're told to shut down so storage operations stop retrying.
+	lc := lifecycle.NewLifecycle(config.Scheduler.ShutdownTimeout, logger)
+	ctx := lc.Context()
+
+	// Key value store that everything is persisted to.
+	var kv sdkPersistence.KeyValueStore
+	switch config.Persistence.Driver {
+	case "etcd":
+		e, err := etcd.NewClient(
+			strings.Split(config.Persistence.Endpoints, ","),
+			config.Persistence.Timeout,
+			config.Persistence.KeepaliveTime,
+			config.Persistence.KeepaliveTimeout,
+		)
+		if err != nil {
+			logger.Emit(logging.ERROR, "Failed to connect to etcd: %s", err.Error())
+			os.Exit(1)
+		}
+		kv = e
+	case "embedded":
+		e, err := embedded.NewClient(config.Persistence.Path)
+		if err != nil {
+			logger.Emit(logging.ERROR, "Failed to open embedded storage: %s", err.Error())
+			os.Exit(1)
+		}
+		kv = e
+	default:
+		logger.Emit(logging.ERROR, "Unknown storage driver %s", config.Persistence.Driver)
+		os.Exit(1)
+	}
+
+	// Keep our keys apart from any other framework sharing the same store.
+	prefix := config.Persistence.Prefix
+	if prefix == "" {
+		prefix = config.Scheduler.Name
+	}
+
+	// One-shot move of keys written before they were namespaced.
+	// The leader key isn't moved since it's rewritten as soon as an election happens.
+	// The framework ID expires along with the failover timeout, so it keeps a lease of the same length.
+	if config.Persistence.Migrate {
+		leases := make(map[string]int64)
+		if config.Scheduler.Failover > 0 {
+			leases["/frameworkId"] = int64(config.Scheduler.Failover)
+		}
+
+		moved, skipped, err := persistence.MigrateUnprefixed(kv, prefix, leases,
+			manager.TASK_DIRECTORY,
+			agent.BLACKLIST_DIRECTORY,
+			ipam.POOL_DIRECTORY,
+			"/frameworkId",
+		)
+		for _, key := range skipped {
+			logger.Emit(logging.ERROR, "Not migrating %s, it already exists under %s with a different value", key, prefix)
+		}
+		if err != nil {
+			logger.Emit(logging.ERROR, "Failed to migrate storage keys: %s", err.Error())
+			os.Exit(1)
+		}
+
+		logger.Emit(logging.INFO, "Migrated %d storage keys under %s", moved, persistence.Prefix(prefix))
+		os.Exit(0)
+	}
+
+	// Starting against state that hasn't been moved yet would register as a brand new framework.
+	unmigrated, err := persistence.Unmigrated(kv, prefix, "/frameworkId", manager.TASK_DIRECTORY)
+	if err != nil {
+		logger.Emit(logging.ERROR, "Failed to check for storage keys that need migrating: %s", err.Error())
+		os.Exit(1)
+	}
+	if unmigrated {
+		logger.Emit(logging.ERROR, "Found state written before keys were kept under %s, run once with -persistence.migrate "+
+			"to move it before starting", persistence.Prefix(prefix))
+		os.Exit(1)
+	}
+
 	// Storage interface that holds client and retry policy manager.
-	p := persistence.NewPersistence(etcd.NewClient(
-		strings.Split(config.Persistence.Endpoints, ","),
-		config.Persistence.Timeout,
-		config.Persistence.KeepaliveTime,
-		config.Persistence.KeepaliveTimeout,
-	), config.Persistence.MaxRetries)
+	p := persistence.NewPersistence(kv, prefix, persistence.RetryPolicy{
+		MaxRetries: config.Persistence.MaxRetries,
+		Backoff:    config.Persistence.RetryBackoff,
+		MaxBackoff: config.Persistence.RetryMaxBackoff,
+		Jitter:     config.Persistence.RetryJitter,
+	})
+
+	// Task definitions can hold credentials in their environment so keep them encrypted at rest if we have keys.
+	var keys *encryption.Keyring
+	if config.Persistence.EncryptionKeys != "" {
+		keys, err = encryption.LoadKeyring(config.Persistence.EncryptionKeys)
+		if err != nil {
+			logger.Emit(logging.ERROR, "Failed to load encryption keys: %s", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Restoring a backup happens before any election so it writes straight to storage.
+	if config.Persistence.Restore != "" {
+		restored := p
+		if keys != nil {
+			restored = encryption.NewEncrypted(p, keys, []string{manager.TASK_DIRECTORY}, logger)
+		}
+		b := backup.NewBackup(restored, logger)
+
+		data, err := ioutil.ReadFile(config.Persistence.Restore)
+		if err != nil {
+			logger.Emit(logging.ERROR, "Failed to read backup: %s", err.Error())
+			os.Exit(1)
+		}
+
+		archive := new(backup.Archive)
+		if err := json.Unmarshal(data, archive); err != nil {
+			logger.Emit(logging.ERROR, "Failed to parse backup: %s", err.Error())
+			os.Exit(1)
+		}
+		if err := b.Restore(ctx, archive, config.Scheduler.Failover); err != nil {
+			logger.Emit(logging.ERROR, "Failed to restore backup: %s", err.Error())
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	// Instances only trust each other's certificates if they're signed by the CA.
+	peer, err := ha.LoadTLS(config.Leader)
+	if err != nil {
+		logger.Emit(logging.ERROR, "Failed to load HA TLS configuration: %s", err.Error())
+		os.Exit(1)
+	}
+
+	// Standbys send API requests that change anything to whatever address the leader advertises.
+	// That's a port of its own that only other instances can use if they authenticate each other.
+	if config.Leader.API == "" {
+		switch {
+		case peer != nil:
+			config.Leader.API = "https://" + net.JoinHostPort(config.Leader.IP, strconv.Itoa(config.Leader.Port))
+		case config.APIServer.Cert != "" && config.APIServer.Key != "":
+			config.Leader.API = "https://" + net.JoinHostPort(config.Leader.IP, strconv.Itoa(config.APIServer.Port))
+		default:
+			config.Leader.API = "http://" + net.JoinHostPort(config.Leader.IP, strconv.Itoa(config.APIServer.Port))
+		}
+	}
+
+	// Elects our leader. Everything else only writes to storage while we're leading.
+	election := ha.NewHA(p, logger, config.Leader)
+	p = ha.NewFenced(p, election)
+
+	// Lets shutting down wait for whatever's being written.
+	tracked := lifecycle.NewTracked(p)
+	p = tracked
+
+	// Encrypting on top of the fence means re-encrypting old values is fenced and waited for like any other write.
+	var encrypted *encryption.Encrypted
+	if keys != nil {
+		encrypted = encryption.NewEncrypted(p, keys, []string{manager.TASK_DIRECTORY}, logger)
+		p = encrypted
+	}
+
+	// Takes snapshots of everything in storage and puts them back.
+	b := backup.NewBackup(p, logger)
+
+	// Upgrades what older versions left in storage. Migrations register themselves from the packages that own the data.
+	migrator := schema.NewMigrator(p, schema.Registered(), logger)
+	if config.Persistence.SchemaDryRun {
+		reports, err := migrator.Run(ctx, true)
+		if err != nil {
+			logger.Emit(logging.ERROR, "Storage migration dry run failed: %s", err.Error())
+			os.Exit(1)
+		}
+
+		logger.Emit(logging.INFO, "%d storage migrations are pending", len(reports))
+		os.Exit(0)
+	}
 
 	// Manages our tasks.
 	taskManager := manager.NewTaskManager(
@@ -86,25 +271,87 @@ func main() {
 		logger,
 	)
 
-	auth := "Basic " + base64.StdEncoding.EncodeToString(
-		[]byte(config.Scheduler.Principal+":"+config.Scheduler.Secret),
-	)
+	// Proves who we are to Mesos, either with our secret or by logging in as a service account.
+	var authenticator auth.Authenticator = auth.NewBasic(config.Scheduler.Principal, config.Scheduler.Secret)
+	if config.Scheduler.AuthKey != "" {
+		if config.Scheduler.AuthLogin == "" {
+			logger.Emit(logging.ERROR, "A login endpoint is needed to log in as a service account")
+			os.Exit(1)
+		}
+
+		key, err := auth.LoadKey(config.Scheduler.AuthKey)
+		if err != nil {
+			logger.Emit(logging.ERROR, "Failed to load the service account key: %s", err.Error())
+			os.Exit(1)
+		}
+		authenticator = auth.NewServiceAccount(
+			config.Scheduler.Principal,
+			key,
+			config.Scheduler.AuthLogin,
+			config.Scheduler.AuthRefresh,
+			logger,
+		)
+	}
+
+	// Tracks why queued tasks haven't launched yet.
+	q := queue.NewLaunchQueue(config.Scheduler.QueueHistory)
+
+	// Tracks the agents we've seen in offers along with the blacklist and quarantined agents.
+	a := agent.NewInventory(p, config.Scheduler.BlacklistRefuse, agent.QuarantinePolicy{
+		Threshold: config.Scheduler.QuarantineThreshold,
+		Window:    config.Scheduler.QuarantineWindow,
+		Cooldown:  config.Scheduler.QuarantineCooldown,
+		Apps:      config.Scheduler.QuarantineApps,
+	}, logger)
+
+	// Hands out unique addresses from named pools to tasks on CNI networks.
+	i := ipam.NewPools(p, logger)
 
 	r := resourceManager.NewDefaultResourceManager() // Manages resources from the cluster
-	c := client.NewClient(client.ClientData{
-		Endpoint: config.Scheduler.MesosEndpoint,
-		Auth:     auth,
-	}, logger) // Manages scheduler/executor HTTP calls, authorization, and new master detection.
-	s := sched.NewDefaultScheduler(c, frameworkInfo, logger) // Manages how to route and schedule tasks.
-	m := apiManager.NewApiParser(r, taskManager, s)          // Middleware for our API.
-	ha := ha.NewHA(p, logger, config.Leader)
+
+	// Manages scheduler HTTP calls, authorization, and failing over between masters.
+	mc := master.NewClient(
+		strings.Split(config.Scheduler.MesosEndpoint, ","),
+		authenticator,
+		config.Scheduler.SubscribeRetry,
+		config.Scheduler.SubscribeRetryMax,
+		logger,
+	)
+	s := sched.NewDefaultScheduler(mc, frameworkInfo, logger) // Manages how to route and schedule tasks.
+
+	// Finds where memory, storage and Mesos disagree about our tasks.
+	k := consistency.NewChecker(taskManager, p, s, config.Scheduler.ConsistencyTimeout, logger)
+
+	// Tracks tasks Mesos runs for us that we don't know about.
+	// Standbys never get status updates so they never have any orphans to kill.
+	o := consistency.NewOrphans(s, consistency.OrphanPolicy{
+		Kill:  config.Scheduler.OrphanKill,
+		Grace: config.Scheduler.OrphanGrace,
+	}, logger)
+	go o.Run(ctx)
+
+	// Retires the framework when an operator asks us to.
+	d := framework.NewTeardown(ctx, taskManager, s, q, i, p, config.Scheduler.TeardownTimeout, logger)
+
+	m := apiManager.NewApiParser(r, taskManager, s, q, a, i, b, k, o, election, mc, d) // Middleware for our API.
 
 	// Used to listen for events coming from mesos master to our scheduler.
 	eventChan := make(chan *mesos_v1_scheduler.Event)
 	reviveChan := make(chan *sdkTaskManager.Task)
 
 	// Event controller manages scheduler events and how they are handled.
-	e := controller.NewEventController(config, s, taskManager, p, logger, ha)
+	e := controller.NewEventController(config, s, taskManager, p, logger, election, a, i, migrator, encrypted, k, mc)
+
+	// Records what's handed to the scheduler so problems can be replayed offline.
+	var j *journal.Journal
+	if config.Scheduler.JournalPath != "" {
+		var err error
+		j, err = journal.NewJournal(config.Scheduler.JournalPath, config.Scheduler.JournalSize, config.Scheduler.JournalFiles, logger)
+		if err != nil {
+			logger.Emit(logging.ERROR, "Failed to open the journal: %s", err.Error())
+			os.Exit(1)
+		}
+	}
 
 	logger.Emit(logging.INFO, "Starting API server")
 
@@ -116,11 +363,36 @@ func main() {
 		config.APIServer.Port,
 	)
 
-	apiSrv := api.NewApiServer(config, m, logger)
+	apiSrv := api.NewApiServer(config, m, election, peer, j, logger)
 	go apiSrv.RunAPI(nil) // nil means to use default handlers.
+	go apiSrv.RunPeer()
 
 	// Run our event controller and kick off HA leader election.
 	// Then subscribe to Mesos and start listening for events.
-	h := events.NewHandler(taskManager, r, config, s, p, reviveChan, logger)
-	e.Run(eventChan, reviveChan, h)
+	h := events.NewHandler(ctx, taskManager, r, config, s, p, reviveChan, q, a, i, k, o, logger)
+	if j != nil {
+		h = journal.NewHandler(h, j)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		e.Run(ctx, eventChan, reviveChan, h)
+		close(stopped)
+	}()
+
+	// Everything that's running off the root context stops as soon as we're told to shut down.
+	// The API and event loop get to finish what they're doing before anything else is stopped.
+	lc.OnStop("API server", apiSrv.Shutdown)
+	lc.OnStop("event loop", lifecycle.Done(stopped))
+	lc.OnStop("scheduler", func(context.Context) error {
+		h.Signals()
+		return nil
+	})
+	lc.OnStop("storage writes", tracked.Flush)
+	if j != nil {
+		lc.OnStop("journal", func(context.Context) error {
+			return j.Close()
+		})
+	}
+
+	os.Exit(lc.Wait())
 }
@@ -0,0 +1,351 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"github.com/verizonlabs/hydrogen/scheduler/auth"
+	"github.com/verizonlabs/mesos-framework-sdk/client"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// States our subscription to Mesos can be in.
+const (
+	DISCONNECTED = "disconnected"
+	SUBSCRIBING  = "subscribing"
+	SUBSCRIBED   = "subscribed"
+)
+
+// How often we check on our subscription until the master tells us how often it sends heartbeats.
+const defaultHeartbeat = 15 * time.Second
+
+// Describes which master we're talking to and how our subscription is doing.
+// Failures counts the attempts in a row that didn't get us subscribed.
+// Stalls counts every time we dropped a subscription because the master stopped sending us heartbeats.
+type Status struct {
+	Master    string    `json:"master"`
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	LastEvent time.Time `json:"last_event"`
+	Failures  int       `json:"failures"`
+	Stalls    int       `json:"stalls"`
+	Endpoints []string  `json:"endpoints"`
+}
+
+//
+// Client sends calls to whichever of the Mesos masters is leading.
+// Masters that aren't leading redirect us to the one that is, which we then stick with until the connection drops.
+// Each time our subscription drops we move on to the next master we were given,
+// waiting twice as long as before for every attempt in a row that didn't get us subscribed.
+// A master that stops sending heartbeats is treated the same as one whose connection dropped.
+// Every subscription uses the credentials our authenticator gives us at the time,
+// and we subscribe again to the same master once they've been refreshed.
+//
+type Client struct {
+	endpoints  []string
+	backoff    time.Duration
+	maxBackoff time.Duration
+	auth       auth.Authenticator
+	connect    func(endpoint, header string) client.Client
+	logger     logging.Logger
+	mutex      sync.Mutex
+	clients    map[string]client.Client
+	next       int
+	master     string
+	state      string
+	since      time.Time
+	failures   int
+	stream     io.Closer
+	heartbeat  time.Duration
+	lastEvent  time.Time
+	stalls     int
+	header     string
+	renewing   bool
+}
+
+// Returns a client for the given masters, starting with the first one.
+func NewClient(endpoints []string, a auth.Authenticator, backoff, maxBackoff time.Duration, l logging.Logger) *Client {
+	return &Client{
+		endpoints:  endpoints,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		auth:       a,
+		connect: func(endpoint, header string) client.Client {
+			return client.NewClient(client.ClientData{
+				Endpoint: endpoint,
+				Auth:     header,
+			}, l)
+		},
+		logger:  l,
+		clients: make(map[string]client.Client),
+		next:    1 % len(endpoints),
+		master:  endpoints[0],
+		state:   DISCONNECTED,
+		since:   time.Now(),
+	}
+}
+
+//
+// Request sends a call to the master we're talking to, following it to the leading master if it redirects us there.
+// A successful subscribe call means we're subscribed until Disconnected is called.
+//
+func (c *Client) Request(call interface{}) (*http.Response, error) {
+	subscribe := false
+	if call, ok := call.(*mesos_v1_scheduler.Call); ok && call.GetType() == mesos_v1_scheduler.Call_SUBSCRIBE {
+		subscribe = true
+		c.transition(SUBSCRIBING)
+
+		// Clients hold on to the credentials they were made with so a new subscription gets new clients.
+		header, err := c.auth.Header()
+		if err != nil {
+			return nil, err
+		}
+		c.mutex.Lock()
+		c.header = header
+		c.clients = make(map[string]client.Client)
+		c.mutex.Unlock()
+	}
+
+	resp, err := c.current().Request(call)
+	if leader, ok := c.redirected(resp); ok {
+		c.follow(leader)
+
+		// The redirect was followed for us, otherwise we send the call again ourselves.
+		if resp.StatusCode == http.StatusTemporaryRedirect {
+			resp.Body.Close()
+			resp, err = c.current().Request(call)
+		}
+	}
+
+	if subscribe && err == nil && resp != nil && resp.StatusCode == http.StatusOK {
+		c.mutex.Lock()
+		c.stream = resp.Body
+		c.lastEvent = time.Now()
+		c.mutex.Unlock()
+
+		c.transition(SUBSCRIBED)
+		c.logger.Emit(logging.INFO, "Subscribed to Mesos master %s", c.Status().Master)
+	}
+
+	return resp, err
+}
+
+// Received notes that the master sent us an event, and how often it sends heartbeats if it's telling us.
+func (c *Client) Received(event *mesos_v1_scheduler.Event) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.lastEvent = time.Now()
+	if event.GetType() == mesos_v1_scheduler.Event_SUBSCRIBED {
+		c.heartbeat = time.Duration(event.GetSubscribed().GetHeartbeatIntervalSeconds() * float64(time.Second))
+	}
+}
+
+//
+// Watch drops our subscription once the master goes quiet for the given number of heartbeats in a row,
+// which makes us subscribe again as if the connection had dropped. Zero misses never drops a quiet subscription.
+// Our subscription is also dropped once our credentials are refreshed so we subscribe again with the new ones.
+// This method blocks until the context is done.
+//
+func (c *Client) Watch(ctx context.Context, misses int) {
+	for {
+		select {
+		case <-time.After(c.check(misses)):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Gets the stream ID of our subscription to the master we're talking to.
+func (c *Client) StreamID() string {
+	return c.current().StreamID()
+}
+
+//
+// Disconnected marks our subscription as lost and moves on to the next master.
+// Returns how long to wait before subscribing again, which doubles for every attempt in a row that didn't get us subscribed.
+//
+func (c *Client) Disconnected() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// We dropped the subscription ourselves to renew our credentials so there's nothing wrong with this master.
+	if c.renewing {
+		c.renewing = false
+		c.state = DISCONNECTED
+		c.since = time.Now()
+		c.stream = nil
+		return 0
+	}
+
+	wait := c.backoff
+	for i := 0; i < c.failures && (c.maxBackoff <= 0 || wait < c.maxBackoff); i++ {
+		wait *= 2
+	}
+	if c.maxBackoff > 0 && wait > c.maxBackoff {
+		wait = c.maxBackoff
+	}
+	c.failures++
+
+	c.logger.Emit(logging.ERROR, "Lost connection to Mesos master %s, trying %s in %s", c.master, c.endpoints[c.next], wait)
+	c.master = c.endpoints[c.next]
+	c.next = (c.next + 1) % len(c.endpoints)
+	c.state = DISCONNECTED
+	c.stream = nil
+	c.since = time.Now()
+
+	return wait
+}
+
+// Reports which master we're talking to and how our subscription is doing.
+func (c *Client) Status() Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return Status{
+		Master:    c.master,
+		State:     c.state,
+		Since:     c.since,
+		LastEvent: c.lastEvent,
+		Failures:  c.failures,
+		Stalls:    c.stalls,
+		Endpoints: c.endpoints,
+	}
+}
+
+//
+// Drops our subscription if we've missed too many heartbeats or our credentials were refreshed,
+// and returns how long to wait until checking again.
+//
+func (c *Client) check(misses int) time.Duration {
+	// Refreshing our credentials can mean logging in again so don't hold anything up while we do.
+	header, err := c.auth.Header()
+	if err != nil {
+		c.logger.Emit(logging.ERROR, "Failed to refresh our credentials for Mesos: %s", err.Error())
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.state != SUBSCRIBED || c.stream == nil {
+		return defaultHeartbeat
+	}
+
+	// Calls on our subscription are made with the credentials we subscribed with, so switch over before those expire.
+	if err == nil && header != c.header {
+		c.logger.Emit(logging.INFO, "Our credentials for Mesos were refreshed, subscribing to %s again", c.master)
+		c.renewing = true
+		c.stream.Close()
+		c.stream = nil
+		return defaultHeartbeat
+	}
+
+	if misses <= 0 || c.heartbeat <= 0 {
+		return defaultHeartbeat
+	}
+
+	quiet := time.Since(c.lastEvent)
+	limit := time.Duration(misses) * c.heartbeat
+	if quiet < limit {
+		return limit - quiet
+	}
+
+	// Closing the stream makes the subscribe call return so we subscribe again.
+	c.stalls++
+	c.logger.Emit(logging.ERROR, "Missed %d heartbeats from Mesos master %s, nothing received for %s, resubscribing",
+		misses, c.master, quiet)
+	c.stream.Close()
+	c.stream = nil
+
+	return c.heartbeat
+}
+
+// Gets the client for the master we're talking to, connecting to it if we haven't yet.
+func (c *Client) current() client.Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cl, ok := c.clients[c.master]
+	if !ok {
+		cl = c.connect(c.master, c.header)
+		c.clients[c.master] = cl
+	}
+
+	return cl
+}
+
+// Finds out if we've been sent to another master, either by a redirect we got back or one that was followed for us.
+func (c *Client) redirected(resp *http.Response) (string, bool) {
+	if resp == nil {
+		return "", false
+	}
+
+	c.mutex.Lock()
+	master := c.master
+	c.mutex.Unlock()
+	base, err := url.Parse(master)
+	if err != nil {
+		return "", false
+	}
+
+	var leader *url.URL
+	switch {
+	case resp.StatusCode == http.StatusTemporaryRedirect:
+		// Masters send the leader's address without a scheme.
+		location, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || resp.Header.Get("Location") == "" {
+			return "", false
+		}
+		leader = base.ResolveReference(location)
+	case resp.Request != nil && resp.Request.URL != nil && resp.Request.URL.Host != base.Host:
+		leader = resp.Request.URL
+	default:
+		return "", false
+	}
+
+	return leader.String(), leader.String() != master
+}
+
+// Sticks with the leading master from now on.
+func (c *Client) follow(leader string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.logger.Emit(logging.INFO, "Mesos master %s redirected us to the leading master %s", c.master, leader)
+	c.master = leader
+}
+
+// Moves our subscription into a new state.
+func (c *Client) transition(state string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.state = state
+	c.since = time.Now()
+	switch state {
+	case SUBSCRIBING:
+		// We don't know how often the next master sends heartbeats until it tells us.
+		c.heartbeat = 0
+	case SUBSCRIBED:
+		c.failures = 0
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package master
+
+import (
+	"context"
+	"github.com/verizonlabs/mesos-framework-sdk/client"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1_scheduler"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Answers calls the way a single master would.
+type fakeMaster struct {
+	endpoint string
+	header   string
+	respond  func(endpoint string) *http.Response
+	calls    *[]string
+}
+
+// Hands out whatever header it's told to.
+type fakeAuth struct {
+	mutex  sync.Mutex
+	header string
+}
+
+func (f *fakeAuth) Header() (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.header, nil
+}
+
+func (f *fakeAuth) set(header string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.header = header
+}
+
+func (f fakeMaster) Request(interface{}) (*http.Response, error) {
+	*f.calls = append(*f.calls, f.endpoint)
+	return f.respond(f.endpoint), nil
+}
+
+func (f fakeMaster) StreamID() string {
+	return f.endpoint
+}
+
+func response(status int, location string) *http.Response {
+	resp := &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	if location != "" {
+		resp.Header.Set("Location", location)
+	}
+
+	return resp
+}
+
+func fakeClient(endpoints []string, respond func(endpoint string) *http.Response) (*Client, *[]string) {
+	calls := []string{}
+	c := NewClient(endpoints, &fakeAuth{header: "Bearer a"}, time.Second, 5*time.Second, new(mockLogger.MockLogger))
+	c.connect = func(endpoint, header string) client.Client {
+		return fakeMaster{endpoint: endpoint, header: header, respond: respond, calls: &calls}
+	}
+
+	return c, &calls
+}
+
+func subscribe() *mesos_v1_scheduler.Call {
+	return &mesos_v1_scheduler.Call{Type: mesos_v1_scheduler.Call_SUBSCRIBE.Enum()}
+}
+
+// Masters that aren't leading send us to the one that is and we stick with it.
+func TestClient_Redirect(t *testing.T) {
+	c, calls := fakeClient([]string{"http://10.0.0.1:5050/api/v1/scheduler"}, func(endpoint string) *http.Response {
+		if strings.HasPrefix(endpoint, "http://10.0.0.1") {
+			return response(http.StatusTemporaryRedirect, "//10.0.0.2:5050/api/v1/scheduler")
+		}
+		return response(http.StatusOK, "")
+	})
+
+	resp, err := c.Request(subscribe())
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected to subscribe through the redirect, got %v and %v", resp, err)
+	}
+	if len(*calls) != 2 || (*calls)[1] != "http://10.0.0.2:5050/api/v1/scheduler" {
+		t.Fatalf("Expected the call to be sent again to the leading master, got %v", *calls)
+	}
+
+	status := c.Status()
+	if status.Master != "http://10.0.0.2:5050/api/v1/scheduler" || status.State != SUBSCRIBED {
+		t.Fatalf("Unexpected status after following the redirect %+v", status)
+	}
+	if c.StreamID() != "http://10.0.0.2:5050/api/v1/scheduler" {
+		t.Fatal("The stream ID should come from the leading master")
+	}
+}
+
+// Redirects the HTTP client already followed for us are remembered without sending the call again.
+func TestClient_RedirectFollowed(t *testing.T) {
+	c, calls := fakeClient([]string{"http://10.0.0.1:5050/api/v1/scheduler"}, func(endpoint string) *http.Response {
+		resp := response(http.StatusAccepted, "")
+		resp.Request = &http.Request{URL: &url.URL{Scheme: "http", Host: "10.0.0.3:5050", Path: "/api/v1/scheduler"}}
+		return resp
+	})
+
+	if _, err := c.Request(&mesos_v1_scheduler.Call{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(*calls) != 1 {
+		t.Fatalf("Expected only one call, got %v", *calls)
+	}
+	status := c.Status()
+	if status.Master != "http://10.0.0.3:5050/api/v1/scheduler" || status.State != DISCONNECTED {
+		t.Fatalf("Unexpected status after a followed redirect %+v", status)
+	}
+}
+
+// Every lost subscription moves on to the next master and waits longer, up to the limit.
+func TestClient_Disconnected(t *testing.T) {
+	c, _ := fakeClient([]string{"http://a", "http://b", "http://c"}, func(string) *http.Response {
+		return response(http.StatusServiceUnavailable, "")
+	})
+
+	expected := []struct {
+		wait   time.Duration
+		master string
+	}{
+		{time.Second, "http://b"},
+		{2 * time.Second, "http://c"},
+		{4 * time.Second, "http://a"},
+		{5 * time.Second, "http://b"},
+		{5 * time.Second, "http://c"},
+	}
+	for _, e := range expected {
+		if _, err := c.Request(subscribe()); err != nil {
+			t.Fatal(err.Error())
+		}
+		if state := c.Status().State; state != SUBSCRIBING {
+			t.Fatalf("A failed subscribe should leave us subscribing, got %s", state)
+		}
+		if wait := c.Disconnected(); wait != e.wait {
+			t.Fatalf("Expected to wait %s, got %s", e.wait, wait)
+		}
+		if status := c.Status(); status.Master != e.master || status.State != DISCONNECTED {
+			t.Fatalf("Expected to move on to %s, got %+v", e.master, status)
+		}
+	}
+	if failures := c.Status().Failures; failures != len(expected) {
+		t.Fatalf("Expected %d failures, got %d", len(expected), failures)
+	}
+}
+
+// Subscribing starts the backoff over.
+func TestClient_Subscribed(t *testing.T) {
+	c, _ := fakeClient([]string{"http://a", "http://b"}, func(string) *http.Response {
+		return response(http.StatusOK, "")
+	})
+
+	c.Disconnected()
+	c.Disconnected()
+	if _, err := c.Request(subscribe()); err != nil {
+		t.Fatal(err.Error())
+	}
+	status := c.Status()
+	if status.State != SUBSCRIBED || status.Failures != 0 {
+		t.Fatalf("Unexpected status after subscribing %+v", status)
+	}
+	if wait := c.Disconnected(); wait != time.Second {
+		t.Fatalf("Expected the backoff to start over, got %s", wait)
+	}
+}
+
+// Masters that go quiet for too long have their stream dropped so we subscribe again.
+func TestClient_Watch(t *testing.T) {
+	stream, _ := io.Pipe()
+	c, _ := fakeClient([]string{"http://a"}, func(string) *http.Response {
+		resp := response(http.StatusOK, "")
+		resp.Body = stream
+		return resp
+	})
+
+	resp, err := c.Request(subscribe())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	interval := 0.01
+	c.Received(&mesos_v1_scheduler.Event{
+		Type:       mesos_v1_scheduler.Event_SUBSCRIBED.Enum(),
+		Subscribed: &mesos_v1_scheduler.Event_Subscribed{HeartbeatIntervalSeconds: &interval},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx, 2)
+
+	read := make(chan error)
+	go func() {
+		_, err := resp.Body.Read(make([]byte, 1))
+		read <- err
+	}()
+
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Fatal("Expected the stream to be closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The stream should be dropped after missing two heartbeats")
+	}
+	if stalls := c.Status().Stalls; stalls != 1 {
+		t.Fatalf("Expected the stall to be counted, got %d", stalls)
+	}
+}
+
+// Heartbeats that keep coming keep the stream open.
+func TestClient_WatchHeartbeats(t *testing.T) {
+	c, _ := fakeClient([]string{"http://a"}, func(string) *http.Response {
+		return response(http.StatusOK, "")
+	})
+	if _, err := c.Request(subscribe()); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	interval := 60.0
+	c.Received(&mesos_v1_scheduler.Event{
+		Type:       mesos_v1_scheduler.Event_SUBSCRIBED.Enum(),
+		Subscribed: &mesos_v1_scheduler.Event_Subscribed{HeartbeatIntervalSeconds: &interval},
+	})
+	c.Received(&mesos_v1_scheduler.Event{Type: mesos_v1_scheduler.Event_HEARTBEAT.Enum()})
+	if wait := c.check(3); wait <= 2*time.Minute || wait > 3*time.Minute {
+		t.Fatalf("Expected to check again in just under three heartbeats, got %s", wait)
+	}
+	if stalls := c.Status().Stalls; stalls != 0 {
+		t.Fatalf("Expected no stalls, got %d", stalls)
+	}
+}
+
+// Refreshed credentials are picked up by subscribing to the same master again.
+func TestClient_WatchRenew(t *testing.T) {
+	c, _ := fakeClient([]string{"http://a", "http://b"}, func(string) *http.Response {
+		return response(http.StatusOK, "")
+	})
+	if _, err := c.Request(subscribe()); err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.check(3) != defaultHeartbeat || c.Status().State != SUBSCRIBED {
+		t.Fatal("Nothing should change while our credentials stay the same")
+	}
+
+	c.auth.(*fakeAuth).set("Bearer b")
+	c.check(3)
+	if wait := c.Disconnected(); wait != 0 {
+		t.Fatalf("Expected to subscribe again straight away, got %s", wait)
+	}
+	if status := c.Status(); status.Master != "http://a" || status.Failures != 0 {
+		t.Fatalf("Expected to stay with the same master, got %+v", status)
+	}
+
+	if _, err := c.Request(subscribe()); err != nil {
+		t.Fatal(err.Error())
+	}
+	if header := c.current().(fakeMaster).header; header != "Bearer b" {
+		t.Fatalf("Expected to subscribe with the new credentials, got %s", header)
+	}
+}
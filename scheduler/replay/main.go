@@ -0,0 +1,123 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/verizonlabs/hydrogen/scheduler"
+	"github.com/verizonlabs/hydrogen/scheduler/agent"
+	apiManager "github.com/verizonlabs/hydrogen/scheduler/api/manager"
+	"github.com/verizonlabs/hydrogen/scheduler/api/v1"
+	"github.com/verizonlabs/hydrogen/scheduler/auth"
+	"github.com/verizonlabs/hydrogen/scheduler/consistency"
+	"github.com/verizonlabs/hydrogen/scheduler/events"
+	"github.com/verizonlabs/hydrogen/scheduler/framework"
+	"github.com/verizonlabs/hydrogen/scheduler/ha"
+	"github.com/verizonlabs/hydrogen/scheduler/journal"
+	"github.com/verizonlabs/hydrogen/scheduler/master"
+	"github.com/verizonlabs/hydrogen/task/ipam"
+	"github.com/verizonlabs/hydrogen/task/manager"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/backup"
+	"github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/hydrogen/task/queue"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	resourceManager "github.com/verizonlabs/mesos-framework-sdk/resources/manager"
+	t "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Replays a journal against a scheduler that's wired to a recording scheduler and in-memory storage.
+// Takes the same flags as the scheduler followed by the journal files, oldest first.
+// Every call the scheduler makes to Mesos and to storage is printed instead of being made.
+func main() {
+	logger := logging.NewDefaultLogger()
+
+	config := new(scheduler.Configuration).Initialize()
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [scheduler flags] journal...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	paths := flag.Args()
+	if len(paths) == 1 {
+		// Pick up any rotated files along with the current one.
+		paths = journal.Files(paths[0])
+	}
+	if len(paths) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	entries, err := journal.Load(paths...)
+	if err != nil {
+		logger.Emit(logging.ERROR, "Failed to read the journal: %s", err.Error())
+		os.Exit(1)
+	}
+
+	frameworkInfo := &mesos_v1.FrameworkInfo{
+		User:            &config.Scheduler.User,
+		Name:            &config.Scheduler.Name,
+		FailoverTimeout: &config.Scheduler.Failover,
+		Checkpoint:      &config.Scheduler.Checkpointing,
+		Role:            &config.Scheduler.Role,
+		Hostname:        &config.Scheduler.Hostname,
+		Principal:       &config.Scheduler.Principal,
+	}
+
+	s := journal.NewRecorder(frameworkInfo, os.Stdout)
+	p := persistence.NewPersistence(
+		journal.NewRecordingStore(&test.MockFailingKVStore{Data: make(map[string]string)}, s),
+		"",
+		persistence.RetryPolicy{},
+	)
+
+	taskManager := manager.NewTaskManager(make(map[string]*t.Task), p, logger)
+	q := queue.NewLaunchQueue(config.Scheduler.QueueHistory)
+	a := agent.NewInventory(p, config.Scheduler.BlacklistRefuse, agent.QuarantinePolicy{
+		Threshold: config.Scheduler.QuarantineThreshold,
+		Window:    config.Scheduler.QuarantineWindow,
+		Cooldown:  config.Scheduler.QuarantineCooldown,
+		Apps:      config.Scheduler.QuarantineApps,
+	}, logger)
+	i := ipam.NewPools(p, logger)
+	r := resourceManager.NewDefaultResourceManager()
+	k := consistency.NewChecker(taskManager, p, s, config.Scheduler.ConsistencyTimeout, logger)
+	o := consistency.NewOrphans(s, consistency.OrphanPolicy{}, logger) // Orphans are never killed on their own here.
+	e := ha.NewHA(p, logger, config.Leader)                            // Never elected since nothing here is ever written for real.
+
+	// Never connects to a master since every call goes to the recording scheduler.
+	mc := master.NewClient(strings.Split(config.Scheduler.MesosEndpoint, ","), auth.NewBasic("", ""), 0, 0, logger)
+
+	// Tasks are only reported stopped further on in the journal so a teardown can't wait for them.
+	d := framework.NewTeardown(context.Background(), taskManager, s, q, i, p, 0, logger)
+	m := apiManager.NewApiParser(r, taskManager, s, q, a, i, backup.NewBackup(p, logger), k, o, e, mc, d)
+
+	mux := http.NewServeMux()
+	for path, route := range v1.MapRoutes(v1.NewHandlers(m)) {
+		mux.HandleFunc(path, route.Handler)
+	}
+
+	revives := make(chan *t.Task, 1024)
+	h := events.NewHandler(context.Background(), taskManager, r, config, s, p, revives, q, a, i, k, o, logger)
+
+	journal.Replay(entries, h, mux, revives, os.Stdout)
+}
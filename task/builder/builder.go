@@ -16,6 +16,7 @@ package builder
 
 import (
 	"errors"
+	"github.com/verizonlabs/hydrogen/task/ports"
 	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	resourcebuilder "github.com/verizonlabs/mesos-framework-sdk/resources"
 	"github.com/verizonlabs/mesos-framework-sdk/task"
@@ -33,6 +34,36 @@ import (
 var NoNameError = errors.New("A name is required for the application. Please set the name field.")
 var NoResourcesError = errors.New("Application requested with no resources. Please set some resources.")
 
+// Application JSON along with the fields we support on top of what the SDK parses.
+type ApplicationJSON struct {
+	task.ApplicationJSON
+	Ports   []ports.PortJSON `json:"ports"`
+	IpPools []string         `json:"ippools"`
+}
+
+// Parses 1...n applications along with their ports.  Any error fails all other applications.
+func Applications(apps ...*ApplicationJSON) ([]*manager.Task, error) {
+	tasks := make([]*task.ApplicationJSON, 0, len(apps))
+	for _, app := range apps {
+		tasks = append(tasks, &app.ApplicationJSON)
+	}
+
+	parsedTasks, err := Application(tasks...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, app := range apps {
+		discovery, err := ports.Parse(app.Name, app.Ports)
+		if err != nil {
+			return nil, err
+		}
+		parsedTasks[i].Info.Discovery = discovery
+	}
+
+	return parsedTasks, nil
+}
+
 // Parses a 1...n tasks.  Any error fails all other tasks.
 func Application(tasks ...*task.ApplicationJSON) ([]*manager.Task, error) {
 	parsedTasks := []*manager.Task{}
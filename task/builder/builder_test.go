@@ -15,6 +15,7 @@
 package builder
 
 import (
+	"encoding/json"
 	"github.com/verizonlabs/mesos-framework-sdk/task"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
 	"testing"
@@ -295,3 +296,30 @@ func TestApplicationHealthChecks(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestApplications_Ports(t *testing.T) {
+	app := &ApplicationJSON{}
+	err := json.Unmarshal([]byte(`{
+		"name": "test",
+		"resources": {"cpu": 0.5, "mem": 128.0},
+		"command": {"cmd": "/bin/sleep 1"},
+		"ports": [{"name": "http", "host": 0, "container": 8080}, {"host": 9000, "protocol": "udp"}]
+	}`), app)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tasks, err := Applications(app)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	requested := tasks[0].Info.GetDiscovery().GetPorts().GetPorts()
+	if len(requested) != 2 || requested[1].GetNumber() != 9000 || requested[1].GetProtocol() != "udp" {
+		t.Fatalf("Expected 2 requested ports, got %v", requested)
+	}
+
+	app.Ports = append(app.Ports, app.Ports[1])
+	if _, err := Applications(app); err == nil {
+		t.Fatal("Requesting the same host port twice should fail")
+	}
+}
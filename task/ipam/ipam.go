@@ -0,0 +1,391 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"net"
+	"sort"
+	"sync"
+)
+
+const (
+	// Root directory for IP pools.
+	POOL_DIRECTORY = "/pools/"
+
+	// Network label that tells us which pool a container network gets its address from.
+	POOL_LABEL = "ippool"
+)
+
+type (
+	// A named range of addresses on a CNI network.
+	// Allocations map each address handed out to the task ID holding it.
+	Pool struct {
+		Name        string            `json:"name"`
+		Network     string            `json:"network"`
+		CIDR        string            `json:"cidr"`
+		Allocations map[string]string `json:"allocations,omitempty"`
+	}
+
+	// Manages every IP pool and makes sure no address is handed out twice.
+	Pools struct {
+		mutex   sync.Mutex
+		pools   map[string]*Pool
+		storage persistence.Storage
+		logger  logging.Logger
+	}
+)
+
+// Returns a new, empty set of IP pools.
+func NewPools(s persistence.Storage, l logging.Logger) *Pools {
+	return &Pools{
+		pools:   make(map[string]*Pool),
+		storage: s,
+		logger:  l,
+	}
+}
+
+// Loads every pool and its allocations from storage, replacing whatever is currently in memory.
+func (p *Pools) Restore(ctx context.Context) error {
+	var stored map[string]string
+	err := p.storage.Retry(ctx, func() error {
+		s, err := p.storage.ReadAll(POOL_DIRECTORY)
+		if err != nil {
+			p.logger.Emit(logging.ERROR, "Failed to read IP pools: %s", err.Error())
+			return err
+		}
+
+		stored = s
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pools := make(map[string]*Pool, len(stored))
+	for _, value := range stored {
+		pool := new(Pool)
+		if err := json.Unmarshal([]byte(value), pool); err != nil {
+			return err
+		}
+		if pool.Allocations == nil {
+			pool.Allocations = make(map[string]string)
+		}
+		pools[pool.Name] = pool
+	}
+
+	p.mutex.Lock()
+	p.pools = pools
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// Validates and persists a new pool.
+func (p *Pools) Create(ctx context.Context, pool Pool) error {
+	if pool.Name == "" || pool.Network == "" {
+		return errors.New("Pool name and network are required")
+	}
+	if _, _, err := net.ParseCIDR(pool.CIDR); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.pools[pool.Name]; ok {
+		return errors.New("Pool " + pool.Name + " already exists")
+	}
+
+	pool.Allocations = make(map[string]string)
+	if err := p.write(ctx, &pool); err != nil {
+		return err
+	}
+	p.pools[pool.Name] = &pool
+
+	p.logger.Emit(logging.INFO, "Created IP pool %s for network %s with %s", pool.Name, pool.Network, pool.CIDR)
+	return nil
+}
+
+// Removes a pool, as long as nothing is still using addresses from it.
+func (p *Pools) Delete(ctx context.Context, name string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pool, ok := p.pools[name]
+	if !ok {
+		return errors.New("Pool " + name + " does not exist")
+	}
+	if len(pool.Allocations) > 0 {
+		return errors.New("Pool " + name + " still has addresses in use")
+	}
+
+	err := p.storage.Retry(ctx, func() error {
+		err := p.storage.Delete(POOL_DIRECTORY + name)
+		if err != nil {
+			p.logger.Emit(logging.ERROR, "Failed to delete IP pool %s: %s", name, err.Error())
+		}
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	delete(p.pools, name)
+
+	p.logger.Emit(logging.INFO, "Deleted IP pool %s", name)
+	return nil
+}
+
+// Gets a copy of every pool, sorted by name.
+func (p *Pools) All() []Pool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pools := make([]Pool, 0, len(p.pools))
+	for _, pool := range p.pools {
+		c := *pool
+		c.Allocations = make(map[string]string, len(pool.Allocations))
+		for ip, id := range pool.Allocations {
+			c.Allocations[ip] = id
+		}
+		pools = append(pools, c)
+	}
+	sort.Sort(byName(pools))
+
+	return pools
+}
+
+//
+// Attach marks the task as wanting an address from each of the named pools.
+// A network is added to the task's container for every pool, labeled with the pool it gets its address from.
+// No addresses are handed out until the task is launched.
+//
+func (p *Pools) Attach(info *mesos_v1.TaskInfo, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	container := &mesos_v1.ContainerInfo{Type: mesos_v1.ContainerInfo_MESOS.Enum()}
+	if info.Container != nil {
+		c := *info.Container
+		container = &c
+	}
+
+	networks := append([]*mesos_v1.NetworkInfo{}, container.GetNetworkInfos()...)
+	for _, name := range names {
+		pool, ok := p.pools[name]
+		if !ok {
+			return errors.New("Pool " + name + " does not exist")
+		}
+
+		networks = append(networks, &mesos_v1.NetworkInfo{
+			Name: utils.ProtoString(pool.Network),
+			Labels: &mesos_v1.Labels{Labels: []*mesos_v1.Label{
+				{Key: utils.ProtoString(POOL_LABEL), Value: utils.ProtoString(name)},
+			}},
+		})
+	}
+	container.NetworkInfos = networks
+	info.Container = container
+
+	return nil
+}
+
+//
+// Allocate gives the task an address on every network that's backed by a pool.
+// A task that already holds an address in a pool keeps it, so relaunching is safe.
+// If any pool has run out of addresses, whatever was handed out during this call is given back.
+//
+func (p *Pools) Allocate(ctx context.Context, info *mesos_v1.TaskInfo) error {
+	id := info.GetTaskId().GetValue()
+	if !pooled(info) {
+		return nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	container := *info.Container
+	container.NetworkInfos = []*mesos_v1.NetworkInfo{}
+	allocated := map[*Pool]string{}
+	for _, n := range info.Container.GetNetworkInfos() {
+		network := *n
+		container.NetworkInfos = append(container.NetworkInfos, &network)
+
+		name := poolName(n)
+		if name == "" {
+			continue
+		}
+
+		pool, ok := p.pools[name]
+		if !ok {
+			p.rollback(ctx, allocated)
+			return errors.New("Pool " + name + " does not exist")
+		}
+
+		ip, fresh, err := pool.allocate(id)
+		if err != nil {
+			p.rollback(ctx, allocated)
+			return err
+		}
+		if fresh {
+			if err := p.write(ctx, pool); err != nil {
+				delete(pool.Allocations, ip)
+				p.rollback(ctx, allocated)
+				return err
+			}
+			allocated[pool] = ip
+			p.logger.Emit(logging.INFO, "Allocated %s from IP pool %s to task %s", ip, name, id)
+		}
+
+		protocol := mesos_v1.NetworkInfo_IPv4
+		if net.ParseIP(ip).To4() == nil {
+			protocol = mesos_v1.NetworkInfo_IPv6
+		}
+		network.IpAddresses = []*mesos_v1.NetworkInfo_IPAddress{
+			{IpAddress: utils.ProtoString(ip), Protocol: protocol.Enum()},
+		}
+	}
+	info.Container = &container
+
+	return nil
+}
+
+// Release gives back every address held by the task.
+func (p *Pools) Release(ctx context.Context, taskID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, pool := range p.pools {
+		for ip, id := range pool.Allocations {
+			if id != taskID {
+				continue
+			}
+
+			delete(pool.Allocations, ip)
+			if err := p.write(ctx, pool); err != nil {
+				// Keep the address reserved in memory so we don't hand out something storage still thinks is taken.
+				pool.Allocations[ip] = id
+				continue
+			}
+			p.logger.Emit(logging.INFO, "Released %s from IP pool %s held by task %s", ip, pool.Name, taskID)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (p *Pools) rollback(ctx context.Context, allocated map[*Pool]string) {
+	for pool, ip := range allocated {
+		delete(pool.Allocations, ip)
+		p.write(ctx, pool)
+	}
+}
+
+// Must be called with the lock held.
+func (p *Pools) write(ctx context.Context, pool *Pool) error {
+	data, err := json.Marshal(pool)
+	if err != nil {
+		return err
+	}
+
+	return p.storage.Retry(ctx, func() error {
+		err := p.storage.Update(POOL_DIRECTORY+pool.Name, string(data))
+		if err != nil {
+			p.logger.Emit(logging.ERROR, "Failed to save IP pool %s: %s", pool.Name, err.Error())
+		}
+
+		return err
+	})
+}
+
+// Finds the address the task already holds or the first free one.
+// Reports whether the address was newly handed out.
+func (pool *Pool) allocate(taskID string) (string, bool, error) {
+	for ip, id := range pool.Allocations {
+		if id == taskID {
+			return ip, false, nil
+		}
+	}
+
+	ip, ipNet, err := net.ParseCIDR(pool.CIDR)
+	if err != nil {
+		return "", false, err
+	}
+
+	first := ip.Mask(ipNet.Mask)
+	for addr := next(first); ipNet.Contains(addr); addr = next(addr) {
+		// Skip the IPv4 broadcast address.
+		if addr.To4() != nil && !ipNet.Contains(next(addr)) {
+			break
+		}
+
+		if _, ok := pool.Allocations[addr.String()]; !ok {
+			pool.Allocations[addr.String()] = taskID
+			return addr.String(), true, nil
+		}
+	}
+
+	return "", false, errors.New("Pool " + pool.Name + " has no free addresses")
+}
+
+// Returns the address after the given one.
+func next(ip net.IP) net.IP {
+	n := make(net.IP, len(ip))
+	copy(n, ip)
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			break
+		}
+	}
+
+	return n
+}
+
+func pooled(info *mesos_v1.TaskInfo) bool {
+	for _, n := range info.GetContainer().GetNetworkInfos() {
+		if poolName(n) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func poolName(n *mesos_v1.NetworkInfo) string {
+	for _, l := range n.GetLabels().GetLabels() {
+		if l.GetKey() == POOL_LABEL {
+			return l.GetValue()
+		}
+	}
+
+	return ""
+}
+
+type byName []Pool
+
+func (b byName) Len() int           { return len(b) }
+func (b byName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byName) Less(i, j int) bool { return b[i].Name < b[j].Name }
@@ -0,0 +1,127 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"context"
+	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"testing"
+)
+
+func poolsFixture(t *testing.T, cidr string) *Pools {
+	p := NewPools(&mockStorage.MockStorage{}, &mockLogger.MockLogger{})
+	if err := p.Create(context.Background(), Pool{Name: "test", Network: "cni", CIDR: cidr}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return p
+}
+
+func taskInfo(t *testing.T, p *Pools, id string) *mesos_v1.TaskInfo {
+	info := &mesos_v1.TaskInfo{TaskId: &mesos_v1.TaskID{Value: utils.ProtoString(id)}}
+	if err := p.Attach(info, []string{"test"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return info
+}
+
+func address(info *mesos_v1.TaskInfo) string {
+	return info.GetContainer().GetNetworkInfos()[0].GetIpAddresses()[0].GetIpAddress()
+}
+
+func TestPools_Create(t *testing.T) {
+	p := poolsFixture(t, "10.0.0.0/30")
+	if err := p.Create(context.Background(), Pool{Name: "test", Network: "cni", CIDR: "10.0.1.0/30"}); err == nil {
+		t.Fatal("Creating a pool twice should fail")
+	}
+	if err := p.Create(context.Background(), Pool{Name: "bad", Network: "cni", CIDR: "not a cidr"}); err == nil {
+		t.Fatal("Creating a pool with an invalid CIDR should fail")
+	}
+	if err := p.Create(context.Background(), Pool{Name: "nonetwork", CIDR: "10.0.1.0/30"}); err == nil {
+		t.Fatal("Creating a pool without a network should fail")
+	}
+	if err := p.Attach(&mesos_v1.TaskInfo{}, []string{"missing"}); err == nil {
+		t.Fatal("Attaching a pool that doesn't exist should fail")
+	}
+}
+
+// Every instance should get its own address until the pool runs dry.
+func TestPools_Allocate(t *testing.T) {
+	p := poolsFixture(t, "10.0.0.0/30")
+
+	first := taskInfo(t, p, "a")
+	second := taskInfo(t, p, "b")
+	if err := p.Allocate(context.Background(), first); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := p.Allocate(context.Background(), second); err != nil {
+		t.Fatal(err.Error())
+	}
+	if address(first) != "10.0.0.1" || address(second) != "10.0.0.2" {
+		t.Fatalf("Expected unique addresses, got %s and %s", address(first), address(second))
+	}
+
+	// Relaunching keeps the same address.
+	if err := p.Allocate(context.Background(), first); err != nil || address(first) != "10.0.0.1" {
+		t.Fatal("Task should keep its address when launched again")
+	}
+
+	// Network and broadcast addresses are never handed out.
+	if err := p.Allocate(context.Background(), taskInfo(t, p, "c")); err == nil {
+		t.Fatal("Pool should be out of addresses")
+	}
+
+	if err := p.Delete(context.Background(), "test"); err == nil {
+		t.Fatal("Deleting a pool with addresses in use should fail")
+	}
+
+	p.Release(context.Background(), "a")
+	third := taskInfo(t, p, "c")
+	if err := p.Allocate(context.Background(), third); err != nil || address(third) != "10.0.0.1" {
+		t.Fatal("Released addresses should be handed out again")
+	}
+}
+
+func TestPools_AllocateIPv6(t *testing.T) {
+	p := poolsFixture(t, "2600::/126")
+	info := taskInfo(t, p, "a")
+	if err := p.Allocate(context.Background(), info); err != nil {
+		t.Fatal(err.Error())
+	}
+	ip := info.GetContainer().GetNetworkInfos()[0].GetIpAddresses()[0]
+	if ip.GetIpAddress() != "2600::1" || ip.GetProtocol() != mesos_v1.NetworkInfo_IPv6 {
+		t.Fatalf("Expected an IPv6 address, got %v", ip)
+	}
+}
+
+// Storage failures shouldn't leave addresses reserved in memory.
+func TestPools_StorageFailure(t *testing.T) {
+	p := NewPools(&mockStorage.MockBrokenStorage{}, &mockLogger.MockLogger{})
+	if err := p.Create(context.Background(), Pool{Name: "test", Network: "cni", CIDR: "10.0.0.0/30"}); err == nil {
+		t.Fatal("Creating a pool should fail when storage is broken")
+	}
+
+	p.pools["test"] = &Pool{Name: "test", Network: "cni", CIDR: "10.0.0.0/30", Allocations: map[string]string{}}
+	if err := p.Allocate(context.Background(), taskInfo(t, p, "a")); err == nil {
+		t.Fatal("Allocating should fail when storage is broken")
+	}
+	if len(p.pools["test"].Allocations) != 0 {
+		t.Fatal("Failed allocations should be given back")
+	}
+}
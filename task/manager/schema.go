@@ -0,0 +1,57 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	sdkPersistence "github.com/verizonlabs/mesos-framework-sdk/persistence"
+	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
+)
+
+func init() {
+	schema.Register(schema.Migration{
+		Version:     1,
+		Description: "Wrap task records in a versioned envelope",
+		Migrate:     wrapTasks,
+	})
+}
+
+// Wraps every task that was stored before we had envelopes.
+// Each task is decoded first so a record we can't read stops the migration instead of being carried forward.
+func wrapTasks(kv sdkPersistence.KeyValueStore) error {
+	tasks, err := kv.ReadAll(TASK_DIRECTORY)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range tasks {
+		data, version, err := schema.Unwrap(value)
+		if err != nil {
+			return err
+		}
+		if version > 0 {
+			continue
+		}
+
+		if _, err := new(manager.Task).Decode(data); err != nil {
+			return err
+		}
+		if err := kv.Update(key, schema.Wrap(data)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
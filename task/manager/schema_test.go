@@ -0,0 +1,50 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package manager
+
+import (
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	"github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"testing"
+)
+
+func TestWrapTasks(t *testing.T) {
+	task := &manager.Task{Info: &mesos_v1.TaskInfo{
+		Name:   utils.ProtoString("test"),
+		TaskId: &mesos_v1.TaskID{Value: utils.ProtoString("1")},
+	}}
+	data, err := task.Encode()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	kv := &test.MockFailingKVStore{Data: map[string]string{
+		TASK_DIRECTORY + "1": string(data),
+		TASK_DIRECTORY + "2": schema.Wrap(data),
+	}}
+	if err := wrapTasks(kv); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for key, value := range kv.Data {
+		unwrapped, version, err := schema.Unwrap(value)
+		if err != nil || version != schema.VERSION || string(unwrapped) != string(data) {
+			t.Fatalf("Expected %s to be wrapped exactly once, got %q", key, value)
+		}
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
 	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
 	"strconv"
 	"strings"
 	"sync"
@@ -33,6 +34,11 @@ const (
 )
 
 type (
+	// Task managers that can drop a task from memory while leaving it in storage.
+	Forgetful interface {
+		Forget(name string)
+	}
+
 	// Our primary task handler that implements the above interface.
 	// The task handler manages all tasks that are submitted, updated, or deleted.
 	// Offers from Mesos are matched up with user-submitted tasks, and those tasks are updated via event callbacks.
@@ -65,10 +71,35 @@ func NewTaskManager(
 
 // Add and persists a new task into the task manager.
 // Duplicate task names are not allowed by Mesos, thus they are not allowed here.
+// Every instance of every task is written in a single storage transaction,
+// and tasks are only added to memory once that succeeds, so either all of them are added or none are.
 func (m *TaskHandler) Add(tasks ...*manager.Task) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	staged := []*manager.Task{}
+	ops := []persistence.Operation{}
+	stage := func(t *manager.Task) error {
+		name := t.Info.GetName()
+		if _, ok := m.tasks[name]; ok {
+			return errors.New("Task " + name + " already exists")
+		}
+		for _, s := range staged {
+			if s.Info.GetName() == name {
+				return errors.New("Task " + name + " already exists")
+			}
+		}
+
+		data, err := t.Encode()
+		if err != nil {
+			return err
+		}
+
+		staged = append(staged, t)
+		ops = append(ops, persistence.Operation{Type: persistence.PUT, Key: m.storageKey(t), Value: schema.Wrap(data)})
+		return nil
+	}
+
 	for _, t := range tasks {
 		t.State = manager.UNKNOWN
 
@@ -77,21 +108,9 @@ func (m *TaskHandler) Add(tasks ...*manager.Task) error {
 
 		// If we have a single instance, only add it.
 		if t.Instances == 1 {
-			if _, ok := m.tasks[t.Info.GetName()]; ok {
-				return errors.New("Task " + t.Info.GetName() + " already exists")
-			}
-			// Write forward.
-			data, err := t.Encode()
-			if err != nil {
-				return err
-			}
-
-			err = m.storageWrite(t, data)
-			if err != nil {
-				m.logger.Emit(logging.ERROR, "Storage error: %v", err)
+			if err := stage(t); err != nil {
 				return err
 			}
-			m.tasks[t.Info.GetName()] = t
 			continue
 		}
 
@@ -105,32 +124,42 @@ func (m *TaskHandler) Add(tasks ...*manager.Task) error {
 			duplicate.Info = &tmp
 			duplicate.Info.Name = utils.ProtoString(originalName + "-" + strconv.Itoa(i+1))
 			duplicate.Info.TaskId = &mesos_v1.TaskID{Value: utils.ProtoString(taskId + "-" + strconv.Itoa(i+1))}
-			if _, ok := m.tasks[duplicate.Info.GetName()]; ok {
-				return errors.New("Task " + duplicate.Info.GetName() + " already exists")
-			}
-
-			// Write forward.
-			data, err := duplicate.Encode()
-			if err != nil {
+			if err := stage(&duplicate); err != nil {
 				return err
 			}
-
-			err = m.storageWrite(&duplicate, data)
-			if err != nil {
-				m.logger.Emit(logging.ERROR, "Storage error: %v", err)
-				return err
-			}
-			m.tasks[duplicate.Info.GetName()] = &duplicate
 		}
 	}
 
+	// Write forward.
+	err := m.storage.Transaction(ops...)
+	if err != nil {
+		m.logger.Emit(logging.ERROR, "Storage error, no tasks were added: %v", err)
+		return err
+	}
+
+	for _, t := range staged {
+		m.tasks[t.Info.GetName()] = t
+	}
+
 	return nil
 }
 
 func (m *TaskHandler) Restore(task *manager.Task) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
 	m.tasks[task.Info.GetName()] = task
 }
 
+// Drops a task from memory without touching storage.
+// Used to follow tasks that someone else deleted from storage.
+func (m *TaskHandler) Forget(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.tasks, name)
+}
+
 // Delete a task from memory and etcd, and clears any associated policy.
 func (m *TaskHandler) Delete(tasks ...*manager.Task) error {
 	m.mutex.Lock()
@@ -270,15 +299,9 @@ func (m *TaskHandler) All() ([]*manager.Task, error) {
 
 // Function that wraps writing to the storage backend.
 func (m *TaskHandler) storageWrite(task *manager.Task, encoded []byte) error {
-	var writeKey string
 	var id string = task.Info.GetTaskId().GetValue()
 	var name string = task.Info.GetName()
-	if task.GroupInfo.InGroup {
-		writeKey = TASK_DIRECTORY + task.GroupInfo.GroupName + id
-	} else {
-		writeKey = TASK_DIRECTORY + id
-	}
-	err := m.storage.Update(writeKey, string(encoded))
+	err := m.storage.Update(m.storageKey(task), schema.Wrap(encoded))
 	if err != nil {
 		m.logger.Emit(
 			logging.ERROR, "Failed to update task %s with name %s to persistent data store. Retrying...",
@@ -291,16 +314,19 @@ func (m *TaskHandler) storageWrite(task *manager.Task, encoded []byte) error {
 
 // Function that wraps deleting from the storage backend.
 func (m *TaskHandler) storageDelete(task *manager.Task) error {
-	var del string
-	var id string = task.Info.GetTaskId().GetValue()
-	if task.GroupInfo.InGroup {
-		del = TASK_DIRECTORY + task.GroupInfo.GroupName + id
-	} else {
-		del = TASK_DIRECTORY + id
-	}
-	err := m.storage.Delete(del)
+	err := m.storage.Delete(m.storageKey(task))
 	if err != nil {
 		m.logger.Emit(logging.ERROR, err.Error())
 	}
 	return err
 }
+
+// Tasks in a group are stored under their group's directory.
+func (m *TaskHandler) storageKey(task *manager.Task) string {
+	id := task.Info.GetTaskId().GetValue()
+	if task.GroupInfo.InGroup {
+		return TASK_DIRECTORY + task.GroupInfo.GroupName + id
+	}
+
+	return TASK_DIRECTORY + id
+}
@@ -19,6 +19,7 @@ import (
 	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
 	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
 	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"github.com/verizonlabs/hydrogen/task/persistence"
 	mockStorage "github.com/verizonlabs/hydrogen/task/persistence/test"
 	"strconv"
 	"testing"
@@ -460,3 +461,75 @@ func BenchmarkTaskHandler_Update(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+// Adding a group should be all or nothing, no matter which instance fails to persist.
+func TestTaskManager_AddGroupAtomic(t *testing.T) {
+	logger := new(mockLogger.MockLogger)
+
+	for failOn := 1; failOn <= 5; failOn++ {
+		kv := &mockStorage.MockFailingKVStore{FailOn: failOn}
+		taskManager := NewTaskManager(make(map[string]*manager.Task), persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), logger)
+
+		err := taskManager.Add(&manager.Task{Info: CreateTestTask("group"), Instances: 5})
+		if err == nil {
+			t.Fatalf("Add should fail when instance %d fails to persist", failOn)
+		}
+		if taskManager.TotalTasks() != 0 {
+			t.Fatalf("No instances should be in memory when instance %d fails, found %d", failOn, taskManager.TotalTasks())
+		}
+		if len(kv.Data) != 0 {
+			t.Fatalf("No instances should be persisted when instance %d fails, found %v", failOn, kv.Data)
+		}
+	}
+
+	kv := &mockStorage.MockFailingKVStore{}
+	taskManager := NewTaskManager(make(map[string]*manager.Task), persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), logger)
+	if err := taskManager.Add(&manager.Task{Info: CreateTestTask("group"), Instances: 5}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if taskManager.TotalTasks() != 5 || len(kv.Data) != 5 {
+		t.Fatalf("Expected 5 instances in memory and storage, got %d and %d", taskManager.TotalTasks(), len(kv.Data))
+	}
+}
+
+// Duplicates in the same request or against existing tasks should fail before anything is written.
+func TestTaskManager_AddDuplicates(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{}
+	taskManager := NewTaskManager(make(map[string]*manager.Task), persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), new(mockLogger.MockLogger))
+
+	err := taskManager.Add(
+		&manager.Task{Info: CreateTestTask("first"), Instances: 1},
+		&manager.Task{Info: CreateTestTask("first"), Instances: 1},
+	)
+	if err == nil || taskManager.TotalTasks() != 0 || len(kv.Data) != 0 {
+		t.Fatal("Duplicate tasks in the same request should not be added")
+	}
+
+	if err := taskManager.Add(&manager.Task{Info: CreateTestTask("first"), Instances: 1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	err = taskManager.Add(
+		&manager.Task{Info: CreateTestTask("second"), Instances: 1},
+		&manager.Task{Info: CreateTestTask("first"), Instances: 1},
+	)
+	if err == nil || taskManager.TotalTasks() != 1 {
+		t.Fatal("A request containing an existing task should not add anything")
+	}
+}
+
+// Forgetting a task only drops it from memory.
+func TestTaskManager_Forget(t *testing.T) {
+	kv := &mockStorage.MockFailingKVStore{}
+	taskManager := NewTaskManager(make(map[string]*manager.Task), persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), new(mockLogger.MockLogger))
+	if err := taskManager.Add(&manager.Task{Info: CreateTestTask("task"), Instances: 1}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	taskManager.(Forgetful).Forget("task")
+	if taskManager.TotalTasks() != 0 {
+		t.Fatalf("Expected the task to be forgotten, got %d tasks", taskManager.TotalTasks())
+	}
+	if len(kv.Data) != 1 {
+		t.Fatalf("Forgetting shouldn't touch storage, got %v", kv.Data)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// Package backup snapshots everything the scheduler keeps in storage into a single archive and restores it.
+//
+package backup
+
+import (
+	"context"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/manager"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	sdkTaskManager "github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Archive format version.
+	VERSION = 1
+
+	// The leader is rewritten by every election so it's never backed up.
+	leaderKey      = "/leader"
+	frameworkIDKey = "/frameworkId"
+)
+
+type (
+	//
+	// Archive holds everything that was in storage when the backup was taken.
+	// Tasks are keyed by where they're stored, which also keeps them in their groups.
+	// Everything else, like the blacklist, IP pools, and schema version, is kept as is in Keys.
+	//
+	Archive struct {
+		Version     int               `json:"version"`
+		Created     time.Time         `json:"created"`
+		Schema      int               `json:"schema"`
+		FrameworkID string            `json:"frameworkId,omitempty"`
+		Tasks       map[string]string `json:"tasks"`
+		Keys        map[string]string `json:"keys"`
+	}
+
+	// Takes and restores backups of our storage.
+	Backup struct {
+		storage persistence.Storage
+		logger  logging.Logger
+	}
+)
+
+// Returns a new backup manager for the given storage.
+func NewBackup(s persistence.Storage, l logging.Logger) *Backup {
+	return &Backup{
+		storage: s,
+		logger:  l,
+	}
+}
+
+// Export reads every key we own in a single read so the archive is a consistent snapshot.
+func (b *Backup) Export(ctx context.Context) (*Archive, error) {
+	var all map[string]string
+	err := b.storage.Retry(ctx, func() error {
+		a, err := b.storage.ReadAll("/")
+		if err != nil {
+			b.logger.Emit(logging.ERROR, "Failed to read storage for a backup: %s", err.Error())
+			return err
+		}
+
+		all = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		Version: VERSION,
+		Created: time.Now().UTC(),
+		Tasks:   make(map[string]string),
+		Keys:    make(map[string]string),
+	}
+	for key, value := range all {
+		switch {
+		case isLeader(key):
+		case key == frameworkIDKey:
+			archive.FrameworkID = value
+		case strings.HasPrefix(key, manager.TASK_DIRECTORY):
+			archive.Tasks[key] = value
+		default:
+			archive.Keys[key] = value
+		}
+	}
+
+	if v, ok := archive.Keys[schema.VERSION_KEY]; ok {
+		archive.Schema, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.New("Invalid schema version " + v + " in storage")
+		}
+	}
+
+	b.logger.Emit(logging.INFO, "Backed up %d tasks and %d other keys", len(archive.Tasks), len(archive.Keys))
+	return archive, nil
+}
+
+//
+// Validate makes sure an archive can be restored by this scheduler.
+// Every task has to decode, so a bad archive is rejected before anything is written.
+//
+func Validate(a *Archive) error {
+	if a.Version != VERSION {
+		return errors.New("Unsupported backup version " + strconv.Itoa(a.Version))
+	}
+	if a.Schema > schema.VERSION {
+		return errors.New("Backup has schema version " + strconv.Itoa(a.Schema) +
+			" which is newer than this scheduler's version " + strconv.Itoa(schema.VERSION))
+	}
+
+	for key, value := range a.Tasks {
+		if !strings.HasPrefix(key, manager.TASK_DIRECTORY) {
+			return errors.New("Task " + key + " is not stored under " + manager.TASK_DIRECTORY)
+		}
+
+		data, _, err := schema.Unwrap(value)
+		if err != nil {
+			return errors.New("Task " + key + ": " + err.Error())
+		}
+		if _, err := new(sdkTaskManager.Task).Decode(data); err != nil {
+			return errors.New("Task " + key + " can't be decoded: " + err.Error())
+		}
+	}
+	for key := range a.Keys {
+		if isLeader(key) || key == frameworkIDKey || strings.HasPrefix(key, manager.TASK_DIRECTORY) {
+			return errors.New("Key " + key + " doesn't belong with the other keys")
+		}
+	}
+
+	return nil
+}
+
+//
+// Restore writes an archive into empty storage.
+// It's validated first and then every key is written in one transaction, so storage ends up with all of it or none of it.
+// The framework ID gets a fresh lease of the given failover timeout, the same as when we subscribe.
+//
+func (b *Backup) Restore(ctx context.Context, a *Archive, failover float64) error {
+	if err := Validate(a); err != nil {
+		return err
+	}
+
+	var existing map[string]string
+	err := b.storage.Retry(ctx, func() error {
+		e, err := b.storage.ReadAll("/")
+		existing = e
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for key := range existing {
+		if !isLeader(key) {
+			return errors.New("Refusing to restore over existing state, found " + key)
+		}
+	}
+
+	ops := make([]persistence.Operation, 0, len(a.Tasks)+len(a.Keys))
+	for key, value := range a.Tasks {
+		ops = append(ops, persistence.Operation{Type: persistence.PUT, Key: key, Value: value})
+	}
+	for key, value := range a.Keys {
+		ops = append(ops, persistence.Operation{Type: persistence.PUT, Key: key, Value: value})
+	}
+	err = b.storage.Retry(ctx, func() error {
+		return b.storage.Transaction(ops...)
+	})
+	if err != nil {
+		b.logger.Emit(logging.ERROR, "Failed to restore backup: %s", err.Error())
+		return err
+	}
+
+	if a.FrameworkID != "" {
+		err := b.storage.Retry(ctx, func() error {
+			if failover <= 0 {
+				return b.storage.Update(frameworkIDKey, a.FrameworkID)
+			}
+
+			_, err := b.storage.CreateWithLease(frameworkIDKey, a.FrameworkID, int64(failover))
+			return err
+		})
+		if err != nil {
+			b.logger.Emit(logging.ERROR, "Failed to restore the framework ID: %s", err.Error())
+			return err
+		}
+	}
+
+	b.logger.Emit(logging.INFO, "Restored %d tasks and %d other keys from a backup taken at %s",
+		len(a.Tasks), len(a.Keys), a.Created.Format(time.RFC3339))
+	return nil
+}
+
+// Leader election keeps the leader record along with its terms and leases under the leader key.
+// None of it means anything outside the cluster that wrote it.
+func isLeader(key string) bool {
+	return key == leaderKey || strings.HasPrefix(key, leaderKey+"/")
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/schema"
+	"github.com/verizonlabs/hydrogen/task/persistence/test"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"strings"
+	"testing"
+)
+
+func record(t *testing.T, name string) string {
+	task := &manager.Task{Info: &mesos_v1.TaskInfo{
+		Name:   utils.ProtoString(name),
+		TaskId: &mesos_v1.TaskID{Value: utils.ProtoString(name)},
+	}}
+	data, err := task.Encode()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return schema.Wrap(data)
+}
+
+func backupFixture(data map[string]string) (*Backup, *test.MockFailingKVStore) {
+	kv := &test.MockFailingKVStore{Data: data}
+	return NewBackup(persistence.NewPersistence(kv, "hydrogen", persistence.RetryPolicy{}), new(mockLogger.MockLogger)), kv
+}
+
+// Restoring a backup into empty storage has to give back exactly what was backed up.
+func TestBackup_RoundTrip(t *testing.T) {
+	source, sourceKV := backupFixture(map[string]string{
+		"/hydrogen/tasks/a":         record(t, "a"),
+		"/hydrogen/tasks/group/b":   record(t, "b"),
+		"/hydrogen/blacklist/host":  `{"hostname": "host"}`,
+		"/hydrogen/schema/version":  "1",
+		"/hydrogen/frameworkId":     "id",
+		"/hydrogen/leader":          `{"id": "a", "ip": "1.2.3.4", "token": 1}`,
+		"/hydrogen/leader/leases/a": "1.2.3.4",
+		"/other/tasks/not-ours":     record(t, "c"),
+	})
+
+	archive, err := source.Export(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(archive.Tasks) != 2 || archive.FrameworkID != "id" || archive.Schema != 1 || len(archive.Keys) != 2 {
+		t.Fatalf("Unexpected archive: %+v", archive)
+	}
+
+	// Go through JSON the same way the API and restore mode do.
+	data, err := json.Marshal(archive)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	decoded := new(Archive)
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	target, targetKV := backupFixture(map[string]string{})
+	if err := target.Restore(context.Background(), decoded, 0); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for key, value := range sourceKV.Data {
+		if strings.HasPrefix(key, "/hydrogen/leader") || key == "/other/tasks/not-ours" {
+			continue
+		}
+		if targetKV.Data[key] != value {
+			t.Fatalf("Expected %s to be restored as %q, got %q", key, value, targetKV.Data[key])
+		}
+	}
+	if len(targetKV.Data) != len(sourceKV.Data)-3 {
+		t.Fatalf("Only our keys should be restored, got %v", targetKV.Data)
+	}
+}
+
+func TestBackup_RestoreNotEmpty(t *testing.T) {
+	b, kv := backupFixture(map[string]string{"/hydrogen/tasks/a": record(t, "a"), "/hydrogen/leader": "1.2.3.4"})
+	archive := &Archive{Version: VERSION, Tasks: map[string]string{"/tasks/b": record(t, "b")}}
+
+	if err := b.Restore(context.Background(), archive, 0); err == nil {
+		t.Fatal("Restoring over existing state should fail")
+	}
+	if _, ok := kv.Data["/hydrogen/tasks/b"]; ok {
+		t.Fatal("Nothing should be written when restoring over existing state")
+	}
+}
+
+func TestBackup_RestoreInvalid(t *testing.T) {
+	invalid := []*Archive{
+		{Version: VERSION + 1},
+		{Version: VERSION, Schema: schema.VERSION + 1},
+		{Version: VERSION, Tasks: map[string]string{"/tasks/a": "not a task"}},
+		{Version: VERSION, Tasks: map[string]string{"/elsewhere/a": record(t, "a")}},
+		{Version: VERSION, Keys: map[string]string{"/leader": "1.2.3.4"}},
+		{Version: VERSION, Keys: map[string]string{"/leader/terms/00000000000000000001": "a"}},
+	}
+
+	for _, archive := range invalid {
+		b, kv := backupFixture(map[string]string{})
+		if err := b.Restore(context.Background(), archive, 0); err == nil {
+			t.Fatalf("Expected %+v to be rejected", archive)
+		}
+		if len(kv.Data) != 0 {
+			t.Fatalf("Nothing should be written from an invalid archive, got %v", kv.Data)
+		}
+	}
+}
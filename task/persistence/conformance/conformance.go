@@ -0,0 +1,161 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// Package conformance holds the behaviour every storage driver has to share.
+// Drivers run the suite from their own tests so the scheduler can treat them interchangeably.
+//
+package conformance
+
+import (
+	"github.com/verizonlabs/mesos-framework-sdk/persistence"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Longest we wait for a lease to expire.
+// Some stores round short TTLs up so this is well past the TTL we ask for.
+const expiryTimeout = 10 * time.Second
+
+//
+// Run checks that the store behaves like every other driver.
+// All keys are written under a prefix that's unique to this run so a shared store like etcd can be used.
+//
+func Run(t *testing.T, kv persistence.KeyValueStore) {
+	prefix := "/conformance/" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	t.Run("CreateAndRead", func(t *testing.T) { createAndRead(t, kv, prefix) })
+	t.Run("ReadMissing", func(t *testing.T) { readMissing(t, kv, prefix) })
+	t.Run("Update", func(t *testing.T) { update(t, kv, prefix) })
+	t.Run("ReadAll", func(t *testing.T) { readAll(t, kv, prefix) })
+	t.Run("Delete", func(t *testing.T) { deleteKey(t, kv, prefix) })
+	t.Run("Lease", func(t *testing.T) { leases(t, kv, prefix) })
+}
+
+func createAndRead(t *testing.T, kv persistence.KeyValueStore, prefix string) {
+	key := prefix + "/create"
+	if err := kv.Create(key, "first"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expect(t, kv, key, "first")
+
+	// Creating an existing key must not overwrite it, leader election depends on this.
+	if err := kv.Create(key, "second"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expect(t, kv, key, "first")
+}
+
+func readMissing(t *testing.T, kv persistence.KeyValueStore, prefix string) {
+	expect(t, kv, prefix+"/missing", "")
+}
+
+func update(t *testing.T, kv persistence.KeyValueStore, prefix string) {
+	key := prefix + "/update"
+	if err := kv.Update(key, "first"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expect(t, kv, key, "first")
+
+	if err := kv.Update(key, "second"); err != nil {
+		t.Fatal(err.Error())
+	}
+	expect(t, kv, key, "second")
+}
+
+func readAll(t *testing.T, kv persistence.KeyValueStore, prefix string) {
+	dir := prefix + "/all/"
+	for _, k := range []string{"a", "b", "c"} {
+		if err := kv.Update(dir+k, k); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := kv.Update(prefix+"/other", "x"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	all, err := kv.ReadAll(dir)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(all) != 3 || all[dir+"a"] != "a" || all[dir+"b"] != "b" || all[dir+"c"] != "c" {
+		t.Fatalf("Expected exactly the 3 keys under %s keyed by their full path, got %v", dir, all)
+	}
+
+	none, err := kv.ReadAll(prefix + "/nothing/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(none) != 0 {
+		t.Fatalf("Expected nothing under an empty prefix, got %v", none)
+	}
+}
+
+func deleteKey(t *testing.T, kv persistence.KeyValueStore, prefix string) {
+	key := prefix + "/delete"
+	if err := kv.Update(key, "value"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := kv.Delete(key); err != nil {
+		t.Fatal(err.Error())
+	}
+	expect(t, kv, key, "")
+
+	if err := kv.Delete(key); err != nil {
+		t.Fatalf("Deleting a missing key should not fail: %s", err.Error())
+	}
+}
+
+func leases(t *testing.T, kv persistence.KeyValueStore, prefix string) {
+	key := prefix + "/lease"
+	id, err := kv.CreateWithLease(key, "leased", 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expect(t, kv, key, "leased")
+
+	if err := kv.RefreshLease(id); err != nil {
+		t.Fatalf("Refreshing a live lease should work: %s", err.Error())
+	}
+
+	deadline := time.Now().Add(expiryTimeout)
+	for {
+		v, err := kv.Read(key)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if v == "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Leased key was still around after %s", expiryTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := kv.RefreshLease(id); err == nil {
+		t.Fatal("Refreshing an expired lease should fail")
+	}
+}
+
+func expect(t *testing.T, kv persistence.KeyValueStore, key, value string) {
+	v, err := kv.Read(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if v != value {
+		t.Fatalf("Expected %s to hold %q, got %q", key, value, v)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package conformance
+
+import (
+	"github.com/verizonlabs/hydrogen/task/persistence/drivers/etcd"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs the suite against a real etcd cluster when one is given through HYDROGEN_ETCD_ENDPOINTS.
+func TestEtcd(t *testing.T) {
+	endpoints := os.Getenv("HYDROGEN_ETCD_ENDPOINTS")
+	if endpoints == "" {
+		t.Skip("Set HYDROGEN_ETCD_ENDPOINTS to run the storage conformance suite against etcd")
+	}
+
+	e, err := etcd.NewClient(strings.Split(endpoints, ","), 2*time.Second, 30*time.Second, 20*time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	Run(t, e)
+}
@@ -0,0 +1,285 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// Package embedded provides a key value store that lives in a single local file.
+// It's meant for running on one node, development, and CI where standing up an etcd cluster isn't worth it.
+// Leases are emulated with TTLs and every write is synced to disk before it's acknowledged.
+//
+package embedded
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Embedded key value store backed by a single file.
+	Embedded struct {
+		mutex sync.Mutex
+		path  string
+		state state
+	}
+
+	// Everything that's written to disk.
+	state struct {
+		Keys      map[string]entry `json:"keys"`
+		Leases    map[int64]lease  `json:"leases"`
+		NextLease int64            `json:"nextLease"`
+	}
+
+	entry struct {
+		Value string `json:"value"`
+		Lease int64  `json:"lease,omitempty"`
+	}
+
+	lease struct {
+		TTL     int64     `json:"ttl"`
+		Expires time.Time `json:"expires"`
+	}
+)
+
+var LeaseNotFound = errors.New("Lease not found or already expired")
+
+// Opens the store at the given path, creating it if it doesn't exist yet.
+func NewClient(path string) (*Embedded, error) {
+	e := &Embedded{
+		path: path,
+		state: state{
+			Keys:   make(map[string]entry),
+			Leases: make(map[int64]lease),
+		},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e, e.flush(e.state)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &e.state); err != nil {
+		return nil, errors.New("Failed to load " + path + ": " + err.Error())
+	}
+	if e.state.Keys == nil {
+		e.state.Keys = make(map[string]entry)
+	}
+	if e.state.Leases == nil {
+		e.state.Leases = make(map[int64]lease)
+	}
+
+	return e, nil
+}
+
+// Creates the key if it doesn't exist yet.
+// Like etcd, creating a key that already exists leaves it untouched.
+func (e *Embedded) Create(key, value string) error {
+	return e.write(func(s *state) error {
+		if _, ok := s.Keys[key]; !ok {
+			s.Keys[key] = entry{Value: value}
+		}
+		return nil
+	})
+}
+
+// Sets the key and attaches it to a new lease that expires after ttl seconds.
+func (e *Embedded) CreateWithLease(key, value string, ttl int64) (int64, error) {
+	if ttl <= 0 {
+		return 0, errors.New("Lease TTL must be positive")
+	}
+
+	var id int64
+	err := e.write(func(s *state) error {
+		s.NextLease++
+		id = s.NextLease
+		s.Leases[id] = lease{TTL: ttl, Expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+		s.Keys[key] = entry{Value: value, Lease: id}
+		return nil
+	})
+
+	return id, err
+}
+
+// Reads the key, returning an empty value if it doesn't exist.
+func (e *Embedded) Read(key string) (string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expire(&e.state, time.Now())
+	return e.state.Keys[key].Value, nil
+}
+
+// Reads every key that starts with the given prefix.
+func (e *Embedded) ReadAll(key string) (map[string]string, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.expire(&e.state, time.Now())
+	all := make(map[string]string)
+	for k, v := range e.state.Keys {
+		if strings.HasPrefix(k, key) {
+			all[k] = v.Value
+		}
+	}
+
+	return all, nil
+}
+
+// Sets the key, creating it if needed.
+func (e *Embedded) Update(key, value string) error {
+	return e.write(func(s *state) error {
+		s.Keys[key] = entry{Value: value}
+		return nil
+	})
+}
+
+// Restarts the countdown of a lease that hasn't expired yet.
+func (e *Embedded) RefreshLease(id int64) error {
+	return e.write(func(s *state) error {
+		l, ok := s.Leases[id]
+		if !ok {
+			return LeaseNotFound
+		}
+
+		l.Expires = time.Now().Add(time.Duration(l.TTL) * time.Second)
+		s.Leases[id] = l
+		return nil
+	})
+}
+
+// Removes the key. Deleting a key that doesn't exist is not an error.
+func (e *Embedded) Delete(key string) error {
+	return e.write(func(s *state) error {
+		delete(s.Keys, key)
+		return nil
+	})
+}
+
+// Applies every operation with a single write to disk so either all or none of them are kept.
+func (e *Embedded) Transaction(ops ...persistence.Operation) error {
+	return e.write(func(s *state) error {
+		for _, op := range ops {
+			switch op.Type {
+			case persistence.PUT:
+				s.Keys[op.Key] = entry{Value: op.Value}
+			case persistence.DELETE:
+				delete(s.Keys, op.Key)
+			default:
+				return errors.New("Unknown operation type for key " + op.Key)
+			}
+		}
+		return nil
+	})
+}
+
+// Applies a change to a copy of the current state and only keeps it once it's safely on disk.
+func (e *Embedded) write(change func(*state) error) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	next := e.state.copy()
+	e.expire(&next, time.Now())
+	if err := change(&next); err != nil {
+		return err
+	}
+	if err := e.flush(next); err != nil {
+		return err
+	}
+	e.state = next
+
+	return nil
+}
+
+// Drops expired leases along with every key attached to them.
+// Expired keys stay on disk until the next write.
+func (e *Embedded) expire(s *state, now time.Time) {
+	for id, l := range s.Leases {
+		if now.Before(l.Expires) {
+			continue
+		}
+
+		delete(s.Leases, id)
+		for k, v := range s.Keys {
+			if v.Lease == id {
+				delete(s.Keys, k)
+			}
+		}
+	}
+}
+
+//
+// Writes the state to a temporary file next to ours, syncs it, then renames it into place.
+// The rename is atomic so a crash leaves either the old or the new state on disk, never a partial one.
+//
+func (e *Embedded) flush(s state) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(e.path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(e.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), e.path); err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename itself survives a crash.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func (s state) copy() state {
+	c := state{
+		Keys:      make(map[string]entry, len(s.Keys)),
+		Leases:    make(map[int64]lease, len(s.Leases)),
+		NextLease: s.NextLease,
+	}
+	for k, v := range s.Keys {
+		c.Keys[k] = v
+	}
+	for k, v := range s.Leases {
+		c.Leases[k] = v
+	}
+
+	return c
+}
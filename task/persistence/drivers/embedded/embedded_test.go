@@ -0,0 +1,126 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package embedded
+
+import (
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/conformance"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func store(t *testing.T) (*Embedded, string) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	path := filepath.Join(dir, "hydrogen.db")
+	e, err := NewClient(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return e, dir
+}
+
+func TestEmbedded_Conformance(t *testing.T) {
+	e, dir := store(t)
+	defer os.RemoveAll(dir)
+
+	conformance.Run(t, e)
+}
+
+// Everything written must still be there after reopening the file.
+func TestEmbedded_Reopen(t *testing.T) {
+	e, dir := store(t)
+	defer os.RemoveAll(dir)
+
+	if err := e.Update("/a", "a"); err != nil {
+		t.Fatal(err.Error())
+	}
+	id, err := e.CreateWithLease("/leased", "b", 60)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reopened, err := NewClient(e.path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if v, _ := reopened.Read("/a"); v != "a" {
+		t.Fatalf("Expected /a to survive reopening, got %q", v)
+	}
+	if v, _ := reopened.Read("/leased"); v != "b" {
+		t.Fatalf("Expected /leased to survive reopening, got %q", v)
+	}
+	if err := reopened.RefreshLease(id); err != nil {
+		t.Fatalf("Lease should survive reopening: %s", err.Error())
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("Expected only the store file to be left behind, got %d files", len(files))
+	}
+}
+
+func TestEmbedded_Corrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embedded")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hydrogen.db")
+	ioutil.WriteFile(path, []byte("not json"), 0600)
+	if _, err := NewClient(path); err == nil {
+		t.Fatal("Loading a corrupt file should fail")
+	}
+}
+
+func TestEmbedded_Transaction(t *testing.T) {
+	e, dir := store(t)
+	defer os.RemoveAll(dir)
+
+	e.Update("/c", "old c")
+	p := persistence.NewPersistence(e, "", persistence.RetryPolicy{})
+	err := p.Transaction(
+		persistence.Operation{Type: persistence.PUT, Key: "/a", Value: "a"},
+		persistence.Operation{Type: persistence.DELETE, Key: "/c"},
+	)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if v, _ := e.Read("/a"); v != "a" {
+		t.Fatal("Transaction should have written /a")
+	}
+	if v, _ := e.Read("/c"); v != "" {
+		t.Fatal("Transaction should have deleted /c")
+	}
+
+	// An invalid operation must leave everything untouched.
+	err = e.Transaction(
+		persistence.Operation{Type: persistence.PUT, Key: "/b", Value: "b"},
+		persistence.Operation{Type: persistence.OperationType(42), Key: "/bad"},
+	)
+	if err == nil {
+		t.Fatal("Transaction with an unknown operation should fail")
+	}
+	if v, _ := e.Read("/b"); v != "" {
+		t.Fatal("Failed transaction should not write anything")
+	}
+}
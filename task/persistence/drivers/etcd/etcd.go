@@ -0,0 +1,152 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// Package etcd provides the etcd key value store with native transactions and watches on top of the SDK's driver.
+// Plain reads, writes and leases go through the SDK driver while transactions and watches use their own client
+// since the SDK doesn't expose the one it holds.
+//
+package etcd
+
+import (
+	"context"
+	"errors"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/mesos-framework-sdk/persistence/drivers/etcd"
+	"time"
+)
+
+// Most operations etcd accepts in a single transaction unless the cluster was started with a higher --max-txn-ops.
+const MaxTxnOps = 128
+
+// Etcd key value store that applies transactions atomically and watches for changes.
+type Etcd struct {
+	*etcd.Etcd
+	client  *clientv3.Client
+	timeout time.Duration
+}
+
+// Connects to the etcd cluster at the given endpoints.
+func NewClient(endpoints []string, timeout, kaTime, kaTimeout time.Duration) (*Etcd, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:            endpoints,
+		DialTimeout:          timeout,
+		DialKeepAliveTime:    kaTime,
+		DialKeepAliveTimeout: kaTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Etcd{
+		Etcd:    etcd.NewClient(endpoints, timeout, kaTime, kaTimeout),
+		client:  client,
+		timeout: timeout,
+	}, nil
+}
+
+//
+// Transaction applies the operations in a single etcd transaction so either all or none of them are kept.
+// etcd limits how many operations one transaction may hold, so anything past MaxTxnOps is split into
+// several transactions that are applied in order. Each of those is atomic but they aren't atomic together,
+// and a failure leaves the ones before it applied.
+//
+func (e *Etcd) Transaction(ops ...persistence.Operation) error {
+	for _, batch := range batches(ops, MaxTxnOps) {
+		if err := e.commit(batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Splits the operations into consecutive batches of at most size operations each.
+func batches(ops []persistence.Operation, size int) [][]persistence.Operation {
+	split := [][]persistence.Operation{}
+	for start := 0; start < len(ops); start += size {
+		end := start + size
+		if end > len(ops) {
+			end = len(ops)
+		}
+		split = append(split, ops[start:end])
+	}
+
+	return split
+}
+
+func (e *Etcd) commit(ops []persistence.Operation) error {
+	txn := make([]clientv3.Op, len(ops))
+	for i, op := range ops {
+		switch op.Type {
+		case persistence.PUT:
+			txn[i] = clientv3.OpPut(op.Key, op.Value)
+		case persistence.DELETE:
+			txn[i] = clientv3.OpDelete(op.Key)
+		default:
+			return errors.New("Unknown operation type for key " + op.Key)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.timeout)
+	defer cancel()
+
+	_, err := e.client.Txn(ctx).Then(txn...).Commit()
+	return err
+}
+
+//
+// Watch reports every key under the directory that's written or deleted from now on.
+// It only returns once etcd has registered the watch, so anything written after that is seen.
+// The channel is closed once the context is cancelled or if etcd ends the watch,
+// such as when the revision we were at has been compacted away.
+//
+func (e *Etcd) Watch(ctx context.Context, key string) <-chan persistence.Operation {
+	changes := make(chan persistence.Operation)
+
+	ctx, cancel := context.WithCancel(ctx)
+	events := e.client.Watch(clientv3.WithRequireLeader(ctx), key, clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+	if created, ok := <-events; !ok || created.Err() != nil || !created.Created {
+		cancel()
+		close(changes)
+		return changes
+	}
+
+	go func() {
+		defer cancel()
+		defer close(changes)
+
+		for resp := range events {
+			if resp.Err() != nil || resp.Canceled {
+				return
+			}
+
+			for _, ev := range resp.Events {
+				op := persistence.Operation{Type: persistence.PUT, Key: string(ev.Kv.Key), Value: string(ev.Kv.Value)}
+				if ev.Type == clientv3.EventTypeDelete {
+					op = persistence.Operation{Type: persistence.DELETE, Key: string(ev.Kv.Key)}
+				}
+
+				select {
+				case changes <- op:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return changes
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package etcd
+
+import (
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"strconv"
+	"testing"
+)
+
+func TestBatches(t *testing.T) {
+	for _, n := range []int{0, 1, MaxTxnOps, MaxTxnOps + 1, 3 * MaxTxnOps} {
+		ops := make([]persistence.Operation, n)
+		for i := range ops {
+			ops[i] = persistence.Operation{Type: persistence.PUT, Key: "/" + strconv.Itoa(i)}
+		}
+
+		next := 0
+		for _, batch := range batches(ops, MaxTxnOps) {
+			if len(batch) == 0 || len(batch) > MaxTxnOps {
+				t.Fatalf("Batch of %d operations out of %d", len(batch), n)
+			}
+			for _, op := range batch {
+				if op.Key != "/"+strconv.Itoa(next) {
+					t.Fatalf("Expected /%d next, got %s", next, op.Key)
+				}
+				next++
+			}
+		}
+		if next != n {
+			t.Fatalf("Expected %d operations in batches, got %d", n, next)
+		}
+	}
+}
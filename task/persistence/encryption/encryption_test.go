@@ -0,0 +1,231 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/test"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	oldKey = []byte("0123456789abcdef0123456789abcdef")
+	newKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func keyring(t *testing.T, primary string, keys map[string][]byte) *Keyring {
+	k, err := NewKeyring(primary, keys)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return k
+}
+
+func encryptedFixture(t *testing.T, kv *test.MockFailingKVStore, k *Keyring) *Encrypted {
+	s := persistence.NewPersistence(kv, "", persistence.RetryPolicy{})
+	return NewEncrypted(s, k, []string{"/tasks/"}, new(mockLogger.MockLogger))
+}
+
+func TestKeyring_SealOpen(t *testing.T) {
+	k := keyring(t, "old", map[string][]byte{"old": oldKey})
+
+	sealed, err := k.Seal("/tasks/a", "secret")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if strings.Contains(sealed, "secret") || !strings.HasPrefix(sealed, "enc:old:") {
+		t.Fatalf("Expected an encrypted value under key old, got %s", sealed)
+	}
+
+	if plain, err := k.Open("/tasks/a", sealed); err != nil || plain != "secret" {
+		t.Fatalf("Expected to get the secret back, got %q: %v", plain, err)
+	}
+	if _, err := k.Open("/tasks/b", sealed); err == nil {
+		t.Fatal("A value moved to another key should fail to decrypt")
+	}
+	if plain, err := k.Open("/tasks/a", "plaintext"); err != nil || plain != "plaintext" {
+		t.Fatal("Plaintext values should be read back untouched")
+	}
+
+	other := keyring(t, "new", map[string][]byte{"new": newKey})
+	if _, err := other.Open("/tasks/a", sealed); err == nil {
+		t.Fatal("Values encrypted with a key we don't have should fail")
+	}
+}
+
+func TestNewKeyring_Invalid(t *testing.T) {
+	if _, err := NewKeyring("missing", map[string][]byte{"old": oldKey}); err == nil {
+		t.Fatal("The primary key has to be in the keyring")
+	}
+	if _, err := NewKeyring("short", map[string][]byte{"short": []byte("short")}); err == nil {
+		t.Fatal("Keys have to be a valid AES key size")
+	}
+	if _, err := NewKeyring("a:b", map[string][]byte{"a:b": oldKey}); err == nil {
+		t.Fatal("Key IDs can't contain a colon")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	f, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"primary": "new", "keys": {"old": "` + base64.StdEncoding.EncodeToString(oldKey) +
+		`", "new": "` + base64.StdEncoding.EncodeToString(newKey) + `"}}`)
+	f.Close()
+
+	k, err := LoadKeyring(f.Name())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if k.Primary() != "new" || len(k.ciphers) != 2 {
+		t.Fatalf("Expected both keys with new as the primary, got %s and %d keys", k.Primary(), len(k.ciphers))
+	}
+}
+
+func TestEncrypted_Storage(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{}}
+	e := encryptedFixture(t, kv, keyring(t, "old", map[string][]byte{"old": oldKey}))
+
+	if err := e.Update("/tasks/a", "secret a"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := e.Transaction(persistence.Operation{Type: persistence.PUT, Key: "/tasks/b", Value: "secret b"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := e.Update("/leader", "1.2.3.4"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for key, value := range kv.Data {
+		if strings.HasPrefix(key, "/tasks/") && strings.Contains(value, "secret") {
+			t.Fatalf("%s should be encrypted at rest, got %s", key, value)
+		}
+	}
+	if kv.Data["/leader"] != "1.2.3.4" {
+		t.Fatal("Values outside of the encrypted directories should be left as they are")
+	}
+
+	all, err := e.ReadAll("/tasks/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if all["/tasks/a"] != "secret a" || all["/tasks/b"] != "secret b" {
+		t.Fatalf("Expected decrypted values, got %v", all)
+	}
+}
+
+// Watchers see what was written, not what's at rest.
+func TestEncrypted_Watch(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{}}
+	e := encryptedFixture(t, kv, keyring(t, "old", map[string][]byte{"old": oldKey}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := e.Watch(ctx, "/tasks/")
+	if err := e.Update("/tasks/a", "secret a"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	select {
+	case op := <-changes:
+		if op.Key != "/tasks/a" || op.Value != "secret a" {
+			t.Fatalf("Expected a decrypted change, got %+v", op)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a change")
+	}
+}
+
+// Values written with an old key stay readable during a rotation and are moved over by re-encrypting.
+func TestEncrypted_Rotation(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/tasks/plain": "from before encryption"}}
+	old := encryptedFixture(t, kv, keyring(t, "old", map[string][]byte{"old": oldKey}))
+	if err := old.Update("/tasks/a", "secret"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	rotated := encryptedFixture(t, kv, keyring(t, "new", map[string][]byte{"old": oldKey, "new": newKey}))
+	if v, err := rotated.Read("/tasks/a"); err != nil || v != "secret" {
+		t.Fatalf("Values under the old key should still be readable, got %q: %v", v, err)
+	}
+
+	count, err := rotated.Reencrypt(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if count != 2 {
+		t.Fatalf("Expected both the old and plaintext values to be re-encrypted, got %d", count)
+	}
+	if count, _ := rotated.Reencrypt(context.Background()); count != 0 {
+		t.Fatalf("Nothing should be left to re-encrypt, got %d", count)
+	}
+
+	// The old key can be dropped once everything has been re-encrypted.
+	retired := encryptedFixture(t, kv, keyring(t, "new", map[string][]byte{"new": newKey}))
+	all, err := retired.ReadAll("/tasks/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if all["/tasks/a"] != "secret" || all["/tasks/plain"] != "from before encryption" {
+		t.Fatalf("Expected every value to be readable with only the new key, got %v", all)
+	}
+}
+
+// Writes carry on while re-encrypting a key is backing off after a failure.
+func TestEncrypted_ReencryptBackoff(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/tasks/plain": "from before encryption"}, FailOn: 1}
+	s := persistence.NewPersistence(kv, "", persistence.RetryPolicy{MaxRetries: 1, Backoff: 2 * time.Second})
+	e := NewEncrypted(s, keyring(t, "new", map[string][]byte{"new": newKey}), []string{"/tasks/"}, new(mockLogger.MockLogger))
+
+	done := make(chan int)
+	go func() {
+		count, err := e.Reencrypt(context.Background())
+		if err != nil {
+			t.Error(err.Error())
+		}
+		done <- count
+	}()
+
+	// Give the first attempt time to fail so we're writing while it backs off.
+	time.Sleep(200 * time.Millisecond)
+	written := make(chan error)
+	go func() { written <- e.Update("/tasks/a", "secret") }()
+
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	case <-done:
+		t.Fatal("Writes shouldn't wait for re-encryption to finish backing off")
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a write while re-encryption was backing off")
+	}
+
+	if count := <-done; count != 1 {
+		t.Fatalf("Expected the plaintext value to be re-encrypted once the retry succeeded, got %d", count)
+	}
+}
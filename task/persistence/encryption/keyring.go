@@ -0,0 +1,168 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// Package encryption keeps what we persist encrypted at rest.
+// Values are sealed with AES-GCM under a named key from a key file, so keys can be rotated without losing older records.
+//
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Marks a value as encrypted. Anything without it is treated as plaintext from before encryption was turned on.
+const marker = "enc:"
+
+//
+// Key file layout. Keys are base64 encoded and must be 16, 24, or 32 bytes long to pick AES-128, AES-192, or AES-256.
+// New values are always encrypted with the primary key while every other key is only kept around for reading.
+//
+// {"primary": "2018-02", "keys": {"2018-01": "...", "2018-02": "..."}}
+//
+type keyFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// Every key we know about along with the one used for new values.
+type Keyring struct {
+	primary string
+	ciphers map[string]cipher.AEAD
+}
+
+// Loads the keyring from a key file.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.New("Invalid key file " + path + ": " + err.Error())
+	}
+
+	keys := make(map[string][]byte, len(f.Keys))
+	for id, encoded := range f.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("Key " + id + " is not valid base64")
+		}
+		keys[id] = key
+	}
+
+	return NewKeyring(f.Primary, keys)
+}
+
+// Builds a keyring from raw keys, with new values encrypted using the primary key.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, errors.New("Primary key " + primary + " is not in the keyring")
+	}
+
+	k := &Keyring{
+		primary: primary,
+		ciphers: make(map[string]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, errors.New("Key ID " + id + " must not be empty or contain a colon")
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.New("Key " + id + ": " + err.Error())
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.ciphers[id] = gcm
+	}
+
+	return k, nil
+}
+
+// Returns the ID of the key new values are encrypted with.
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+//
+// Seal encrypts the value with the primary key.
+// The storage key is authenticated along with it so an encrypted value can't be moved to another key unnoticed.
+//
+func (k *Keyring) Seal(key, value string) (string, error) {
+	gcm := k.ciphers[k.primary]
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), []byte(key))
+	return marker + k.primary + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed with any key in the keyring. Plaintext values are returned untouched.
+func (k *Keyring) Open(key, value string) (string, error) {
+	id, sealed, ok := parse(value)
+	if !ok {
+		return value, nil
+	}
+
+	gcm, ok := k.ciphers[id]
+	if !ok {
+		return "", errors.New("Value of " + key + " is encrypted with unknown key " + id)
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < gcm.NonceSize() {
+		return "", errors.New("Value of " + key + " is not a valid encrypted value")
+	}
+
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(key))
+	if err != nil {
+		return "", errors.New("Failed to decrypt " + key + " with key " + id + ": " + err.Error())
+	}
+
+	return string(plain), nil
+}
+
+// Reports whether the value needs to be re-encrypted with the primary key.
+func (k *Keyring) Stale(value string) bool {
+	id, _, ok := parse(value)
+	return !ok || id != k.primary
+}
+
+// Splits an encrypted value into its key ID and sealed data.
+func parse(value string) (string, string, bool) {
+	if !strings.HasPrefix(value, marker) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, marker), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,277 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"context"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Encrypted wraps storage so values under the given directories are encrypted before they're written.
+// Every value read back is decrypted, whichever key it was written with.
+// Writes are serialized so re-encrypting can never overwrite something newer.
+// Re-encrypting writes to the wrapped storage directly,
+// so this should wrap anything that fences or tracks writes rather than the other way around.
+//
+type Encrypted struct {
+	persistence.Storage
+	mutex       sync.Mutex
+	keys        *Keyring
+	directories []string
+	logger      logging.Logger
+}
+
+// Returns storage that encrypts values under any of the directories.
+func NewEncrypted(s persistence.Storage, k *Keyring, directories []string, l logging.Logger) *Encrypted {
+	return &Encrypted{
+		Storage:     s,
+		keys:        k,
+		directories: directories,
+		logger:      l,
+	}
+}
+
+func (e *Encrypted) Create(key, value string) error {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.Storage.Create(key, sealed)
+}
+
+func (e *Encrypted) CreateWithLease(key, value string, ttl int64) (int64, error) {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return 0, err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.Storage.CreateWithLease(key, sealed, ttl)
+}
+
+func (e *Encrypted) Read(key string) (string, error) {
+	value, err := e.Storage.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	return e.keys.Open(key, value)
+}
+
+func (e *Encrypted) ReadAll(key string) (map[string]string, error) {
+	all, err := e.Storage.ReadAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make(map[string]string, len(all))
+	for k, v := range all {
+		plain, err := e.keys.Open(k, v)
+		if err != nil {
+			return nil, err
+		}
+		opened[k] = plain
+	}
+
+	return opened, nil
+}
+
+func (e *Encrypted) Update(key, value string) error {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.Storage.Update(key, sealed)
+}
+
+func (e *Encrypted) Delete(key string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.Storage.Delete(key)
+}
+
+func (e *Encrypted) Transaction(ops ...persistence.Operation) error {
+	sealed := make([]persistence.Operation, len(ops))
+	for i, op := range ops {
+		sealed[i] = op
+		if op.Type != persistence.PUT {
+			continue
+		}
+
+		value, err := e.seal(op.Key, op.Value)
+		if err != nil {
+			return err
+		}
+		sealed[i].Value = value
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.Storage.Transaction(sealed...)
+}
+
+// Reports changes the same as the storage underneath, with every value decrypted.
+// Values that can't be decrypted end the watch since anything after them can't be trusted to converge.
+func (e *Encrypted) Watch(ctx context.Context, key string) <-chan persistence.Operation {
+	changes := e.Storage.Watch(ctx, key)
+	opened := make(chan persistence.Operation)
+	go func() {
+		defer close(opened)
+		for op := range changes {
+			if op.Type == persistence.PUT {
+				plain, err := e.keys.Open(op.Key, op.Value)
+				if err != nil {
+					e.logger.Emit(logging.ERROR, "Failed to decrypt %s while watching: %s", op.Key, err.Error())
+					return
+				}
+				op.Value = plain
+			}
+
+			select {
+			case opened <- op:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return opened
+}
+
+//
+// Reencrypt rewrites every value that isn't encrypted with the primary key yet, including plaintext from before
+// encryption was turned on. Each value is read again while holding the write lock so nothing newer is overwritten.
+// Returns how many values were rewritten.
+//
+func (e *Encrypted) Reencrypt(ctx context.Context) (int, error) {
+	count := 0
+	for _, dir := range e.directories {
+		var all map[string]string
+		err := e.Storage.Retry(ctx, func() error {
+			a, err := e.Storage.ReadAll(dir)
+			all = a
+			return err
+		})
+		if err != nil {
+			return count, err
+		}
+
+		for key, value := range all {
+			if err := ctx.Err(); err != nil {
+				return count, err
+			}
+			if !e.keys.Stale(value) {
+				continue
+			}
+
+			rewritten, err := e.reencrypt(ctx, key)
+			if err != nil {
+				return count, err
+			}
+			if rewritten {
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+// Periodically re-encrypts stale values until the context is done.
+func (e *Encrypted) RunReencrypt(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := e.Reencrypt(ctx)
+		if err != nil {
+			e.logger.Emit(logging.ERROR, "Failed to re-encrypt stored values: %s", err.Error())
+		} else if count > 0 {
+			e.logger.Emit(logging.INFO, "Re-encrypted %d stored values with key %s", count, e.keys.Primary())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Each attempt holds the write lock so nobody writes the key between us reading and rewriting it,
+// but it's let go while backing off so other writes aren't held up by our retries.
+func (e *Encrypted) reencrypt(ctx context.Context, key string) (bool, error) {
+	rewritten := false
+	err := e.Storage.Retry(ctx, func() error {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+
+		value, err := e.Storage.Read(key)
+		if err != nil {
+			return err
+		}
+
+		// Deleted or already rewritten since we listed it.
+		if value == "" || !e.keys.Stale(value) {
+			return nil
+		}
+
+		plain, err := e.keys.Open(key, value)
+		if err != nil {
+			return err
+		}
+		sealed, err := e.keys.Seal(key, plain)
+		if err != nil {
+			return err
+		}
+
+		if err := e.Storage.Update(key, sealed); err != nil {
+			return err
+		}
+
+		rewritten = true
+		return nil
+	})
+
+	return rewritten, err
+}
+
+// Encrypts the value if its key is under one of our directories.
+func (e *Encrypted) seal(key, value string) (string, error) {
+	for _, dir := range e.directories {
+		if strings.HasPrefix(key, dir) {
+			return e.keys.Seal(key, value)
+		}
+	}
+
+	return value, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"github.com/verizonlabs/mesos-framework-sdk/persistence"
+	"strings"
+)
+
+//
+// MigrateUnprefixed moves keys written before keys were namespaced so they live under the prefix.
+// Roots ending in a slash are directories and everything under them is moved, anything else is a single key.
+// A key that's already been moved is left alone, so the migration can safely be run again after a failure.
+// Keys whose namespaced copy holds something different are skipped and reported back instead of being overwritten.
+// Keys found in leases are recreated on a new lease that expires after the given number of seconds.
+//
+func MigrateUnprefixed(kv persistence.KeyValueStore, namespace string, leases map[string]int64,
+	roots ...string) (moved int, skipped []string, err error) {
+	prefix := Prefix(namespace)
+	if prefix == "" {
+		return 0, nil, nil
+	}
+
+	for _, root := range roots {
+		keys := make(map[string]string)
+		if strings.HasSuffix(root, "/") {
+			keys, err = kv.ReadAll(root)
+			if err != nil {
+				return moved, skipped, err
+			}
+		} else {
+			value, err := kv.Read(root)
+			if err != nil {
+				return moved, skipped, err
+			}
+			if value != "" {
+				keys[root] = value
+			}
+		}
+
+		for key, value := range keys {
+			existing, err := kv.Read(prefix + key)
+			if err != nil {
+				return moved, skipped, err
+			}
+
+			if existing == "" {
+				if ttl, ok := leases[key]; ok {
+					_, err = kv.CreateWithLease(prefix+key, value, ttl)
+				} else {
+					err = kv.Update(prefix+key, value)
+				}
+				if err != nil {
+					return moved, skipped, err
+				}
+			} else if existing != value {
+				skipped = append(skipped, key)
+				continue
+			}
+
+			if err := kv.Delete(key); err != nil {
+				return moved, skipped, err
+			}
+			moved++
+		}
+	}
+
+	return moved, skipped, nil
+}
+
+//
+// Unmigrated reports whether keys were written before keys were namespaced and haven't been moved yet.
+// That's only the case when none of the roots have anything under the prefix but some of them have something outside of it,
+// since starting up then would look like a brand new framework and lose track of everything that's running.
+//
+func Unmigrated(kv persistence.KeyValueStore, namespace string, roots ...string) (bool, error) {
+	prefix := Prefix(namespace)
+	if prefix == "" {
+		return false, nil
+	}
+
+	found := false
+	for _, root := range roots {
+		namespaced, err := exists(kv, prefix+root)
+		if err != nil || namespaced {
+			return false, err
+		}
+
+		legacy, err := exists(kv, root)
+		if err != nil {
+			return false, err
+		}
+		found = found || legacy
+	}
+
+	return found, nil
+}
+
+// Checks whether a key, or anything under it for a directory, has been written.
+func exists(kv persistence.KeyValueStore, key string) (bool, error) {
+	if strings.HasSuffix(key, "/") {
+		all, err := kv.ReadAll(key)
+		return len(all) > 0, err
+	}
+
+	value, err := kv.Read(key)
+	return value != "", err
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+//
+// RetryPolicy describes how storage operations are retried.
+// Each retry waits twice as long as the one before it, starting at Backoff and never going past MaxBackoff.
+// Jitter randomizes each wait by up to that fraction of it so that several schedulers don't retry in lockstep.
+//
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+}
+
+//
+// Run calls f until it succeeds, the retries run out, or the context is done.
+// Nothing is shared between calls so one failing operation never uses up the retries of another.
+// The last error from f is returned, or the context's error if it was cancelled while waiting.
+//
+func (r RetryPolicy) Run(ctx context.Context, f func() error) error {
+	backoff := r.Backoff
+	for retries := 0; ; retries++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := f()
+		if err == nil || retries >= r.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(r.jitter(backoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+			backoff = r.MaxBackoff
+		}
+	}
+}
+
+// Spreads the wait randomly by up to the jitter fraction in either direction.
+func (r RetryPolicy) jitter(d time.Duration) time.Duration {
+	if r.Jitter <= 0 || d <= 0 {
+		return d
+	}
+
+	delta := r.Jitter * float64(d)
+	return d + time.Duration(delta*(2*rand.Float64()-1))
+}
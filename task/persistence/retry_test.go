@@ -0,0 +1,82 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence_test
+
+import (
+	"context"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Run(t *testing.T) {
+	policy := persistence.RetryPolicy{MaxRetries: 3, Backoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Jitter: 0.5}
+
+	calls := 0
+	err := policy.Run(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("Not yet")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("Expected success on the third call, got %d calls and %v", calls, err)
+	}
+
+	calls = 0
+	err = policy.Run(context.Background(), func() error {
+		calls++
+		return errors.New("Always")
+	})
+	if err == nil || err.Error() != "Always" || calls != 4 {
+		t.Fatalf("Expected the last error after 4 calls, got %d calls and %v", calls, err)
+	}
+}
+
+// One operation running out of retries must not affect the next one.
+func TestPersistence_RetryIsPerOperation(t *testing.T) {
+	p := persistence.NewPersistence(nil, "", persistence.RetryPolicy{MaxRetries: 1})
+	for i := 0; i < 5; i++ {
+		p.Retry(context.Background(), func() error { return errors.New("Broken") })
+	}
+
+	if err := p.Retry(context.Background(), func() error { return nil }); err != nil {
+		t.Fatalf("A fresh operation should succeed: %s", err.Error())
+	}
+}
+
+func TestRetryPolicy_RunCancelled(t *testing.T) {
+	policy := persistence.RetryPolicy{MaxRetries: 100, Backoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := policy.Run(ctx, func() error {
+		calls++
+		return errors.New("Broken")
+	})
+	if err != context.Canceled || calls != 1 {
+		t.Fatalf("Expected cancellation after 1 call, got %d calls and %v", calls, err)
+	}
+
+	if err := policy.Run(ctx, func() error { return nil }); err != context.Canceled {
+		t.Fatal("Nothing should run once the context is done")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//
+// Package schema versions what we keep in storage.
+// Records are wrapped in an envelope that says which version wrote them,
+// and registered migrations bring older data up to date before the scheduler restores its state.
+//
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// Schema version this scheduler writes.
+// Bump it along with registering a migration whenever the layout of anything in storage changes.
+const VERSION = 1
+
+// Wraps a single stored record along with the schema version it was written with.
+type Envelope struct {
+	Version int    `json:"version"`
+	Data    string `json:"data"`
+}
+
+// Wraps the record in an envelope for the current schema version.
+func Wrap(data []byte) string {
+	e, _ := json.Marshal(Envelope{Version: VERSION, Data: string(data)})
+	return string(e)
+}
+
+//
+// Unwrap returns a stored record along with the schema version it was written with.
+// Records from before we had envelopes are returned untouched as version 0.
+// Records written by a newer scheduler than this one are rejected since we can't know what changed.
+//
+func Unwrap(value string) ([]byte, int, error) {
+	var e Envelope
+	if err := json.Unmarshal([]byte(value), &e); err != nil || e.Version == 0 {
+		return []byte(value), 0, nil
+	}
+	if e.Version > VERSION {
+		return nil, e.Version, errors.New("Record was written with schema version " + strconv.Itoa(e.Version) +
+			" which is newer than " + strconv.Itoa(VERSION))
+	}
+
+	return []byte(e.Data), e.Version, nil
+}
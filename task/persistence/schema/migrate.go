@@ -0,0 +1,201 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"context"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/mesos-framework-sdk/logging"
+	sdkPersistence "github.com/verizonlabs/mesos-framework-sdk/persistence"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Key that holds the schema version storage is currently at.
+const VERSION_KEY = "/schema/version"
+
+type (
+	//
+	// Migration brings storage from the version before it up to Version.
+	// Migrate reads and writes through the store it's handed. Nothing is written to storage until it returns,
+	// then everything it changed is applied in a single transaction along with the new schema version.
+	//
+	Migration struct {
+		Version     int
+		Description string
+		Migrate     func(kv sdkPersistence.KeyValueStore) error
+	}
+
+	// Runs every migration that storage hasn't seen yet.
+	Migrator struct {
+		storage    persistence.Storage
+		migrations []Migration
+		logger     logging.Logger
+	}
+
+	// What a migration did, or would do during a dry run.
+	Report struct {
+		Version     int                     `json:"version"`
+		Description string                  `json:"description"`
+		Changes     []persistence.Operation `json:"changes"`
+	}
+)
+
+var (
+	registered []Migration
+	mutex      sync.Mutex
+)
+
+// Register adds a migration that will be run on startup. It's meant to be called from init functions.
+func Register(m Migration) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	registered = append(registered, m)
+}
+
+// Returns every registered migration.
+func Registered() []Migration {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return append([]Migration{}, registered...)
+}
+
+// Returns a migrator that knows about the given migrations.
+func NewMigrator(s persistence.Storage, migrations []Migration, l logging.Logger) *Migrator {
+	sorted := append([]Migration{}, migrations...)
+	sort.Sort(byVersion(sorted))
+
+	return &Migrator{
+		storage:    s,
+		migrations: sorted,
+		logger:     l,
+	}
+}
+
+// Reads the schema version storage is at, with anything from before we tracked versions being version 0.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var version int
+	err := m.storage.Retry(ctx, func() error {
+		v, err := m.storage.Read(VERSION_KEY)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			version = 0
+			return nil
+		}
+
+		version, err = strconv.Atoi(v)
+		return err
+	})
+
+	return version, err
+}
+
+//
+// Run applies every migration newer than the version in storage, oldest first.
+// During a dry run nothing is written. Each migration still sees what the ones before it would have changed,
+// so the reports show exactly what a real run would do.
+// Storage written by a newer scheduler is refused since there's no telling what it expects.
+//
+func (m *Migrator) Run(ctx context.Context, dryRun bool) ([]Report, error) {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > VERSION {
+		return nil, errors.New("Storage is at schema version " + strconv.Itoa(current) +
+			" which is newer than this scheduler's version " + strconv.Itoa(VERSION))
+	}
+
+	o := newOverlay(m.storage)
+	reports := []Report{}
+	version := current
+	for _, migration := range m.migrations {
+		if migration.Version <= current {
+			continue
+		}
+		if migration.Version > VERSION {
+			return reports, errors.New("Migration to schema version " + strconv.Itoa(migration.Version) +
+				" is newer than this scheduler's version " + strconv.Itoa(VERSION))
+		}
+
+		if err := migration.Migrate(o); err != nil {
+			return reports, errors.New("Migration to schema version " + strconv.Itoa(migration.Version) +
+				" failed: " + err.Error())
+		}
+
+		changes := o.take()
+		report := Report{Version: migration.Version, Description: migration.Description, Changes: changes}
+		reports = append(reports, report)
+
+		if dryRun {
+			m.logger.Emit(logging.INFO, "Migration to schema version %d (%s) would change %d keys",
+				migration.Version, migration.Description, len(changes))
+			for _, c := range changes {
+				m.logger.Emit(logging.INFO, "  %s %s", operationName(c.Type), c.Key)
+			}
+			continue
+		}
+
+		ops := append(changes, persistence.Operation{
+			Type:  persistence.PUT,
+			Key:   VERSION_KEY,
+			Value: strconv.Itoa(migration.Version),
+		})
+		err := m.storage.Retry(ctx, func() error {
+			return m.storage.Transaction(ops...)
+		})
+		if err != nil {
+			return reports, errors.New("Failed to save migration to schema version " + strconv.Itoa(migration.Version) +
+				": " + err.Error())
+		}
+		o.reset()
+		version = migration.Version
+
+		m.logger.Emit(logging.INFO, "Migrated storage to schema version %d (%s), %d keys changed",
+			migration.Version, migration.Description, len(changes))
+	}
+
+	// Nothing left needs migrating so storage is marked as current.
+	if !dryRun && version < VERSION {
+		err := m.storage.Retry(ctx, func() error {
+			return m.storage.Update(VERSION_KEY, strconv.Itoa(VERSION))
+		})
+		if err != nil {
+			return reports, err
+		}
+	}
+
+	return reports, nil
+}
+
+func operationName(t persistence.OperationType) string {
+	if t == persistence.DELETE {
+		return "DELETE"
+	}
+
+	return "PUT"
+}
+
+type byVersion []Migration
+
+func (b byVersion) Len() int           { return len(b) }
+func (b byVersion) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byVersion) Less(i, j int) bool { return b[i].Version < b[j].Version }
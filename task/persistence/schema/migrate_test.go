@@ -0,0 +1,128 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"context"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/test"
+	mockLogger "github.com/verizonlabs/mesos-framework-sdk/logging/test"
+	sdkPersistence "github.com/verizonlabs/mesos-framework-sdk/persistence"
+	"strconv"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	data, version, err := Unwrap(Wrap([]byte("record")))
+	if err != nil || version != VERSION || string(data) != "record" {
+		t.Fatalf("Expected the record back at version %d, got %q at %d: %v", VERSION, data, version, err)
+	}
+
+	data, version, err = Unwrap("legacy")
+	if err != nil || version != 0 || string(data) != "legacy" {
+		t.Fatalf("Expected legacy records back untouched at version 0, got %q at %d: %v", data, version, err)
+	}
+
+	if _, _, err := Unwrap(`{"version": 99, "data": "future"}`); err == nil {
+		t.Fatal("Records from a newer schema should be rejected")
+	}
+}
+
+// Uppercases every value under /records/ so we can see what was migrated.
+func upper(kv sdkPersistence.KeyValueStore) error {
+	records, err := kv.ReadAll("/records/")
+	if err != nil {
+		return err
+	}
+	for k, v := range records {
+		if err := kv.Update(k, v+"!"); err != nil {
+			return err
+		}
+	}
+
+	return kv.Delete("/obsolete")
+}
+
+func migrations() []Migration {
+	return []Migration{{Version: 1, Description: "Mark records", Migrate: upper}}
+}
+
+func TestMigrator_Run(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/records/a": "a", "/obsolete": "x"}}
+	m := NewMigrator(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), migrations(), new(mockLogger.MockLogger))
+
+	reports, err := m.Run(context.Background(), false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(reports) != 1 || len(reports[0].Changes) != 2 {
+		t.Fatalf("Expected one migration with 2 changes, got %v", reports)
+	}
+	if kv.Data["/records/a"] != "a!" || kv.Data[VERSION_KEY] != strconv.Itoa(VERSION) {
+		t.Fatalf("Unexpected storage after migrating: %v", kv.Data)
+	}
+	if _, ok := kv.Data["/obsolete"]; ok {
+		t.Fatal("Deleted keys should be gone after migrating")
+	}
+
+	// Running again finds nothing to do.
+	reports, err = m.Run(context.Background(), false)
+	if err != nil || len(reports) != 0 || kv.Data["/records/a"] != "a!" {
+		t.Fatalf("Migrations should only run once, got %v: %v", reports, err)
+	}
+}
+
+func TestMigrator_DryRun(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/records/a": "a", "/obsolete": "x"}}
+	m := NewMigrator(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), migrations(), new(mockLogger.MockLogger))
+
+	reports, err := m.Run(context.Background(), true)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(reports) != 1 || len(reports[0].Changes) != 2 || reports[0].Changes[1].Value != "a!" {
+		t.Fatalf("Expected the dry run to report both changes, got %v", reports)
+	}
+	if len(kv.Data) != 2 || kv.Data["/records/a"] != "a" {
+		t.Fatalf("A dry run must not change storage, got %v", kv.Data)
+	}
+}
+
+// A failing migration must leave storage untouched, including the version.
+func TestMigrator_Failure(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/records/a": "a"}}
+	broken := []Migration{{Version: 1, Migrate: func(kv sdkPersistence.KeyValueStore) error {
+		kv.Update("/records/b", "b")
+		return errors.New("Broken")
+	}}}
+	m := NewMigrator(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), broken, new(mockLogger.MockLogger))
+
+	if _, err := m.Run(context.Background(), false); err == nil {
+		t.Fatal("Expected the migration to fail")
+	}
+	if _, ok := kv.Data["/records/b"]; ok || kv.Data[VERSION_KEY] != "" {
+		t.Fatalf("Nothing from a failed migration should be saved, got %v", kv.Data)
+	}
+}
+
+func TestMigrator_Newer(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{VERSION_KEY: strconv.Itoa(VERSION + 1)}}
+	m := NewMigrator(persistence.NewPersistence(kv, "", persistence.RetryPolicy{}), migrations(), new(mockLogger.MockLogger))
+
+	if _, err := m.Run(context.Background(), false); err == nil {
+		t.Fatal("Storage from a newer scheduler should be refused")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"sort"
+	"strings"
+)
+
+//
+// Holds the writes a migration makes on top of what's in storage without touching storage itself.
+// Reads see the pending writes so migrations can build on each other.
+//
+type overlay struct {
+	storage persistence.Storage
+	values  map[string]*string // Nil means the key was deleted.
+	pending map[string]bool    // Keys changed since the last take.
+}
+
+func newOverlay(s persistence.Storage) *overlay {
+	o := &overlay{storage: s}
+	o.reset()
+
+	return o
+}
+
+func (o *overlay) Create(key, value string) error {
+	v, err := o.Read(key)
+	if err != nil || v != "" {
+		return err
+	}
+
+	return o.Update(key, value)
+}
+
+func (o *overlay) CreateWithLease(key, value string, ttl int64) (int64, error) {
+	return 0, errors.New("Leases can't be created during a migration")
+}
+
+func (o *overlay) Read(key string) (string, error) {
+	if v, ok := o.values[key]; ok {
+		if v == nil {
+			return "", nil
+		}
+		return *v, nil
+	}
+
+	return o.storage.Read(key)
+}
+
+func (o *overlay) ReadAll(key string) (map[string]string, error) {
+	all, err := o.storage.ReadAll(key)
+	if err != nil {
+		return nil, err
+	}
+	if all == nil {
+		all = make(map[string]string)
+	}
+
+	for k, v := range o.values {
+		if !strings.HasPrefix(k, key) {
+			continue
+		}
+		if v == nil {
+			delete(all, k)
+		} else {
+			all[k] = *v
+		}
+	}
+
+	return all, nil
+}
+
+func (o *overlay) Update(key, value string) error {
+	o.values[key] = &value
+	o.pending[key] = true
+
+	return nil
+}
+
+func (o *overlay) RefreshLease(id int64) error {
+	return errors.New("Leases can't be refreshed during a migration")
+}
+
+func (o *overlay) Delete(key string) error {
+	o.values[key] = nil
+	o.pending[key] = true
+
+	return nil
+}
+
+// Returns the keys changed since the last take as operations, sorted by key.
+func (o *overlay) take() []persistence.Operation {
+	keys := make([]string, 0, len(o.pending))
+	for k := range o.pending {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ops := make([]persistence.Operation, 0, len(keys))
+	for _, k := range keys {
+		if v := o.values[k]; v != nil {
+			ops = append(ops, persistence.Operation{Type: persistence.PUT, Key: k, Value: *v})
+		} else {
+			ops = append(ops, persistence.Operation{Type: persistence.DELETE, Key: k})
+		}
+	}
+	o.pending = make(map[string]bool)
+
+	return ops
+}
+
+// Forgets every write once they've been saved to storage.
+func (o *overlay) reset() {
+	o.values = make(map[string]*string)
+	o.pending = make(map[string]bool)
+}
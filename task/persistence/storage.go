@@ -15,70 +15,274 @@
 package persistence
 
 import (
+	"context"
 	"errors"
-	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
 	"github.com/verizonlabs/mesos-framework-sdk/persistence"
-	"github.com/verizonlabs/mesos-framework-sdk/task"
-	"github.com/verizonlabs/mesos-framework-sdk/task/retry"
+	"strings"
 	"time"
 )
 
 // Provides pluggable storage types that can be used to persist state.
 // Also used extensively for testing with mocks.
 type Storage interface {
-	retry.Retry
 	persistence.KeyValueStore
+	Transaction(ops ...Operation) error
+	Retry(ctx context.Context, f func() error) error
+	Watch(ctx context.Context, key string) <-chan Operation
+}
+
+// Describes what an operation in a transaction does to its key.
+type OperationType int
+
+const (
+	PUT OperationType = iota
+	DELETE
+)
+
+// A single write or delete that's applied as part of a transaction.
+type Operation struct {
+	Type  OperationType
+	Key   string
+	Value string
+}
+
+// Key value stores that can natively apply several operations atomically.
+// Stores that don't implement this have transactions emulated for them.
+type Transactional interface {
+	Transaction(ops ...Operation) error
+}
+
+// Key value stores that can natively report changes under a directory as they happen.
+// Stores that don't implement this have changes found for them by polling.
+type Watchable interface {
+	Watch(ctx context.Context, key string) <-chan Operation
 }
 
 // Primary persistence engine that's used to store task state, high availability metadata, and more.
+// Every key is namespaced under a prefix so several frameworks can share one store.
 type Persistence struct {
 	persistence.KeyValueStore
-	policy retry.TaskRetry
+	prefix   string
+	policy   RetryPolicy
+	interval time.Duration
 }
 
 // Returns the main persistence engine that's used across the framework.
-func NewPersistence(kv persistence.KeyValueStore, maxRetries int) Storage {
+func NewPersistence(kv persistence.KeyValueStore, prefix string, policy RetryPolicy) Storage {
 	return &Persistence{
 		KeyValueStore: kv,
-		policy: retry.TaskRetry{
-			RetryTime:  time.Duration(2),
-			MaxRetries: maxRetries,
-			Backoff:    true,
-		},
+		prefix:        Prefix(prefix),
+		policy:        policy,
+		interval:      time.Second,
 	}
 }
 
-// We're not taking user input for storage policies.
-// Return nil error to satisfy the interface.
-func (p Persistence) AddPolicy(policy *task.TimeRetry, mesosTask *mesos_v1.TaskInfo) error {
-	return nil
+// Normalizes a namespace into a key prefix that starts with a slash and doesn't end with one.
+// An empty namespace or a lone slash means keys aren't namespaced at all.
+func Prefix(namespace string) string {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		return ""
+	}
+
+	return "/" + namespace
+}
+
+func (p Persistence) Create(key, value string) error {
+	return p.KeyValueStore.Create(p.prefix+key, value)
+}
+
+func (p Persistence) CreateWithLease(key, value string, ttl int64) (int64, error) {
+	return p.KeyValueStore.CreateWithLease(p.prefix+key, value, ttl)
+}
+
+func (p Persistence) Read(key string) (string, error) {
+	return p.KeyValueStore.Read(p.prefix + key)
 }
 
-// Returns the current policy that's used by the persistence engine.
-// There is only ever one policy used at a given time.
-func (p Persistence) CheckPolicy(mesosTask *mesos_v1.TaskInfo) *retry.TaskRetry {
-	return &p.policy
+// Reads every key under the given directory, keyed without our prefix.
+func (p Persistence) ReadAll(key string) (map[string]string, error) {
+	all, err := p.KeyValueStore.ReadAll(p.prefix + key)
+	if err != nil || p.prefix == "" {
+		return all, err
+	}
+
+	stripped := make(map[string]string, len(all))
+	for k, v := range all {
+		stripped[strings.TrimPrefix(k, p.prefix)] = v
+	}
+
+	return stripped, nil
 }
 
-// We're not storing policies constructed from user input in memory.
-// Return nil error to satisfy the interface.
-func (p Persistence) ClearPolicy(mesosTask *mesos_v1.TaskInfo) error {
+func (p Persistence) Update(key, value string) error {
+	return p.KeyValueStore.Update(p.prefix+key, value)
+}
+
+func (p Persistence) Delete(key string) error {
+	return p.KeyValueStore.Delete(p.prefix + key)
+}
+
+// Retries the supplied storage operation according to our retry policy.
+// Every call starts from a fresh attempt count and backoff.
+func (p Persistence) Retry(ctx context.Context, f func() error) error {
+	return p.policy.Run(ctx, f)
+}
+
+//
+// Transaction applies every operation or none of them.
+// If the underlying store supports transactions natively they're used directly,
+// subject to any limit the store puts on how many operations one transaction may hold.
+// Otherwise each operation is applied in order while remembering what the key held before,
+// and if any operation fails everything that was applied is undone in reverse order.
+//
+func (p Persistence) Transaction(ops ...Operation) error {
+	if t, ok := p.KeyValueStore.(Transactional); ok {
+		prefixed := make([]Operation, len(ops))
+		for i, op := range ops {
+			prefixed[i] = op
+			prefixed[i].Key = p.prefix + op.Key
+		}
+
+		return t.Transaction(prefixed...)
+	}
+
+	// A failed read is treated as the key not existing, so undoing the operation deletes it.
+	previous := make([]*string, len(ops))
+	for i, op := range ops {
+		if value, err := p.Read(op.Key); err == nil && value != "" {
+			previous[i] = &value
+		}
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.Type {
+		case PUT:
+			err = p.Update(op.Key, op.Value)
+		case DELETE:
+			err = p.Delete(op.Key)
+		default:
+			err = errors.New("Unknown operation type for key " + op.Key)
+		}
+
+		if err != nil {
+			if rollbackErr := p.undo(ops[:i], previous[:i]); rollbackErr != nil {
+				return errors.New("Transaction failed: " + err.Error() + ", rollback failed: " + rollbackErr.Error())
+			}
+
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Runs the supplied policy for storage operations.
-func (p Persistence) RunPolicy(policy *retry.TaskRetry, f func() error) error {
-	if policy.TotalRetries == policy.MaxRetries {
-		return errors.New("Retry limit reached")
+// Restores keys to what they held before the given operations were applied.
+// Every key is attempted even if some fail and the first error is returned.
+func (p Persistence) undo(ops []Operation, previous []*string) error {
+	var first error
+	for i := len(ops) - 1; i >= 0; i-- {
+		var err error
+		if previous[i] != nil {
+			err = p.Update(ops[i].Key, *previous[i])
+		} else {
+			err = p.Delete(ops[i].Key)
+		}
+
+		if err != nil && first == nil {
+			first = err
+		}
 	}
 
-	err := f()
-	policy.TotalRetries += 1
+	return first
+}
+
+//
+// Watch reports every key under the directory that's written or deleted from now on, as the operation that did it.
+// Puts always carry the key's latest value, so applying them in order converges on what's in storage
+// even if some changes in between were never seen.
+// The channel is closed once the context is cancelled or if the watch can't continue,
+// after which callers should read everything again and start a new watch.
+//
+func (p Persistence) Watch(ctx context.Context, key string) <-chan Operation {
+	w, ok := p.KeyValueStore.(Watchable)
+	if !ok {
+		return p.poll(ctx, key)
+	}
+
+	changes := w.Watch(ctx, p.prefix+key)
+	if p.prefix == "" {
+		return changes
+	}
+
+	stripped := make(chan Operation)
+	go func() {
+		defer close(stripped)
+		for op := range changes {
+			op.Key = strings.TrimPrefix(op.Key, p.prefix)
+			select {
+			case stripped <- op:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return stripped
+}
+
+// Finds changes by reading the directory every so often and comparing it against what was there before.
+// Failed reads are skipped since the next one that succeeds will find the same changes.
+func (p Persistence) poll(ctx context.Context, key string) <-chan Operation {
+	changes := make(chan Operation)
+
+	previous, err := p.ReadAll(key)
 	if err != nil {
-		policy.RetryTime = policy.RetryTime * 2
-		time.Sleep(policy.RetryTime)
-		return p.RunPolicy(policy, f)
+		close(changes)
+		return changes
 	}
 
-	return nil
+	go func() {
+		defer close(changes)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := p.ReadAll(key)
+			if err != nil {
+				continue
+			}
+
+			ops := []Operation{}
+			for k, v := range current {
+				if old, ok := previous[k]; !ok || old != v {
+					ops = append(ops, Operation{Type: PUT, Key: k, Value: v})
+				}
+			}
+			for k := range previous {
+				if _, ok := current[k]; !ok {
+					ops = append(ops, Operation{Type: DELETE, Key: k})
+				}
+			}
+
+			for _, op := range ops {
+				select {
+				case changes <- op:
+				case <-ctx.Done():
+					return
+				}
+			}
+			previous = current
+		}
+	}()
+
+	return changes
 }
@@ -0,0 +1,248 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence_test
+
+import (
+	"context"
+	"github.com/verizonlabs/hydrogen/task/persistence"
+	"github.com/verizonlabs/hydrogen/task/persistence/test"
+	sdkPersistence "github.com/verizonlabs/mesos-framework-sdk/persistence"
+	"testing"
+	"time"
+)
+
+func ops() []persistence.Operation {
+	return []persistence.Operation{
+		{Type: persistence.PUT, Key: "/a", Value: "new a"},
+		{Type: persistence.PUT, Key: "/b", Value: "new b"},
+		{Type: persistence.DELETE, Key: "/c"},
+		{Type: persistence.PUT, Key: "/d", Value: "new d"},
+	}
+}
+
+func TestPersistence_Transaction(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/a": "old a", "/c": "old c"}}
+	p := persistence.NewPersistence(kv, "", persistence.RetryPolicy{})
+
+	if err := p.Transaction(ops()...); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(kv.Data) != 3 || kv.Data["/a"] != "new a" || kv.Data["/d"] != "new d" {
+		t.Fatalf("Unexpected data after the transaction: %v", kv.Data)
+	}
+}
+
+// Failing any operation should leave storage exactly as it was.
+func TestPersistence_TransactionRollback(t *testing.T) {
+	for failOn := 1; failOn <= len(ops()); failOn++ {
+		kv := &test.MockFailingKVStore{Data: map[string]string{"/a": "old a", "/c": "old c"}, FailOn: failOn}
+		p := persistence.NewPersistence(kv, "", persistence.RetryPolicy{})
+
+		if err := p.Transaction(ops()...); err == nil {
+			t.Fatalf("Transaction should fail when write %d fails", failOn)
+		}
+		if len(kv.Data) != 2 || kv.Data["/a"] != "old a" || kv.Data["/c"] != "old c" {
+			t.Fatalf("Storage should be rolled back when write %d fails, got %v", failOn, kv.Data)
+		}
+	}
+}
+
+type nativeKV struct {
+	test.MockFailingKVStore
+	called bool
+}
+
+func (n *nativeKV) Transaction(ops ...persistence.Operation) error {
+	n.called = true
+	return nil
+}
+
+// Stores with their own transactions should be used directly.
+func TestPersistence_TransactionNative(t *testing.T) {
+	kv := new(nativeKV)
+	p := persistence.NewPersistence(kv, "", persistence.RetryPolicy{})
+	if err := p.Transaction(ops()...); err != nil || !kv.called {
+		t.Fatal("Native transactions should be used when the store supports them")
+	}
+	if len(kv.Data) != 0 {
+		t.Fatal("Operations should not be emulated when the store supports transactions")
+	}
+}
+
+func TestPersistence_Prefix(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/other/tasks/a": "other"}}
+	p := persistence.NewPersistence(kv, "hydrogen/", persistence.RetryPolicy{})
+
+	if err := p.Update("/tasks/a", "a"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if kv.Data["/hydrogen/tasks/a"] != "a" {
+		t.Fatalf("Keys should be written under the prefix, got %v", kv.Data)
+	}
+
+	all, err := p.ReadAll("/tasks/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(all) != 1 || all["/tasks/a"] != "a" {
+		t.Fatalf("Only our keys should be read back without the prefix, got %v", all)
+	}
+
+	if err := p.Transaction(persistence.Operation{Type: persistence.DELETE, Key: "/tasks/a"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := kv.Data["/hydrogen/tasks/a"]; ok || kv.Data["/other/tasks/a"] != "other" {
+		t.Fatalf("Transactions should only touch our own keys, got %v", kv.Data)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	for namespace, prefix := range map[string]string{"": "", "/": "", "hydrogen": "/hydrogen", "/a/b/": "/a/b"} {
+		if p := persistence.Prefix(namespace); p != prefix {
+			t.Fatalf("Expected %q to become %q, got %q", namespace, prefix, p)
+		}
+	}
+}
+
+func TestMigrateUnprefixed(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{
+		"/tasks/a":             "a",
+		"/tasks/b":             "b",
+		"/hydrogen/tasks/b":    "b",
+		"/tasks/c":             "c",
+		"/hydrogen/tasks/c":    "newer c",
+		"/frameworkId":         "id",
+		"/leader":              "1.2.3.4",
+		"/other/tasks/ignored": "x",
+	}}
+
+	moved, skipped, err := persistence.MigrateUnprefixed(kv, "hydrogen", map[string]int64{"/frameworkId": 60},
+		"/tasks/", "/frameworkId", "/missing")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if moved != 3 || len(skipped) != 1 || skipped[0] != "/tasks/c" {
+		t.Fatalf("Expected 3 keys moved and /tasks/c skipped, got %d and %v", moved, skipped)
+	}
+	if kv.Data["/hydrogen/tasks/a"] != "a" || kv.Data["/hydrogen/frameworkId"] != "id" || kv.Data["/hydrogen/tasks/c"] != "newer c" {
+		t.Fatalf("Unexpected namespaced keys after migrating: %v", kv.Data)
+	}
+	if _, ok := kv.Data["/tasks/a"]; ok {
+		t.Fatal("Migrated keys should be removed from their old location")
+	}
+	if kv.Lease("/hydrogen/frameworkId") == 0 || kv.Lease("/hydrogen/tasks/a") != 0 {
+		t.Fatal("Only the framework ID should be migrated onto a lease")
+	}
+	if kv.Data["/tasks/c"] != "c" || kv.Data["/leader"] != "1.2.3.4" || kv.Data["/other/tasks/ignored"] != "x" {
+		t.Fatalf("Keys that weren't migrated should be left alone: %v", kv.Data)
+	}
+
+	// Running it again has nothing left to do.
+	if moved, _, err := persistence.MigrateUnprefixed(kv, "hydrogen", nil, "/tasks/", "/frameworkId"); err != nil || moved != 0 {
+		t.Fatalf("Expected nothing to migrate the second time, got %d and %v", moved, err)
+	}
+}
+
+// Only state that's entirely outside the namespace still needs migrating.
+func TestUnmigrated(t *testing.T) {
+	for _, c := range []struct {
+		data       map[string]string
+		unmigrated bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"/tasks/a": "a"}, true},
+		{map[string]string{"/frameworkId": "id"}, true},
+		{map[string]string{"/tasks/a": "a", "/hydrogen/frameworkId": "id"}, false},
+		{map[string]string{"/frameworkId": "id", "/hydrogen/tasks/a": "a"}, false},
+		{map[string]string{"/other/tasks/a": "a"}, false},
+	} {
+		kv := &test.MockFailingKVStore{Data: c.data}
+		unmigrated, err := persistence.Unmigrated(kv, "hydrogen", "/frameworkId", "/tasks/")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if unmigrated != c.unmigrated {
+			t.Fatalf("Expected %v to need migrating: %v, got %v", c.data, c.unmigrated, unmigrated)
+		}
+	}
+
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/tasks/a": "a"}}
+	if unmigrated, _ := persistence.Unmigrated(kv, "", "/tasks/"); unmigrated {
+		t.Fatal("Nothing needs migrating without a namespace")
+	}
+}
+
+// Waits for the next change, failing if it takes too long.
+func next(t *testing.T, changes <-chan persistence.Operation) persistence.Operation {
+	select {
+	case op, ok := <-changes:
+		if !ok {
+			t.Fatal("Watch ended early")
+		}
+		return op
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a change")
+	}
+
+	return persistence.Operation{}
+}
+
+// Stores that can watch natively are used directly, with keys outside our prefix left out.
+func TestPersistence_Watch(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{}}
+	p := persistence.NewPersistence(kv, "hydrogen", persistence.RetryPolicy{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	changes := p.Watch(ctx, "/tasks/")
+	kv.Update("/other/tasks/a", "ignored")
+	p.Update("/tasks/a", "a")
+	p.Delete("/tasks/a")
+
+	if op := next(t, changes); op.Type != persistence.PUT || op.Key != "/tasks/a" || op.Value != "a" {
+		t.Fatalf("Unexpected change %+v", op)
+	}
+	if op := next(t, changes); op.Type != persistence.DELETE || op.Key != "/tasks/a" {
+		t.Fatalf("Unexpected change %+v", op)
+	}
+
+	cancel()
+	for range changes {
+	}
+}
+
+// Hides the mock's own watch so changes have to be polled for.
+type pollingKV struct {
+	sdkPersistence.KeyValueStore
+}
+
+func TestPersistence_WatchPolling(t *testing.T) {
+	kv := &test.MockFailingKVStore{Data: map[string]string{"/tasks/a": "a", "/tasks/b": "b"}}
+	p := persistence.NewPersistence(pollingKV{kv}, "", persistence.RetryPolicy{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := p.Watch(ctx, "/tasks/")
+	kv.Update("/tasks/a", "changed")
+	kv.Delete("/tasks/b")
+
+	seen := map[string]persistence.Operation{}
+	for len(seen) < 2 {
+		op := next(t, changes)
+		seen[op.Key] = op
+	}
+	if seen["/tasks/a"].Type != persistence.PUT || seen["/tasks/a"].Value != "changed" || seen["/tasks/b"].Type != persistence.DELETE {
+		t.Fatalf("Unexpected changes %+v", seen)
+	}
+}
@@ -15,16 +15,249 @@
 package test
 
 import (
+	"context"
+	"errors"
+	"github.com/verizonlabs/hydrogen/task/persistence"
 	mockKv "github.com/verizonlabs/mesos-framework-sdk/persistence/drivers/etcd/test"
-	mockRetry "github.com/verizonlabs/mesos-framework-sdk/task/retry/test"
+	"strings"
+	"sync"
 )
 
 type MockStorage struct {
 	mockKv.MockKVStore
-	mockRetry.MockRetry
+}
+
+func (m MockStorage) Transaction(ops ...persistence.Operation) error {
+	return nil
+}
+
+func (m MockStorage) Retry(ctx context.Context, f func() error) error {
+	return f()
+}
+
+// Nothing ever changes so the watch only ends when it's cancelled.
+func (m MockStorage) Watch(ctx context.Context, key string) <-chan persistence.Operation {
+	changes := make(chan persistence.Operation)
+	go func() {
+		<-ctx.Done()
+		close(changes)
+	}()
+
+	return changes
 }
 
 type MockBrokenStorage struct {
 	mockKv.MockBrokenKVStore
-	mockRetry.MockBrokenRetry
+}
+
+func (m MockBrokenStorage) Transaction(ops ...persistence.Operation) error {
+	return errors.New("Broken")
+}
+
+func (m MockBrokenStorage) Retry(ctx context.Context, f func() error) error {
+	return errors.New("Broken")
+}
+
+func (m MockBrokenStorage) Watch(ctx context.Context, key string) <-chan persistence.Operation {
+	changes := make(chan persistence.Operation)
+	close(changes)
+
+	return changes
+}
+
+// In-memory key value store that fails a chosen write.
+// Used to inject failures in the middle of a batch of writes.
+// Creates and leases behave the same as etcd, except that leases only expire when told to.
+// Changes are reported to watchers as they're made.
+type MockFailingKVStore struct {
+	Data     map[string]string
+	FailOn   int // Fail the Nth write, counting from 1. Zero never fails.
+	writes   int
+	leases   map[string]int64
+	expired  map[int64]bool
+	next     int64
+	watchers []*watcher
+	mutex    sync.Mutex
+}
+
+type watcher struct {
+	key     string
+	changes chan persistence.Operation
+}
+
+func (m *MockFailingKVStore) write() error {
+	m.writes++
+	if m.writes == m.FailOn {
+		return errors.New("Injected failure")
+	}
+
+	return nil
+}
+
+// Does nothing if the key already exists.
+func (m *MockFailingKVStore) Create(key, value string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.Data[key]; ok {
+		return nil
+	}
+
+	return m.put(key, value)
+}
+
+func (m *MockFailingKVStore) CreateWithLease(key, value string, ttl int64) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.put(key, value); err != nil {
+		return 0, err
+	}
+	if m.leases == nil {
+		m.leases = make(map[string]int64)
+	}
+	m.next++
+	m.leases[key] = m.next
+
+	return m.next, nil
+}
+
+// Missing keys read back as empty, the same as etcd.
+func (m *MockFailingKVStore) Read(key string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.Data[key], nil
+}
+
+func (m *MockFailingKVStore) ReadAll(key string) (map[string]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	all := make(map[string]string)
+	for k, v := range m.Data {
+		if strings.HasPrefix(k, key) {
+			all[k] = v
+		}
+	}
+
+	return all, nil
+}
+
+func (m *MockFailingKVStore) Update(key, value string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.put(key, value)
+}
+
+func (m *MockFailingKVStore) put(key, value string) error {
+	if err := m.write(); err != nil {
+		return err
+	}
+	if m.Data == nil {
+		m.Data = make(map[string]string)
+	}
+	m.Data[key] = value
+	delete(m.leases, key)
+	m.notify(persistence.Operation{Type: persistence.PUT, Key: key, Value: value})
+
+	return nil
+}
+
+func (m *MockFailingKVStore) RefreshLease(id int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.expired[id] {
+		return errors.New("Lease expired")
+	}
+
+	return nil
+}
+
+// Expires a lease, deleting every key that's bound to it.
+func (m *MockFailingKVStore) Expire(id int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.expired == nil {
+		m.expired = make(map[int64]bool)
+	}
+	m.expired[id] = true
+	for key, lease := range m.leases {
+		if lease == id {
+			delete(m.Data, key)
+			delete(m.leases, key)
+			m.notify(persistence.Operation{Type: persistence.DELETE, Key: key})
+		}
+	}
+}
+
+// Gets the lease a key is bound to, if any.
+func (m *MockFailingKVStore) Lease(key string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.leases[key]
+}
+
+func (m *MockFailingKVStore) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.write(); err != nil {
+		return err
+	}
+	if _, ok := m.Data[key]; ok {
+		delete(m.Data, key)
+		delete(m.leases, key)
+		m.notify(persistence.Operation{Type: persistence.DELETE, Key: key})
+	}
+
+	return nil
+}
+
+// Reports changes under the key until the context is cancelled.
+// Watchers that fall too far behind are closed, the same as a watch that can't continue.
+func (m *MockFailingKVStore) Watch(ctx context.Context, key string) <-chan persistence.Operation {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	w := &watcher{key: key, changes: make(chan persistence.Operation, 1024)}
+	m.watchers = append(m.watchers, w)
+	go func() {
+		<-ctx.Done()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		m.unwatch(w)
+	}()
+
+	return w.changes
+}
+
+func (m *MockFailingKVStore) notify(op persistence.Operation) {
+	for _, w := range append([]*watcher{}, m.watchers...) {
+		if !strings.HasPrefix(op.Key, w.key) {
+			continue
+		}
+
+		select {
+		case w.changes <- op:
+		default:
+			m.unwatch(w)
+		}
+	}
+}
+
+func (m *MockFailingKVStore) unwatch(w *watcher) {
+	for i, other := range m.watchers {
+		if other == w {
+			m.watchers = append(m.watchers[:i], m.watchers[i+1:]...)
+			close(w.changes)
+			return
+		}
+	}
 }
@@ -0,0 +1,402 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ports
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Name of the resource Mesos uses to offer host ports.
+	RESOURCE = "ports"
+
+	// Port labels used to remember what was requested once host ports are filled in.
+	containerLabel = "container"
+	dynamicLabel   = "dynamic"
+)
+
+var NoPortsError = errors.New("Offer does not have enough free ports")
+
+// A port requested by an application.
+// A host port of 0 asks for any free port from the offer.
+// A container port maps the host port into the container's network namespace.
+type PortJSON struct {
+	Name      string `json:"name,omitempty"`
+	Host      uint32 `json:"host"`
+	Container uint32 `json:"container,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+}
+
+//
+// Parse validates the requested ports and turns them into discovery info for the task.
+// The requested host ports are kept as the port numbers, with dynamic ports marked by a label
+// so they can be picked again if the task is ever relaunched.
+//
+func Parse(name string, ports []PortJSON) (*mesos_v1.DiscoveryInfo, error) {
+	if len(ports) == 0 {
+		return nil, nil
+	}
+
+	names := make(map[string]bool)
+	static := make(map[uint32]bool)
+	discovery := &mesos_v1.Ports{}
+	for _, p := range ports {
+		protocol := strings.ToLower(p.Protocol)
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		if protocol != "tcp" && protocol != "udp" {
+			return nil, errors.New("Invalid port protocol " + p.Protocol + ", must be tcp or udp")
+		}
+
+		if p.Name != "" {
+			if names[p.Name] {
+				return nil, errors.New("Port name " + p.Name + " is used more than once")
+			}
+			names[p.Name] = true
+		}
+
+		lbls := []*mesos_v1.Label{}
+		if p.Host == 0 {
+			lbls = append(lbls, label(dynamicLabel, "true"))
+		} else {
+			if static[p.Host] {
+				return nil, errors.New("Host port " + strconv.Itoa(int(p.Host)) + " is requested more than once")
+			}
+			static[p.Host] = true
+		}
+		if p.Container != 0 {
+			lbls = append(lbls, label(containerLabel, strconv.Itoa(int(p.Container))))
+		}
+
+		port := &mesos_v1.Port{
+			Number:   proto.Uint32(p.Host),
+			Protocol: utils.ProtoString(protocol),
+			Labels:   &mesos_v1.Labels{Labels: lbls},
+		}
+		if p.Name != "" {
+			port.Name = utils.ProtoString(p.Name)
+		}
+		discovery.Ports = append(discovery.Ports, port)
+	}
+
+	return &mesos_v1.DiscoveryInfo{
+		Visibility: mesos_v1.DiscoveryInfo_FRAMEWORK.Enum(),
+		Name:       utils.ProtoString(name),
+		Ports:      discovery,
+	}, nil
+}
+
+// Assigned reports the ports of a task, with the host ports that were picked if it has been launched.
+func Assigned(info *mesos_v1.TaskInfo) []PortJSON {
+	ports := []PortJSON{}
+	for _, p := range info.GetDiscovery().GetPorts().GetPorts() {
+		container, _ := strconv.Atoi(labelValue(p, containerLabel))
+		ports = append(ports, PortJSON{
+			Name:      p.GetName(),
+			Host:      p.GetNumber(),
+			Container: uint32(container),
+			Protocol:  p.GetProtocol(),
+		})
+	}
+
+	return ports
+}
+
+// Fits determines if the offer has every host port the task needs.
+func Fits(info *mesos_v1.TaskInfo, offer *mesos_v1.Offer) bool {
+	_, err := pick(info, offer)
+	return err == nil
+}
+
+//
+// Allocate carves the task's host ports out of the offer's port ranges.
+// The task is updated in place: the ports resource is added, the discovery info gets the picked host ports,
+// the environment of the command and of any executor's command gets PORT, PORT0..PORTn and PORT_<NAME> variables,
+// and container ports are mapped on every container network. Any ports from a previous launch are replaced.
+//
+func Allocate(info *mesos_v1.TaskInfo, offer *mesos_v1.Offer) error {
+	requested := info.GetDiscovery().GetPorts().GetPorts()
+	if len(requested) == 0 {
+		return nil
+	}
+
+	picked, err := pick(info, offer)
+	if err != nil {
+		return err
+	}
+
+	// Fill in the host ports without touching the original request.
+	discovery := *info.Discovery
+	discovery.Ports = &mesos_v1.Ports{}
+	for i, p := range requested {
+		port := *p
+		port.Number = proto.Uint32(picked[i])
+		discovery.Ports.Ports = append(discovery.Ports.Ports, &port)
+	}
+	info.Discovery = &discovery
+
+	info.Resources = append(withoutPorts(info.GetResources()), resources(picked, offer)...)
+	if info.Command != nil {
+		info.Command = environment(info.Command, discovery.Ports.Ports)
+	}
+	// Tasks run by our custom executor get their environment from the executor's command instead.
+	if info.Executor.GetCommand() != nil {
+		executor := *info.Executor
+		executor.Command = environment(executor.Command, discovery.Ports.Ports)
+		info.Executor = &executor
+	}
+	if info.Container != nil {
+		info.Container = mappings(info.Container, discovery.Ports.Ports)
+	}
+
+	return nil
+}
+
+// Picks a host port for every requested port.
+// Static ports must be inside the offer's ranges, dynamic ports get the lowest free port left over.
+func pick(info *mesos_v1.TaskInfo, offer *mesos_v1.Offer) ([]uint32, error) {
+	requested := info.GetDiscovery().GetPorts().GetPorts()
+	ranges := offered(offer)
+	used := make(map[uint32]bool)
+	picked := make([]uint32, len(requested))
+
+	for i, p := range requested {
+		if dynamic(p) {
+			continue
+		}
+		if !contains(ranges, p.GetNumber()) {
+			return nil, NoPortsError
+		}
+		used[p.GetNumber()] = true
+		picked[i] = p.GetNumber()
+	}
+
+	for i, p := range requested {
+		if !dynamic(p) {
+			continue
+		}
+
+		port, ok := free(ranges, used)
+		if !ok {
+			return nil, NoPortsError
+		}
+		used[port] = true
+		picked[i] = port
+	}
+
+	return picked, nil
+}
+
+// Gathers every port range the offer has, whatever role it was offered under.
+func offered(offer *mesos_v1.Offer) []*mesos_v1.Value_Range {
+	ranges := []*mesos_v1.Value_Range{}
+	for _, r := range offer.GetResources() {
+		if r.GetName() == RESOURCE && r.GetType() == mesos_v1.Value_RANGES {
+			ranges = append(ranges, r.GetRanges().GetRange()...)
+		}
+	}
+
+	return ranges
+}
+
+func contains(ranges []*mesos_v1.Value_Range, port uint32) bool {
+	for _, r := range ranges {
+		if uint64(port) >= r.GetBegin() && uint64(port) <= r.GetEnd() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Finds the lowest port in the ranges that hasn't been used yet.
+func free(ranges []*mesos_v1.Value_Range, used map[uint32]bool) (uint32, bool) {
+	for _, r := range ranges {
+		for port := r.GetBegin(); port <= r.GetEnd(); port++ {
+			if !used[uint32(port)] {
+				return uint32(port), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+//
+// Builds the ports resources for the picked host ports.
+// Offers can hold ports under several roles, so every port is consumed from the role of the range
+// it was picked from and one resource is returned per role.
+//
+func resources(picked []uint32, offer *mesos_v1.Offer) []*mesos_v1.Resource {
+	sorted := append([]uint32{}, picked...)
+	sort.Sort(byNumber(sorted))
+
+	res := []*mesos_v1.Resource{}
+	byRole := make(map[string]*mesos_v1.Resource)
+	for _, port := range sorted {
+		src := source(port, offer)
+		r, ok := byRole[src.GetRole()]
+		if !ok {
+			r = &mesos_v1.Resource{
+				Name:   utils.ProtoString(RESOURCE),
+				Type:   mesos_v1.Value_RANGES.Enum(),
+				Ranges: &mesos_v1.Value_Ranges{},
+				Role:   src.Role,
+			}
+			byRole[src.GetRole()] = r
+			res = append(res, r)
+		}
+		r.Ranges.Range = append(r.Ranges.Range, &mesos_v1.Value_Range{
+			Begin: proto.Uint64(uint64(port)),
+			End:   proto.Uint64(uint64(port)),
+		})
+	}
+
+	return res
+}
+
+// Finds the offered ports resource the port was picked from.
+func source(port uint32, offer *mesos_v1.Offer) *mesos_v1.Resource {
+	for _, r := range offer.GetResources() {
+		if r.GetName() == RESOURCE && r.GetType() == mesos_v1.Value_RANGES && contains(r.GetRanges().GetRange(), port) {
+			return r
+		}
+	}
+
+	return nil
+}
+
+func withoutPorts(resources []*mesos_v1.Resource) []*mesos_v1.Resource {
+	filtered := []*mesos_v1.Resource{}
+	for _, r := range resources {
+		if r.GetName() != RESOURCE {
+			filtered = append(filtered, r)
+		}
+	}
+
+	return filtered
+}
+
+// Copies the command with the port variables set in its environment.
+func environment(cmd *mesos_v1.CommandInfo, ports []*mesos_v1.Port) *mesos_v1.CommandInfo {
+	set := []*mesos_v1.Environment_Variable{}
+	for i, p := range ports {
+		number := strconv.Itoa(int(p.GetNumber()))
+		if i == 0 {
+			set = append(set, variable("PORT", number))
+		}
+		set = append(set, variable("PORT"+strconv.Itoa(i), number))
+		if p.GetName() != "" {
+			set = append(set, variable("PORT_"+envName(p.GetName()), number))
+		}
+	}
+
+	// Only replace the variables we set, anything else the user gave us is left alone.
+	ours := make(map[string]bool)
+	for _, v := range set {
+		ours[v.GetName()] = true
+	}
+	vars := []*mesos_v1.Environment_Variable{}
+	for _, v := range cmd.GetEnvironment().GetVariables() {
+		if !ours[v.GetName()] {
+			vars = append(vars, v)
+		}
+	}
+	vars = append(vars, set...)
+
+	c := *cmd
+	c.Environment = &mesos_v1.Environment{Variables: vars}
+
+	return &c
+}
+
+// Copies the container with host to container port mappings on each of its networks.
+func mappings(container *mesos_v1.ContainerInfo, ports []*mesos_v1.Port) *mesos_v1.ContainerInfo {
+	if len(container.GetNetworkInfos()) == 0 {
+		return container
+	}
+
+	portMappings := []*mesos_v1.NetworkInfo_PortMapping{}
+	for _, p := range ports {
+		target, err := strconv.Atoi(labelValue(p, containerLabel))
+		if err != nil {
+			continue
+		}
+		portMappings = append(portMappings, &mesos_v1.NetworkInfo_PortMapping{
+			HostPort:      proto.Uint32(p.GetNumber()),
+			ContainerPort: proto.Uint32(uint32(target)),
+			Protocol:      utils.ProtoString(p.GetProtocol()),
+		})
+	}
+
+	c := *container
+	c.NetworkInfos = []*mesos_v1.NetworkInfo{}
+	for _, n := range container.GetNetworkInfos() {
+		network := *n
+		network.PortMappings = portMappings
+		c.NetworkInfos = append(c.NetworkInfos, &network)
+	}
+
+	return &c
+}
+
+func dynamic(p *mesos_v1.Port) bool {
+	return labelValue(p, dynamicLabel) == "true"
+}
+
+func labelValue(p *mesos_v1.Port, key string) string {
+	for _, l := range p.GetLabels().GetLabels() {
+		if l.GetKey() == key {
+			return l.GetValue()
+		}
+	}
+
+	return ""
+}
+
+func label(key, value string) *mesos_v1.Label {
+	return &mesos_v1.Label{Key: utils.ProtoString(key), Value: utils.ProtoString(value)}
+}
+
+func variable(name, value string) *mesos_v1.Environment_Variable {
+	return &mesos_v1.Environment_Variable{Name: utils.ProtoString(name), Value: utils.ProtoString(value)}
+}
+
+// Port names become part of an environment variable so only keep what's valid there.
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+type byNumber []uint32
+
+func (b byNumber) Len() int           { return len(b) }
+func (b byNumber) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNumber) Less(i, j int) bool { return b[i] < b[j] }
@@ -0,0 +1,182 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ports
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"testing"
+)
+
+func offer(begin, end uint64) *mesos_v1.Offer {
+	return &mesos_v1.Offer{
+		Resources: []*mesos_v1.Resource{
+			{
+				Name: utils.ProtoString(RESOURCE),
+				Type: mesos_v1.Value_RANGES.Enum(),
+				Ranges: &mesos_v1.Value_Ranges{
+					Range: []*mesos_v1.Value_Range{{Begin: proto.Uint64(begin), End: proto.Uint64(end)}},
+				},
+			},
+		},
+	}
+}
+
+func taskInfo(t *testing.T, ports ...PortJSON) *mesos_v1.TaskInfo {
+	discovery, err := Parse("test", ports)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return &mesos_v1.TaskInfo{
+		Name:      utils.ProtoString("test"),
+		Command:   &mesos_v1.CommandInfo{Value: utils.ProtoString("echo")},
+		Container: &mesos_v1.ContainerInfo{NetworkInfos: []*mesos_v1.NetworkInfo{{Name: utils.ProtoString("cni")}}},
+		Discovery: discovery,
+	}
+}
+
+func TestParse(t *testing.T) {
+	if d, err := Parse("test", nil); d != nil || err != nil {
+		t.Fatal("No ports should give no discovery info")
+	}
+	if _, err := Parse("test", []PortJSON{{Host: 80, Protocol: "sctp"}}); err == nil {
+		t.Fatal("Unknown protocols should be rejected")
+	}
+	if _, err := Parse("test", []PortJSON{{Name: "a"}, {Name: "a"}}); err == nil {
+		t.Fatal("Duplicate port names should be rejected")
+	}
+	if _, err := Parse("test", []PortJSON{{Host: 80}, {Host: 80}}); err == nil {
+		t.Fatal("Duplicate host ports should be rejected")
+	}
+}
+
+func TestFits(t *testing.T) {
+	info := taskInfo(t, PortJSON{Host: 31005}, PortJSON{})
+	if !Fits(info, offer(31000, 31010)) {
+		t.Fatal("Task should fit an offer with the static port and a free port")
+	}
+	if Fits(info, offer(31005, 31005)) {
+		t.Fatal("Task should not fit when the dynamic port has nowhere to go")
+	}
+	if Fits(info, offer(32000, 32010)) {
+		t.Fatal("Task should not fit when the static port isn't offered")
+	}
+}
+
+// Makes sure ports end up in the resources, environment and network port mappings.
+func TestAllocate(t *testing.T) {
+	info := taskInfo(t, PortJSON{Name: "http-api", Container: 8080}, PortJSON{Host: 31000})
+	if err := Allocate(info, offer(31000, 31010)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assigned := Assigned(info)
+	if len(assigned) != 2 || assigned[0].Host != 31001 || assigned[0].Container != 8080 || assigned[1].Host != 31000 {
+		t.Fatalf("Unexpected assigned ports %v", assigned)
+	}
+
+	env := make(map[string]string)
+	for _, v := range info.GetCommand().GetEnvironment().GetVariables() {
+		env[v.GetName()] = v.GetValue()
+	}
+	if env["PORT"] != "31001" || env["PORT0"] != "31001" || env["PORT1"] != "31000" || env["PORT_HTTP_API"] != "31001" {
+		t.Fatalf("Unexpected port environment %v", env)
+	}
+
+	mappings := info.GetContainer().GetNetworkInfos()[0].GetPortMappings()
+	if len(mappings) != 1 || mappings[0].GetHostPort() != 31001 || mappings[0].GetContainerPort() != 8080 {
+		t.Fatalf("Unexpected port mappings %v", mappings)
+	}
+
+	// Relaunching should pick dynamic ports again instead of keeping the old ones.
+	if err := Allocate(info, offer(31000, 31000)); err != NoPortsError {
+		t.Fatal("Dynamic ports should be picked again on every allocation")
+	}
+	if err := Allocate(info, offer(30999, 31000)); err != nil {
+		t.Fatal(err.Error())
+	}
+	ports := 0
+	for _, r := range info.GetResources() {
+		if r.GetName() == RESOURCE {
+			ports++
+		}
+	}
+	if ports != 1 || len(info.GetCommand().GetEnvironment().GetVariables()) != 4 {
+		t.Fatal("Ports from a previous allocation should be replaced")
+	}
+}
+
+// Variables that only look like ours are left alone.
+func TestAllocateKeepsUserEnvironment(t *testing.T) {
+	info := taskInfo(t, PortJSON{})
+	info.Command.Environment = &mesos_v1.Environment{Variables: []*mesos_v1.Environment_Variable{
+		variable("PORTAL_URL", "http://example.com"),
+		variable("PORT0", "1"),
+	}}
+	if err := Allocate(info, offer(31000, 31010)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	env := make(map[string]string)
+	for _, v := range info.GetCommand().GetEnvironment().GetVariables() {
+		env[v.GetName()] = v.GetValue()
+	}
+	if len(env) != 3 || env["PORTAL_URL"] != "http://example.com" || env["PORT0"] != "31000" {
+		t.Fatalf("Unexpected port environment %v", env)
+	}
+}
+
+// Tasks relaunched under our custom executor get their ports through the executor's environment.
+func TestAllocateExecutor(t *testing.T) {
+	info := taskInfo(t, PortJSON{Name: "http"})
+	info.Executor = &mesos_v1.ExecutorInfo{Command: info.Command}
+	info.Command = nil
+	if err := Allocate(info, offer(31000, 31010)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	env := make(map[string]string)
+	for _, v := range info.GetExecutor().GetCommand().GetEnvironment().GetVariables() {
+		env[v.GetName()] = v.GetValue()
+	}
+	if env["PORT"] != "31000" || env["PORT_HTTP"] != "31000" {
+		t.Fatalf("Unexpected executor port environment %v", env)
+	}
+}
+
+// Ports are consumed from the role they were offered under.
+func TestAllocateRoles(t *testing.T) {
+	o := offer(31000, 31000)
+	reserved := offer(32000, 32010).Resources[0]
+	reserved.Role = utils.ProtoString("web")
+	o.Resources = append(o.Resources, reserved)
+
+	info := taskInfo(t, PortJSON{Host: 31000}, PortJSON{Host: 32005}, PortJSON{})
+	if err := Allocate(info, o); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	roles := make(map[string]int)
+	for _, r := range info.GetResources() {
+		if r.GetName() == RESOURCE {
+			roles[r.GetRole()] += len(r.GetRanges().GetRange())
+		}
+	}
+	if len(roles) != 2 || roles[""] != 1 || roles["web"] != 2 {
+		t.Fatalf("Expected ports split by role, got %v", roles)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"fmt"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/task"
+	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Diagnose explains why a task doesn't fit any of the given offers.
+// Each offer is checked against the task's scalar resources first and then its filters.
+// The first reason an offer was rejected is recorded and offers rejected for the same reason are grouped together.
+// If every offer looks like it should fit, the error from the resource manager is used as the detail.
+//
+func Diagnose(t *manager.Task, offers []*mesos_v1.Offer, assignErr error) []Miss {
+	now := time.Now()
+	if len(offers) == 0 {
+		return []Miss{{Reason: NoOffers, Time: now}}
+	}
+
+	wants := scalars(t.Info.GetResources())
+	grouped := make(map[Reason]*Miss)
+	order := []Reason{}
+	best := make(map[Reason]float64)
+
+	for _, offer := range offers {
+		has := scalars(offer.GetResources())
+		reason, available := check(wants, has)
+		if reason == "" && !Filtered(t.Filters, offer.GetAttributes()) {
+			reason = FilterMismatch
+		}
+		if reason == "" {
+			continue
+		}
+
+		m, ok := grouped[reason]
+		if !ok {
+			m = &Miss{Reason: reason, Time: now}
+			grouped[reason] = m
+			order = append(order, reason)
+		}
+		m.Agents = append(m.Agents, agentName(offer))
+		if available > best[reason] {
+			best[reason] = available
+		}
+	}
+
+	if len(order) == 0 {
+		detail := ""
+		if assignErr != nil {
+			detail = assignErr.Error()
+		}
+		return []Miss{{Reason: Unmatched, Detail: detail, Time: now}}
+	}
+
+	misses := make([]Miss, 0, len(order))
+	for _, reason := range order {
+		m := grouped[reason]
+		switch reason {
+		case InsufficientCpu:
+			m.Detail = fmt.Sprintf("requires %g cpu, largest offer has %g", wants["cpus"], best[reason])
+		case InsufficientMem:
+			m.Detail = fmt.Sprintf("requires %g mem, largest offer has %g", wants["mem"], best[reason])
+		case InsufficientDisk:
+			m.Detail = fmt.Sprintf("requires %g disk, largest offer has %g", wants["disk"], best[reason])
+		}
+		misses = append(misses, *m)
+	}
+
+	return misses
+}
+
+// Returns the first scalar resource that the offer can't satisfy and how much of it the offer has.
+func check(wants, has map[string]float64) (Reason, float64) {
+	if wants["cpus"] > has["cpus"] {
+		return InsufficientCpu, has["cpus"]
+	}
+	if wants["mem"] > has["mem"] {
+		return InsufficientMem, has["mem"]
+	}
+	if wants["disk"] > has["disk"] {
+		return InsufficientDisk, has["disk"]
+	}
+
+	return "", 0
+}
+
+// Sums up scalar resources by name.
+// Both "cpu" and "cpus" are accepted as names for CPU shares.
+func scalars(resources []*mesos_v1.Resource) map[string]float64 {
+	totals := make(map[string]float64)
+	for _, r := range resources {
+		if r.GetType() != mesos_v1.Value_SCALAR {
+			continue
+		}
+
+		name := r.GetName()
+		if name == "cpu" {
+			name = "cpus"
+		}
+		totals[name] += r.GetScalar().GetValue()
+	}
+
+	return totals
+}
+
+//
+// Filtered reports whether the agent attributes satisfy every filter.
+// TEXT, SET and SCALAR filters are matched against attributes of the same type.
+// Any other filter type can't be judged from attributes alone and is treated as satisfied.
+//
+func Filtered(filters []task.Filter, attributes []*mesos_v1.Attribute) bool {
+	for _, f := range filters {
+		var kind mesos_v1.Value_Type
+		switch strings.ToUpper(f.Type) {
+		case "TEXT":
+			kind = mesos_v1.Value_TEXT
+		case "SET":
+			kind = mesos_v1.Value_SET
+		case "SCALAR":
+			kind = mesos_v1.Value_SCALAR
+		default:
+			continue
+		}
+
+		if !hasAttribute(kind, f.Value, attributes) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Determines if any attribute of the given type holds one of the wanted values.
+func hasAttribute(kind mesos_v1.Value_Type, values []string, attributes []*mesos_v1.Attribute) bool {
+	for _, a := range attributes {
+		if a.GetType() != kind {
+			continue
+		}
+
+		var have []string
+		switch kind {
+		case mesos_v1.Value_TEXT:
+			have = []string{a.GetText().GetValue()}
+		case mesos_v1.Value_SET:
+			have = a.GetSet().GetItem()
+		case mesos_v1.Value_SCALAR:
+			have = []string{strconv.FormatFloat(a.GetScalar().GetValue(), 'f', -1, 64)}
+		}
+
+		for _, h := range have {
+			for _, v := range values {
+				if h == v {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// Prefer the hostname for display but fall back to the agent ID.
+func agentName(offer *mesos_v1.Offer) string {
+	if offer.GetHostname() != "" {
+		return offer.GetHostname()
+	}
+
+	return offer.GetAgentId().GetValue()
+}
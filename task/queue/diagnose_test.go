@@ -0,0 +1,104 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"errors"
+	"github.com/verizonlabs/mesos-framework-sdk/include/mesos_v1"
+	"github.com/verizonlabs/mesos-framework-sdk/task"
+	"github.com/verizonlabs/mesos-framework-sdk/task/manager"
+	"github.com/verizonlabs/mesos-framework-sdk/utils"
+	"testing"
+)
+
+func scalar(name string, value float64) *mesos_v1.Resource {
+	return &mesos_v1.Resource{
+		Name:   utils.ProtoString(name),
+		Type:   mesos_v1.Value_SCALAR.Enum(),
+		Scalar: &mesos_v1.Value_Scalar{Value: utils.ProtoFloat64(value)},
+	}
+}
+
+func offer(host string, cpu, mem float64, attributes ...*mesos_v1.Attribute) *mesos_v1.Offer {
+	return &mesos_v1.Offer{
+		Id:         &mesos_v1.OfferID{Value: utils.ProtoString(host)},
+		AgentId:    &mesos_v1.AgentID{Value: utils.ProtoString(host)},
+		Hostname:   utils.ProtoString(host),
+		Resources:  []*mesos_v1.Resource{scalar("cpus", cpu), scalar("mem", mem)},
+		Attributes: attributes,
+	}
+}
+
+func testTask(filters ...task.Filter) *manager.Task {
+	return &manager.Task{
+		Info: &mesos_v1.TaskInfo{
+			Name:      utils.ProtoString("test"),
+			Resources: []*mesos_v1.Resource{scalar("cpu", 1), scalar("mem", 128)},
+		},
+		Filters: filters,
+	}
+}
+
+func TestDiagnose_NoOffers(t *testing.T) {
+	misses := Diagnose(testTask(), nil, nil)
+	if len(misses) != 1 || misses[0].Reason != NoOffers {
+		t.Fatalf("Expected no offers, got %v", misses)
+	}
+}
+
+// Offers failing for the same reason should be grouped together.
+func TestDiagnose_Resources(t *testing.T) {
+	misses := Diagnose(testTask(), []*mesos_v1.Offer{
+		offer("a", 0.5, 4096),
+		offer("b", 0.25, 4096),
+		offer("c", 4, 64),
+	}, nil)
+
+	if len(misses) != 2 {
+		t.Fatalf("Expected 2 grouped reasons, got %v", misses)
+	}
+	if misses[0].Reason != InsufficientCpu || len(misses[0].Agents) != 2 {
+		t.Fatalf("Expected 2 agents short on cpu, got %v", misses[0])
+	}
+	if misses[0].Detail != "requires 1 cpu, largest offer has 0.5" {
+		t.Fatalf("Unexpected detail: %s", misses[0].Detail)
+	}
+	if misses[1].Reason != InsufficientMem || misses[1].Agents[0] != "c" {
+		t.Fatalf("Expected agent c to be short on memory, got %v", misses[1])
+	}
+}
+
+func TestDiagnose_Filters(t *testing.T) {
+	attr := &mesos_v1.Attribute{
+		Name: utils.ProtoString("rack"),
+		Type: mesos_v1.Value_TEXT.Enum(),
+		Text: &mesos_v1.Value_Text{Value: utils.ProtoString("r1")},
+	}
+
+	misses := Diagnose(testTask(task.Filter{Type: "TEXT", Value: []string{"r2"}}), []*mesos_v1.Offer{
+		offer("a", 4, 4096, attr),
+	}, nil)
+	if len(misses) != 1 || misses[0].Reason != FilterMismatch {
+		t.Fatalf("Expected a filter mismatch, got %v", misses)
+	}
+
+	// Everything fits so we fall back to the resource manager's explanation.
+	misses = Diagnose(testTask(task.Filter{Type: "text", Value: []string{"r1"}}), []*mesos_v1.Offer{
+		offer("a", 4, 4096, attr),
+	}, errors.New("no match"))
+	if len(misses) != 1 || misses[0].Reason != Unmatched || misses[0].Detail != "no match" {
+		t.Fatalf("Expected an unmatched reason, got %v", misses)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Describes why a queued task could not be matched with an offer.
+	Reason string
+
+	// A single reason a task didn't match during an offer cycle, along with the agents it applied to.
+	Miss struct {
+		Reason Reason    `json:"reason"`
+		Detail string    `json:"detail,omitempty"`
+		Agents []string  `json:"agents,omitempty"`
+		Time   time.Time `json:"time"`
+	}
+
+	// Launch history for a single task that's waiting to be launched.
+	Entry struct {
+		Name     string    `json:"name"`
+		Queued   time.Time `json:"queued"`
+		Attempts int       `json:"attempts"`
+		Misses   []Miss    `json:"reasons"`
+	}
+
+	// The launch queue keeps track of tasks waiting for offers and why they haven't launched yet.
+	// Only the last N reasons are kept for each task to bound memory usage.
+	LaunchQueue struct {
+		mutex   sync.RWMutex
+		entries map[string]*Entry
+		history int
+	}
+)
+
+const (
+	InsufficientCpu   Reason = "INSUFFICIENT_CPU"
+	InsufficientMem   Reason = "INSUFFICIENT_MEM"
+	InsufficientDisk  Reason = "INSUFFICIENT_DISK"
+	InsufficientPorts Reason = "INSUFFICIENT_PORTS"
+	NoAddresses       Reason = "NO_ADDRESSES"
+	FilterMismatch    Reason = "FILTER_MISMATCH"
+	StrategyConflict  Reason = "STRATEGY_CONFLICT"
+	Quarantined       Reason = "QUARANTINED"
+	NoOffers          Reason = "NO_OFFERS"
+	Unmatched         Reason = "UNMATCHED"
+)
+
+// Returns a new launch queue that remembers the last history reasons per task.
+func NewLaunchQueue(history int) *LaunchQueue {
+	if history < 1 {
+		history = 1
+	}
+
+	return &LaunchQueue{
+		entries: make(map[string]*Entry),
+		history: history,
+	}
+}
+
+// Starts tracking a task as waiting to be launched.
+// Tasks that are already being tracked keep their original queue time.
+func (q *LaunchQueue) Enqueue(name string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.enqueue(name)
+}
+
+// Records a failed launch attempt for the task along with the reasons it didn't match.
+func (q *LaunchQueue) Miss(name string, misses ...Miss) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	e := q.enqueue(name)
+	e.Attempts++
+	e.Misses = append(e.Misses, misses...)
+	if over := len(e.Misses) - q.history; over > 0 {
+		e.Misses = append([]Miss{}, e.Misses[over:]...)
+	}
+}
+
+// Stops tracking a task, either because it was launched or because it was removed.
+func (q *LaunchQueue) Remove(name string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	delete(q.entries, name)
+}
+
+// Gets a copy of the launch history for the given task.
+func (q *LaunchQueue) Get(name string) (Entry, bool) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	e, ok := q.entries[name]
+	if !ok {
+		return Entry{}, false
+	}
+
+	return copyEntry(e), true
+}
+
+// Gets a copy of every tracked task, oldest first.
+func (q *LaunchQueue) Entries() []Entry {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	entries := make([]Entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, copyEntry(e))
+	}
+	sort.Sort(byQueued(entries))
+
+	return entries
+}
+
+// Must be called with the lock held.
+func (q *LaunchQueue) enqueue(name string) *Entry {
+	e, ok := q.entries[name]
+	if !ok {
+		e = &Entry{Name: name, Queued: time.Now()}
+		q.entries[name] = e
+	}
+
+	return e
+}
+
+func copyEntry(e *Entry) Entry {
+	c := *e
+	c.Misses = append([]Miss{}, e.Misses...)
+
+	return c
+}
+
+// Sorts entries by the time they were queued.
+type byQueued []Entry
+
+func (b byQueued) Len() int           { return len(b) }
+func (b byQueued) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byQueued) Less(i, j int) bool { return b[i].Queued.Before(b[j].Queued) }
@@ -0,0 +1,67 @@
+// Copyright 2017 Verizon
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queue
+
+import (
+	"testing"
+)
+
+func TestNewLaunchQueue(t *testing.T) {
+	q := NewLaunchQueue(0)
+	if q == nil || q.history != 1 {
+		t.Fatal("Launch queue should always keep at least one reason")
+	}
+}
+
+// Ensures we only keep the configured number of reasons and count every attempt.
+func TestLaunchQueue_Miss(t *testing.T) {
+	q := NewLaunchQueue(2)
+	q.Miss("test", Miss{Reason: InsufficientCpu})
+	q.Miss("test", Miss{Reason: InsufficientMem})
+	q.Miss("test", Miss{Reason: FilterMismatch})
+
+	e, ok := q.Get("test")
+	if !ok {
+		t.Fatal("Task should be tracked after a miss")
+	}
+	if e.Attempts != 3 {
+		t.Fatalf("Expected 3 attempts, got %d", e.Attempts)
+	}
+	if len(e.Misses) != 2 || e.Misses[0].Reason != InsufficientMem || e.Misses[1].Reason != FilterMismatch {
+		t.Fatalf("Expected the last 2 reasons to be kept, got %v", e.Misses)
+	}
+}
+
+// Makes sure enqueueing doesn't reset the time a task has been waiting.
+func TestLaunchQueue_EnqueueRemove(t *testing.T) {
+	q := NewLaunchQueue(1)
+	q.Enqueue("test")
+	first, _ := q.Get("test")
+	q.Enqueue("test")
+	second, _ := q.Get("test")
+	if !first.Queued.Equal(second.Queued) {
+		t.Fatal("Queue time should not change when a task is enqueued again")
+	}
+
+	q.Enqueue("other")
+	if entries := q.Entries(); len(entries) != 2 || entries[0].Name != "test" {
+		t.Fatalf("Expected 2 entries oldest first, got %v", entries)
+	}
+
+	q.Remove("test")
+	if _, ok := q.Get("test"); ok {
+		t.Fatal("Task should no longer be tracked after removal")
+	}
+}